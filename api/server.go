@@ -0,0 +1,341 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gethmate/eth"
+	"gethmate/graph"
+)
+
+// View is the state served by the API: a graph Copy whose reserves all
+// reflect one block, with the prices computed from it
+type View struct {
+	Block         graph.BlockRef
+	Graph         *graph.Graph
+	Prices        *graph.Pricer
+	Opportunities []graph.OpportunityRecord // Valid opportunities on the latest block that had any
+	Found         []graph.OpportunityRecord // Opportunities found on Block, streamed to subscribers
+	UpdatedAt     time.Time
+}
+
+// Server answers read-only queries about the latest published View, and
+// streams each view to websocket subscribers. Until a view is published every
+// query responds 503.
+type Server struct {
+	view   atomic.Pointer[View]
+	stream *stream
+}
+
+func NewServer() *Server {
+	return &Server{stream: newStream()}
+}
+
+// Publish a view, replacing the one served, and stream its opportunities and
+// the reserves that changed since the previous view. The view must not be
+// modified afterwards.
+func (s *Server) Publish(view *View) {
+	prev := s.view.Swap(view)
+	if s.stream.clientCount() > 0 {
+		s.stream.broadcast(newBlockMessage(view, prev))
+	}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stats", s.withView(s.handleStats))
+	mux.HandleFunc("GET /tokens/{address}/pools", s.withView(s.handleTokenPools))
+	mux.HandleFunc("GET /pools/{address}", s.withView(s.handlePool))
+	mux.HandleFunc("GET /pools/{address}/quote", s.withView(s.handleQuote))
+	mux.HandleFunc("GET /prices/{address}", s.withView(s.handlePrice))
+	mux.HandleFunc("GET /opportunities", s.withView(s.handleOpportunities))
+	mux.HandleFunc("GET /stream", s.stream.handle)
+	return mux
+}
+
+// Serve the API on addr until ctx is cancelled
+func (s *Server) Serve(ctx context.Context, addr string) error {
+	server := &http.Server{Addr: addr, Handler: s.Handler(), ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		// Shutdown does not close hijacked websocket connections
+		s.stream.close()
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+type viewHandler func(w http.ResponseWriter, r *http.Request, view *View)
+
+// Load the current view once per request, so that a response never mixes
+// two views
+func (s *Server) withView(handler viewHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		view := s.view.Load()
+		if view == nil {
+			writeError(w, http.StatusServiceUnavailable, "no consistent view of the graph yet")
+			return
+		}
+		handler(w, r, view)
+	}
+}
+
+type StatsResponse struct {
+	Block     graph.BlockRef `json:"block"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	graph.Stats
+	ETHUSD string `json:"eth_usd,omitempty"`
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request, view *View) {
+	writeJSON(w, StatsResponse{
+		Block:     view.Block,
+		UpdatedAt: view.UpdatedAt,
+		Stats:     view.Graph.Stats(),
+		ETHUSD:    formatFloat(view.Prices.ETHUSD()),
+	})
+}
+
+type TokenResponse struct {
+	Address  string `json:"address"`
+	Symbol   string `json:"symbol"`
+	Decimals int    `json:"decimals"`
+}
+
+// TokenReserve is a pool's balance of one of its tokens, in whole tokens
+type TokenReserve struct {
+	TokenResponse
+	Reserve string `json:"reserve"`
+}
+
+// SpotPrice is the price of one whole TokenIn in TokenOut, before fees
+type SpotPrice struct {
+	TokenIn  string `json:"token_in"`
+	TokenOut string `json:"token_out"`
+	Price    string `json:"price"`
+}
+
+type PoolResponse struct {
+	Address    string          `json:"address"`
+	Type       string          `json:"type"`
+	Block      *graph.BlockRef `json:"block,omitempty"`
+	Tokens     []TokenReserve  `json:"tokens"`
+	SpotPrices []SpotPrice     `json:"spot_prices"`
+}
+
+func (s *Server) handleTokenPools(w http.ResponseWriter, r *http.Request, view *View) {
+	address := r.PathValue("address")
+	if view.Graph.GetNode(address) == nil {
+		writeError(w, http.StatusNotFound, "token not in graph")
+		return
+	}
+	pools := view.Graph.GetTokenPools(address)
+	response := make([]PoolResponse, len(pools))
+	for i, pool := range pools {
+		response[i] = poolResponse(view.Graph, pool)
+	}
+	writeJSON(w, response)
+}
+
+func (s *Server) handlePool(w http.ResponseWriter, r *http.Request, view *View) {
+	pool := view.Graph.GetPool(r.PathValue("address"))
+	if pool == nil {
+		writeError(w, http.StatusNotFound, "pool not in graph")
+		return
+	}
+	writeJSON(w, poolResponse(view.Graph, pool))
+}
+
+func poolResponse(g *graph.Graph, pool eth.Pool) PoolResponse {
+	response := PoolResponse{
+		Address:    pool.GetAddress().String(),
+		Type:       eth.GetPoolType(pool),
+		Tokens:     make([]TokenReserve, 0),
+		SpotPrices: make([]SpotPrice, 0),
+	}
+	if block, exists := g.GetReserveBlock(response.Address); exists {
+		response.Block = &block
+	}
+	tokens := pool.GetTokens()
+	for _, token := range tokens {
+		response.Tokens = append(response.Tokens, TokenReserve{
+			TokenResponse: tokenResponse(token),
+			Reserve:       graph.FormatUnits(pool.GetReserve(token), token.Decimals),
+		})
+	}
+	for _, tokenIn := range tokens {
+		for _, tokenOut := range tokens {
+			if tokenIn == tokenOut {
+				continue
+			}
+			response.SpotPrices = append(response.SpotPrices, SpotPrice{
+				TokenIn:  tokenIn.ContractAddress.String(),
+				TokenOut: tokenOut.ContractAddress.String(),
+				Price:    formatFloat(pool.GetSpotPrice(tokenIn, tokenOut)),
+			})
+		}
+	}
+	return response
+}
+
+type QuoteResponse struct {
+	Pool      string          `json:"pool"`
+	Block     *graph.BlockRef `json:"block,omitempty"`
+	TokenIn   TokenResponse   `json:"token_in"`
+	TokenOut  TokenResponse   `json:"token_out"`
+	AmountIn  string          `json:"amount_in"`  // Whole tokens
+	AmountOut string          `json:"amount_out"` // Whole tokens, after fees
+	SpotPrice string          `json:"spot_price"`
+}
+
+// Quote a swap through a pool:
+// /pools/{address}/quote?token_in=...&token_out=...&amount_in=1.5
+func (s *Server) handleQuote(w http.ResponseWriter, r *http.Request, view *View) {
+	pool := view.Graph.GetPool(r.PathValue("address"))
+	if pool == nil {
+		writeError(w, http.StatusNotFound, "pool not in graph")
+		return
+	}
+	query := r.URL.Query()
+	tokenIn := poolToken(pool, query.Get("token_in"))
+	tokenOut := poolToken(pool, query.Get("token_out"))
+	if tokenIn == nil || tokenOut == nil || tokenIn == tokenOut {
+		writeError(w, http.StatusBadRequest, "token_in and token_out must be two different tokens of the pool")
+		return
+	}
+	amountIn, ok := new(big.Float).SetString(query.Get("amount_in"))
+	if !ok || amountIn.Sign() <= 0 || amountIn.IsInf() {
+		writeError(w, http.StatusBadRequest, "amount_in must be a positive number of whole tokens")
+		return
+	}
+
+	amountOut := pool.GetAmountOut(tokenIn, tokenOut, graph.ToUnits(amountIn, tokenIn.Decimals))
+	response := QuoteResponse{
+		Pool:      pool.GetAddress().String(),
+		TokenIn:   tokenResponse(tokenIn),
+		TokenOut:  tokenResponse(tokenOut),
+		AmountIn:  amountIn.Text('f', -1),
+		AmountOut: graph.FormatUnits(amountOut, tokenOut.Decimals),
+		SpotPrice: formatFloat(pool.GetSpotPrice(tokenIn, tokenOut)),
+	}
+	if block, exists := view.Graph.GetReserveBlock(response.Pool); exists {
+		response.Block = &block
+	}
+	writeJSON(w, response)
+}
+
+func poolToken(pool eth.Pool, address string) *eth.ERC20Token {
+	if address == "" {
+		return nil
+	}
+	for _, token := range pool.GetTokens() {
+		if strings.EqualFold(token.ContractAddress.String(), address) {
+			return token
+		}
+	}
+	return nil
+}
+
+type PriceResponse struct {
+	Token      TokenResponse  `json:"token"`
+	Block      graph.BlockRef `json:"block"`
+	ETH        string         `json:"eth"`
+	USD        string         `json:"usd,omitempty"`
+	Confidence float64        `json:"confidence"`
+	Hops       int            `json:"hops"`
+}
+
+func (s *Server) handlePrice(w http.ResponseWriter, r *http.Request, view *View) {
+	node := view.Graph.GetNode(r.PathValue("address"))
+	if node == nil {
+		writeError(w, http.StatusNotFound, "token not in graph")
+		return
+	}
+	price, exists := view.Prices.PriceOf(node.Token)
+	if !exists {
+		writeError(w, http.StatusNotFound, "token is not connected to WETH")
+		return
+	}
+	writeJSON(w, PriceResponse{
+		Token:      tokenResponse(node.Token),
+		Block:      view.Block,
+		ETH:        formatFloat(price.ETH),
+		USD:        formatFloat(price.USD),
+		Confidence: price.Confidence,
+		Hops:       price.Hops,
+	})
+}
+
+// List the valid opportunities found on the latest block that had any, most
+// profitable first: /opportunities?limit=10
+func (s *Server) handleOpportunities(w http.ResponseWriter, r *http.Request, view *View) {
+	limit := 10
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		limit = parsed
+	}
+
+	records := append(make([]graph.OpportunityRecord, 0), view.Opportunities...)
+	sort.SliceStable(records, func(i, j int) bool {
+		return profit(records[i]).Cmp(profit(records[j])) > 0
+	})
+	if len(records) > limit {
+		records = records[:limit]
+	}
+	writeJSON(w, records)
+}
+
+// Net profit of a record, or zero if it was not evaluated
+func profit(record graph.OpportunityRecord) *big.Float {
+	if value, ok := new(big.Float).SetString(record.NetProfit); ok {
+		return value
+	}
+	return new(big.Float)
+}
+
+func tokenResponse(token *eth.ERC20Token) TokenResponse {
+	return TokenResponse{
+		Address:  token.ContractAddress.String(),
+		Symbol:   token.Symbol,
+		Decimals: token.Decimals,
+	}
+}
+
+// Format a price, empty if it is unknown
+func formatFloat(value *big.Float) string {
+	if value == nil {
+		return ""
+	}
+	return value.Text('g', 18)
+}
+
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		slog.Warn("Failed to write api response", "err", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
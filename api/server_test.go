@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"gethmate/eth"
+	"gethmate/graph"
+	"gethmate/internal/testutil"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func get(t *testing.T, handler http.Handler, path string, status int, response any) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	if recorder.Code != status {
+		t.Fatalf("GET %s: expected status %d, got %d: %s", path, status, recorder.Code, recorder.Body)
+	}
+	if response != nil {
+		if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+			t.Fatalf("GET %s: failed to decode response: %v", path, err)
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	fmt.Println("TestServer")
+	weth := testutil.NewToken("WETH", 18)
+	dai := testutil.NewToken("DAI", 18)
+	usdc := testutil.NewToken("USDC", 6)
+	wethDAI := testutil.NewPool("0x0000000000000000000000000000000000000001", weth, dai, testutil.Ether(1_000), testutil.Ether(3_000_000))
+	daiUSDC := testutil.NewPool("0x0000000000000000000000000000000000000002", dai, usdc, testutil.Ether(1_000_000), big.NewInt(1_000_000e6))
+	g := graph.NewGraph()
+	g.AddPool(wethDAI)
+	g.AddPool(daiUSDC)
+	block := graph.BlockRef{Number: 100, Hash: common.HexToHash("0x64")}
+	g.ReserveBlocks["0x0000000000000000000000000000000000000001"] = block
+	g.ReserveBlocks["0x0000000000000000000000000000000000000002"] = block
+
+	server := NewServer()
+	handler := server.Handler()
+	get(t, handler, "/stats", http.StatusServiceUnavailable, nil)
+
+	prices := graph.NewPricer()
+	if err := prices.Update(g); err != nil {
+		t.Fatalf("Failed to update prices: %v", err)
+	}
+	opportunities := graph.NewOpportunityBook(4)
+	for _, profit := range []int64{1, 3, 2} {
+		opportunities.Add(&graph.Opportunity{
+			Block:     block,
+			Start:     g.GetNode(graph.WETHAddress),
+			Path:      []*graph.Edge{},
+			AmountIn:  big.NewFloat(1),
+			NetProfit: big.NewInt(profit),
+		})
+	}
+	server.Publish(&View{Block: block, Graph: g, Prices: prices, Opportunities: opportunities.LatestRecords(), UpdatedAt: time.Now()})
+
+	var stats StatsResponse
+	get(t, handler, "/stats", http.StatusOK, &stats)
+	if stats.Block != block || stats.Nodes != 3 || stats.Pools != 2 || stats.Edges != 2 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if ethUSD, _ := strconv.ParseFloat(stats.ETHUSD, 64); math.Abs(ethUSD-3000) > 1e-6 {
+		t.Errorf("Expected ETH at 3000 USD, got %s", stats.ETHUSD)
+	}
+
+	var pools []PoolResponse
+	get(t, handler, "/tokens/"+dai.ContractAddress.String()+"/pools", http.StatusOK, &pools)
+	if len(pools) != 2 || pools[0].Address != wethDAI.ContractAddress.String() {
+		t.Fatalf("Expected both pools for DAI, got %+v", pools)
+	}
+	get(t, handler, "/tokens/0x0000000000000000000000000000000000000009/pools", http.StatusNotFound, nil)
+
+	var pool PoolResponse
+	get(t, handler, "/pools/0x0000000000000000000000000000000000000002", http.StatusOK, &pool)
+	if pool.Type != eth.UniswapPoolType || pool.Block == nil || *pool.Block != block {
+		t.Errorf("Unexpected pool %+v", pool)
+	}
+	if len(pool.Tokens) != 2 || pool.Tokens[1].Symbol != "USDC" || pool.Tokens[1].Reserve != "1000000.000000" {
+		t.Errorf("Unexpected reserves %+v", pool.Tokens)
+	}
+	if len(pool.SpotPrices) != 2 {
+		t.Errorf("Expected a spot price in each direction, got %+v", pool.SpotPrices)
+	}
+	get(t, handler, "/pools/0x0000000000000000000000000000000000000009", http.StatusNotFound, nil)
+
+	var quote QuoteResponse
+	path := fmt.Sprintf("/pools/%s/quote?token_in=%s&token_out=%s&amount_in=1",
+		wethDAI.ContractAddress, weth.ContractAddress, dai.ContractAddress)
+	get(t, handler, path, http.StatusOK, &quote)
+	expected := wethDAI.GetAmountOut(weth, dai, testutil.Ether(1))
+	if quote.AmountOut != graph.FormatUnits(expected, 18) || quote.TokenOut.Symbol != "DAI" {
+		t.Errorf("Expected %s DAI out, got %+v", graph.FormatUnits(expected, 18), quote)
+	}
+	get(t, handler, fmt.Sprintf("/pools/%s/quote?token_in=%s&token_out=%s&amount_in=1",
+		wethDAI.ContractAddress, weth.ContractAddress, usdc.ContractAddress), http.StatusBadRequest, nil)
+	get(t, handler, fmt.Sprintf("/pools/%s/quote?token_in=%s&token_out=%s&amount_in=-1",
+		wethDAI.ContractAddress, weth.ContractAddress, dai.ContractAddress), http.StatusBadRequest, nil)
+	get(t, handler, fmt.Sprintf("/pools/%s/quote?token_in=%s&token_out=%s&amount_in=Inf",
+		wethDAI.ContractAddress, weth.ContractAddress, dai.ContractAddress), http.StatusBadRequest, nil)
+
+	var price PriceResponse
+	get(t, handler, "/prices/"+usdc.ContractAddress.String(), http.StatusOK, &price)
+	if price.Token.Symbol != "USDC" || price.Hops != 2 || price.USD == "" {
+		t.Errorf("Unexpected price %+v", price)
+	}
+
+	var records []graph.OpportunityRecord
+	get(t, handler, "/opportunities?limit=2", http.StatusOK, &records)
+	if len(records) != 2 || records[0].NetProfit != "0.000000000000000003" || records[1].NetProfit != "0.000000000000000002" {
+		t.Errorf("Expected the two most profitable opportunities, got %+v", records)
+	}
+	get(t, handler, "/opportunities?limit=0", http.StatusBadRequest, nil)
+}
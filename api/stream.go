@@ -0,0 +1,305 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gethmate/eth"
+	"gethmate/graph"
+
+	"github.com/gorilla/websocket"
+)
+
+// Messages queued for a client beyond this are dropped along with the client
+const streamBuffer = 16
+
+const (
+	writeTimeout = 10 * time.Second
+	pingInterval = 30 * time.Second
+	pongTimeout  = 2 * pingInterval
+)
+
+// BlockMessage is streamed for every published view, filtered per client
+type BlockMessage struct {
+	Block         graph.BlockRef            `json:"block"`
+	Opportunities []graph.OpportunityRecord `json:"opportunities"`
+	Reserves      []PoolReserves            `json:"reserves"` // Pools whose reserves changed since the previous block
+}
+
+type PoolReserves struct {
+	Pool   string         `json:"pool"`
+	Tokens []TokenReserve `json:"tokens"`
+}
+
+// Filter is what a client subscribes to. It is set from the query string
+// when connecting, and replaced by any Filter the client sends as JSON.
+type Filter struct {
+	MinProfit string   `json:"min_profit,omitempty"` // Net profit in whole start tokens
+	Tokens    []string `json:"tokens,omitempty"`     // Only opportunities and pools involving one of these
+	MaxHops   int      `json:"max_hops,omitempty"`
+}
+
+type filter struct {
+	minProfit *big.Float
+	tokens    map[string]bool
+	maxHops   int
+}
+
+func (f Filter) compile() (*filter, error) {
+	compiled := &filter{maxHops: f.MaxHops}
+	if f.MinProfit != "" {
+		minProfit, ok := new(big.Float).SetString(f.MinProfit)
+		if !ok {
+			return nil, fmt.Errorf("invalid min_profit %q", f.MinProfit)
+		}
+		compiled.minProfit = minProfit
+	}
+	if f.MaxHops < 0 {
+		return nil, fmt.Errorf("invalid max_hops %d", f.MaxHops)
+	}
+	if len(f.Tokens) > 0 {
+		compiled.tokens = make(map[string]bool, len(f.Tokens))
+		for _, token := range f.Tokens {
+			compiled.tokens[strings.ToLower(strings.TrimSpace(token))] = true
+		}
+	}
+	return compiled, nil
+}
+
+func (f *filter) matchesOpportunity(record graph.OpportunityRecord) bool {
+	if f.maxHops > 0 && len(record.Pools) > f.maxHops {
+		return false
+	}
+	if f.minProfit != nil {
+		profit, ok := new(big.Float).SetString(record.NetProfit)
+		if !ok || profit.Cmp(f.minProfit) < 0 {
+			return false
+		}
+	}
+	return f.matchesAnyToken(record.Tokens)
+}
+
+func (f *filter) matchesAnyToken(addresses []string) bool {
+	if f.tokens == nil {
+		return true
+	}
+	for _, address := range addresses {
+		if f.tokens[strings.ToLower(address)] {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *filter) apply(message *BlockMessage) *BlockMessage {
+	filtered := &BlockMessage{
+		Block:         message.Block,
+		Opportunities: make([]graph.OpportunityRecord, 0),
+		Reserves:      make([]PoolReserves, 0),
+	}
+	for _, record := range message.Opportunities {
+		if f.matchesOpportunity(record) {
+			filtered.Opportunities = append(filtered.Opportunities, record)
+		}
+	}
+	for _, reserves := range message.Reserves {
+		addresses := make([]string, len(reserves.Tokens))
+		for i, token := range reserves.Tokens {
+			addresses[i] = token.Address
+		}
+		if f.matchesAnyToken(addresses) {
+			filtered.Reserves = append(filtered.Reserves, reserves)
+		}
+	}
+	return filtered
+}
+
+// stream fans block messages out to websocket clients. A client that falls
+// more than streamBuffer messages behind is disconnected rather than
+// holding up the others.
+type stream struct {
+	mu       sync.Mutex
+	clients  map[*streamClient]bool
+	upgrader websocket.Upgrader
+}
+
+type streamClient struct {
+	conn     *websocket.Conn
+	messages chan *BlockMessage
+	mu       sync.Mutex
+	filter   *filter
+}
+
+func newStream() *stream {
+	return &stream{
+		clients: make(map[*streamClient]bool),
+		// The feed is read-only, so it may be consumed from any origin
+		upgrader: websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }},
+	}
+}
+
+// Build the message for view, with the reserves that changed since prev
+func newBlockMessage(view, prev *View) *BlockMessage {
+	message := &BlockMessage{
+		Block:         view.Block,
+		Opportunities: view.Found,
+		Reserves:      make([]PoolReserves, 0),
+	}
+	if message.Opportunities == nil {
+		message.Opportunities = make([]graph.OpportunityRecord, 0)
+	}
+	var pools []eth.Pool
+	if prev == nil {
+		pools = view.Graph.ChangedPools(graph.NewGraph())
+	} else {
+		pools = view.Graph.ChangedPools(prev.Graph)
+	}
+	for _, pool := range pools {
+		reserves := PoolReserves{Pool: pool.GetAddress().String(), Tokens: make([]TokenReserve, 0)}
+		for _, token := range pool.GetTokens() {
+			reserves.Tokens = append(reserves.Tokens, TokenReserve{
+				TokenResponse: tokenResponse(token),
+				Reserve:       graph.FormatUnits(pool.GetReserve(token), token.Decimals),
+			})
+		}
+		message.Reserves = append(message.Reserves, reserves)
+	}
+	return message
+}
+
+func (s *stream) clientCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.clients)
+}
+
+func (s *stream) broadcast(message *BlockMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for client := range s.clients {
+		select {
+		case client.messages <- message:
+		default:
+			slog.Warn("Dropping slow stream client", "remote", client.conn.RemoteAddr(), "block", message.Block.Number)
+			s.remove(client)
+		}
+	}
+}
+
+// Must be called with s.mu held
+func (s *stream) remove(client *streamClient) {
+	if s.clients[client] {
+		delete(s.clients, client)
+		close(client.messages)
+	}
+}
+
+// Stream block messages: /stream?min_profit=0.01&tokens=0x...,0x...&max_hops=3
+func (s *stream) handle(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	initial := Filter{MinProfit: query.Get("min_profit")}
+	if tokens := query.Get("tokens"); tokens != "" {
+		initial.Tokens = strings.Split(tokens, ",")
+	}
+	if maxHops := query.Get("max_hops"); maxHops != "" {
+		parsed, err := strconv.Atoi(maxHops)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "max_hops must be an integer")
+			return
+		}
+		initial.MaxHops = parsed
+	}
+	compiled, err := initial.compile()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // The upgrader has already responded
+	}
+	client := &streamClient{conn: conn, messages: make(chan *BlockMessage, streamBuffer), filter: compiled}
+	s.mu.Lock()
+	s.clients[client] = true
+	s.mu.Unlock()
+	slog.Info("Stream client connected", "remote", conn.RemoteAddr())
+
+	go client.writeLoop()
+	client.readLoop()
+	s.mu.Lock()
+	s.remove(client)
+	s.mu.Unlock()
+	slog.Info("Stream client disconnected", "remote", conn.RemoteAddr())
+}
+
+// Read filter updates until the connection fails or is closed
+func (c *streamClient) readLoop() {
+	c.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var update Filter
+		if err := json.Unmarshal(data, &update); err != nil {
+			slog.Debug("Ignoring invalid stream filter", "remote", c.conn.RemoteAddr(), "err", err)
+			continue
+		}
+		compiled, err := update.compile()
+		if err != nil {
+			slog.Debug("Ignoring invalid stream filter", "remote", c.conn.RemoteAddr(), "err", err)
+			continue
+		}
+		c.mu.Lock()
+		c.filter = compiled
+		c.mu.Unlock()
+	}
+}
+
+// Write filtered messages and pings until the client is removed
+func (c *streamClient) writeLoop() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	defer c.conn.Close()
+	for {
+		select {
+		case message, ok := <-c.messages:
+			c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			c.mu.Lock()
+			filtered := c.filter.apply(message)
+			c.mu.Unlock()
+			if err := c.conn.WriteJSON(filtered); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Disconnect every client
+func (s *stream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for client := range s.clients {
+		s.remove(client)
+	}
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"fmt"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gethmate/eth"
+	"gethmate/graph"
+	"gethmate/internal/testutil"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gorilla/websocket"
+)
+
+func TestStream(t *testing.T) {
+	fmt.Println("TestStream")
+	weth := testutil.NewToken("WETH", 18)
+	dai := testutil.NewToken("DAI", 18)
+	usdc := testutil.NewToken("USDC", 6)
+	wethDAI := testutil.NewPool("0x0000000000000000000000000000000000000001", weth, dai, testutil.Ether(1_000), testutil.Ether(3_000_000))
+	daiUSDC := testutil.NewPool("0x0000000000000000000000000000000000000002", dai, usdc, testutil.Ether(1_000_000), big.NewInt(1_000_000e6))
+	g := graph.NewGraph()
+	g.AddPool(wethDAI)
+	g.AddPool(daiUSDC)
+
+	server := NewServer()
+	httpServer := httptest.NewServer(server.Handler())
+	defer httpServer.Close()
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/stream?max_hops=2&tokens=" + usdc.ContractAddress.String()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to stream: %v", err)
+	}
+	defer conn.Close()
+	for server.stream.clientCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	record := func(symbols []string, tokens ...string) graph.OpportunityRecord {
+		return graph.OpportunityRecord{Symbols: symbols, Tokens: tokens, Pools: make([]string, len(tokens)-1), NetProfit: "0.5"}
+	}
+	wethAddress, daiAddress, usdcAddress := weth.ContractAddress.String(), dai.ContractAddress.String(), usdc.ContractAddress.String()
+	block := graph.BlockRef{Number: 1, Hash: common.HexToHash("0x01")}
+	server.Publish(&View{Block: block, Graph: g.Copy(), Prices: graph.NewPricer(), Found: []graph.OpportunityRecord{
+		record([]string{"WETH", "DAI", "WETH"}, wethAddress, daiAddress, wethAddress),
+		record([]string{"WETH", "DAI", "USDC", "WETH"}, wethAddress, daiAddress, usdcAddress, wethAddress),
+		record([]string{"USDC", "DAI", "USDC"}, usdcAddress, daiAddress, usdcAddress),
+	}})
+
+	// The first message has every pool; only the USDC pool passes the filter
+	var message BlockMessage
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&message); err != nil {
+		t.Fatalf("Failed to read message: %v", err)
+	}
+	if message.Block != block {
+		t.Errorf("Expected block %+v, got %+v", block, message.Block)
+	}
+	if len(message.Opportunities) != 1 || message.Opportunities[0].Symbols[0] != "USDC" {
+		t.Errorf("Expected only the two hop USDC opportunity, got %+v", message.Opportunities)
+	}
+	if len(message.Reserves) != 1 || message.Reserves[0].Pool != daiUSDC.ContractAddress.String() {
+		t.Errorf("Expected only the DAI/USDC pool's reserves, got %+v", message.Reserves)
+	}
+
+	// Filter on profit alone, and change one pool's reserves
+	if err := conn.WriteJSON(Filter{MinProfit: "0.1"}); err != nil {
+		t.Fatalf("Failed to send filter: %v", err)
+	}
+	for !filterApplied(server.stream, "0.1") {
+		time.Sleep(time.Millisecond)
+	}
+	changed := wethDAI.Clone().(*eth.UniswapPool)
+	changed.Reserve0 = testutil.Ether(1_001)
+	next := graph.NewGraph()
+	next.AddPool(changed)
+	next.AddPool(daiUSDC)
+	block = graph.BlockRef{Number: 2, Hash: common.HexToHash("0x02")}
+	server.Publish(&View{Block: block, Graph: next, Prices: graph.NewPricer(), Found: []graph.OpportunityRecord{
+		record([]string{"WETH", "DAI", "WETH"}, wethAddress, daiAddress, wethAddress),
+	}})
+
+	message = BlockMessage{}
+	if err := conn.ReadJSON(&message); err != nil {
+		t.Fatalf("Failed to read message: %v", err)
+	}
+	if message.Block != block || len(message.Opportunities) != 1 {
+		t.Errorf("Expected the opportunity at block %+v, got %+v", block, message)
+	}
+	if len(message.Reserves) != 1 || message.Reserves[0].Pool != wethDAI.ContractAddress.String() ||
+		message.Reserves[0].Tokens[0].Reserve != "1001.000000000000000000" {
+		t.Errorf("Expected only the changed WETH/DAI reserves, got %+v", message.Reserves)
+	}
+}
+
+func filterApplied(s *stream, minProfit string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for client := range s.clients {
+		client.mu.Lock()
+		applied := client.filter.minProfit != nil && client.filter.minProfit.Text('f', -1) == minProfit
+		client.mu.Unlock()
+		if applied {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFilter(t *testing.T) {
+	fmt.Println("TestFilter")
+	if _, err := (Filter{MinProfit: "lots"}).compile(); err == nil {
+		t.Errorf("Expected an invalid min_profit to be rejected")
+	}
+	if _, err := (Filter{MaxHops: -1}).compile(); err == nil {
+		t.Errorf("Expected a negative max_hops to be rejected")
+	}
+	f, err := Filter{MinProfit: "0.01", Tokens: []string{" 0xABC "}, MaxHops: 3}.compile()
+	if err != nil {
+		t.Fatalf("Failed to compile filter: %v", err)
+	}
+	cases := []struct {
+		record   graph.OpportunityRecord
+		expected bool
+	}{
+		{graph.OpportunityRecord{Tokens: []string{"0xabc", "0xdef"}, Pools: []string{"p"}, NetProfit: "0.01"}, true},
+		{graph.OpportunityRecord{Tokens: []string{"0xabc", "0xdef"}, Pools: []string{"p"}, NetProfit: "0.009"}, false},
+		{graph.OpportunityRecord{Tokens: []string{"0xdef"}, Pools: []string{"p"}, NetProfit: "1"}, false},
+		{graph.OpportunityRecord{Tokens: []string{"0xabc"}, Pools: []string{"p", "q", "r", "s"}, NetProfit: "1"}, false},
+		{graph.OpportunityRecord{Tokens: []string{"0xabc"}, Pools: []string{"p"}}, false},
+	}
+	for i, c := range cases {
+		if matched := f.matchesOpportunity(c.record); matched != c.expected {
+			t.Errorf("Case %d: expected match %t, got %t", i, c.expected, matched)
+		}
+	}
+}
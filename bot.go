@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math/big"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"gethmate/api"
+	"gethmate/chain"
+	"gethmate/eth"
+	"gethmate/gas"
+	"gethmate/graph"
+	"gethmate/mempool"
+	"gethmate/metrics"
+	"gethmate/rpcpool"
+	"gethmate/workers"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Bot processes new blocks: it refreshes the graph's reserves at each block
+// and searches the refreshed graph for arbitrage opportunities
+type Bot struct {
+	client        eth.Client
+	workers       *workers.Pool
+	graph         *graph.Graph
+	reorgs        *chain.ReorgDetector
+	opportunities *graph.OpportunityBook
+	prices        atomic.Pointer[graph.Pricer] // Read by backrun on the mempool goroutine
+	scheduler     *chain.Scheduler
+	mempool       *mempool.Watcher
+	config        BotConfig
+
+	started     time.Time
+	errors      atomic.Uint64
+	found       atomic.Uint64 // Opportunities that beat gas and the margin
+	belowMargin atomic.Uint64 // Paths that did not
+}
+
+// BotConfig holds the parameters a Bot trades with
+type BotConfig struct {
+	StartAmountIn *big.Float    // WETH traded around each cycle
+	BlockTimeout  time.Duration // Deadline for processing a single block, zero for none
+	Gas           *gas.Model
+	MinProfit     *big.Int // Profit in wei an opportunity must make after gas
+	Journal       *graph.Journal
+	API           *api.Server // Serves and streams each block's consistent view, nil for none
+}
+
+func NewBot(pool *rpcpool.Pool, client eth.Client, workers *workers.Pool, g *graph.Graph, config BotConfig) *Bot {
+	b := &Bot{
+		client:        client,
+		workers:       workers,
+		graph:         g,
+		reorgs:        chain.NewReorgDetector(pool, 64),
+		opportunities: graph.NewOpportunityBook(64),
+		config:        config,
+		started:       time.Now(),
+	}
+	b.prices.Store(graph.NewPricer())
+	b.scheduler = chain.NewScheduler(b.ProcessBlock)
+	b.mempool = mempool.NewWatcher(mempool.RouterV2Address, b.backrun)
+	return b
+}
+
+// Run processes headers until the channel is closed or ctx is cancelled. If
+// processing falls behind, stale headers are skipped in favour of the latest.
+func (b *Bot) Run(ctx context.Context, headers <-chan *types.Header) {
+	b.scheduler.Run(ctx, headers)
+}
+
+// WatchMempool simulates pending router swaps against the latest processed
+// block and searches the state they would leave for backruns, until the
+// channel is closed or ctx is cancelled
+func (b *Bot) WatchMempool(ctx context.Context, transactions <-chan *types.Transaction) {
+	b.mempool.Run(ctx, transactions)
+}
+
+func (b *Bot) ProcessBlock(ctx context.Context, header *types.Header) {
+	blockNumber := header.Number
+	logger := slog.With("block", blockNumber.Uint64())
+	logger.Info("New block", "hash", header.Hash())
+	start := time.Now()
+	metrics.HeadLag.With().Set(start.Sub(time.Unix(int64(header.Time), 0)).Seconds())
+	if b.config.BlockTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.config.BlockTimeout)
+		defer cancel()
+	}
+
+	// Drop state and opportunities from blocks orphaned by a reorg
+	reorg, err := b.reorgs.Observe(ctx, header)
+	if err != nil {
+		b.errors.Add(1)
+		logger.Error("Failed to check for reorg", "stage", "reorg", "err", err)
+	} else if reorg != nil {
+		orphaned := reorg.OrphanedHashes()
+		pools := b.graph.InvalidateReserves(orphaned)
+		invalidated := b.opportunities.Invalidate(orphaned)
+		for _, opportunity := range invalidated {
+			b.journal(opportunity)
+		}
+		logger.Warn("Reorg", "stage", "reorg", "depth", reorg.Depth(), "refetching", len(pools), "invalidated", len(invalidated))
+	}
+
+	// Update edge weights for new block, pinned to the header so that
+	// every pool reflects the same state
+	stageStart := time.Now()
+	if err := b.graph.UpdateAllEdges(ctx, b.client, b.workers, header); err != nil {
+		b.errors.Add(1)
+		logger.Error("Failed to refresh pools", "stage", "refresh", "err", err)
+	}
+	b.recordStage("refresh", time.Since(stageStart))
+	if ctx.Err() != nil {
+		logger.Warn("Abandoning block", "stage", "refresh", "err", context.Cause(ctx))
+		return
+	}
+
+	// Search an immutable copy of the graph, so that the opportunity keeps
+	// the reserves it was found on. Pools that failed to refresh are left out
+	// of the copy rather than mixing their state from an earlier block.
+	view, dropped := b.graph.CopyAt(header)
+	if total := len(view.Pools) + dropped; float64(dropped) > maxDroppedPools*float64(total) {
+		logger.Warn("Skipping block", "stage", "consistency", "dropped", dropped, "pools", total)
+		return
+	} else if dropped > 0 {
+		logger.Warn("Searching without pools that failed to refresh", "stage", "consistency", "dropped", dropped, "pools", total)
+	}
+	if err := view.CheckBlockConsistency(header); err != nil {
+		logger.Warn("Skipping block", "stage", "consistency", "err", err)
+		return
+	}
+	b.mempool.SetGraph(view, header)
+	metrics.GraphNodes.With().Set(float64(len(view.Nodes)))
+	metrics.GraphEdges.With().Set(float64(len(view.Edges)))
+	metrics.GraphPools.With().Set(float64(len(view.Pools)))
+	// Price from a new Pricer, so that the published view keeps its prices
+	prices := graph.NewPricer()
+	if err := prices.Update(view); err != nil {
+		logger.Warn("Failed to update prices", "stage", "pricing", "err", err)
+	}
+	b.prices.Store(prices)
+
+	// Find arbitrage path
+	stageStart = time.Now()
+	path := view.Strategy(ctx, b.config.StartAmountIn)
+	b.recordStage("strategy", time.Since(stageStart))
+	if ctx.Err() != nil {
+		logger.Warn("Abandoning block", "stage", "strategy", "err", context.Cause(ctx))
+		return
+	}
+	bestProfit := 0.0
+	found := make([]graph.OpportunityRecord, 0)
+	if len(path) > 0 {
+		opportunity := &graph.Opportunity{
+			Block:    graph.BlockRef{Number: blockNumber.Uint64(), Hash: header.Hash()},
+			Start:    view.GetNode(graph.WETHAddress),
+			Path:     path,
+			AmountIn: b.config.StartAmountIn,
+			FoundAt:  time.Now(),
+		}
+		// Only surface opportunities that pay for their gas with margin to spare
+		opportunity.Evaluate(b.config.Gas, header)
+		opportunity.Profitable = opportunity.IsProfitable(b.config.MinProfit)
+		record := b.journal(opportunity)
+		found = append(found, record)
+		metrics.Opportunities.With(strconv.FormatBool(opportunity.Profitable)).Inc()
+		bestProfit, _ = new(big.Float).Quo(new(big.Float).SetInt(opportunity.NetProfit), big.NewFloat(params.Ether)).Float64()
+		if opportunity.Profitable {
+			b.opportunities.Add(opportunity)
+			b.found.Add(1)
+			logger.Info("Opportunity", "stage", "strategy", "path", graph.PathString(opportunity.Start, path),
+				"gross", record.GrossProfit, "gas", record.GasCost, "net", record.NetProfit, "net_usd", b.usdValue(opportunity.NetProfit))
+		} else {
+			b.belowMargin.Add(1)
+			logger.Debug("Path does not beat gas and margin", "stage", "strategy", "path", graph.PathString(opportunity.Start, path),
+				"gross", record.GrossProfit, "gas", record.GasCost)
+		}
+	}
+	metrics.BestProfit.With().Set(bestProfit)
+	if b.config.API != nil {
+		b.config.API.Publish(&api.View{
+			Block:         graph.BlockRef{Number: blockNumber.Uint64(), Hash: header.Hash()},
+			Graph:         view,
+			Prices:        prices,
+			Opportunities: b.opportunities.LatestRecords(),
+			Found:         found,
+			UpdatedAt:     time.Now(),
+		})
+	}
+	b.recordStage("block", time.Since(start))
+
+	stats := b.scheduler.Stats()
+	logger.Info("Block processed", "duration", stats.Stages["block"].Last, "refresh", stats.Stages["refresh"].Last,
+		"strategy", stats.Stages["strategy"].Last, "skipped", stats.Skipped)
+}
+
+// Fraction of pools that may fail to refresh before a block is skipped, as
+// too little of the market would be left to search
+const maxDroppedPools = 0.1
+
+// Longest cycle searched for behind a pending swap
+const backrunMaxHops = 4
+
+// Search for a backrun of a pending swap, surfacing it if it pays for its gas
+// with margin to spare
+func (b *Bot) backrun(ctx context.Context, impact *mempool.Impact) {
+	opportunity := mempool.Backrun(ctx, impact, b.config.StartAmountIn, backrunMaxHops)
+	if opportunity == nil {
+		return
+	}
+	opportunity.Evaluate(b.config.Gas, impact.Header)
+	opportunity.Profitable = opportunity.IsProfitable(b.config.MinProfit)
+	metrics.Backruns.With(strconv.FormatBool(opportunity.Profitable)).Inc()
+	logger := slog.With("block", opportunity.Block.Number, "tx", impact.Swap.Hash, "stage", "backrun")
+	if !opportunity.Profitable {
+		logger.Debug("Backrun does not beat gas and margin", "path", graph.PathString(opportunity.Start, opportunity.Path),
+			"gross", graph.FormatUnits(opportunity.GrossProfit, opportunity.Start.Token.Decimals))
+		return
+	}
+	// Only profitable backruns are journaled, as there are far more pending
+	// swaps than blocks
+	record := b.journal(opportunity)
+	b.found.Add(1)
+	logger.Info("Backrun opportunity", "path", graph.PathString(opportunity.Start, opportunity.Path),
+		"gross", record.GrossProfit, "gas", record.GasCost, "net", record.NetProfit, "net_usd", b.usdValue(opportunity.NetProfit))
+}
+
+func (b *Bot) recordStage(stage string, duration time.Duration) {
+	b.scheduler.RecordStage(stage, duration)
+	metrics.StageDuration.With(stage).Observe(duration.Seconds())
+}
+
+// Append an opportunity to the journal, returning its record
+func (b *Bot) journal(opportunity *graph.Opportunity) graph.OpportunityRecord {
+	record := opportunity.Record()
+	if b.config.Journal != nil {
+		if err := b.config.Journal.Append(record); err != nil {
+			b.errors.Add(1)
+			slog.Error("Failed to journal opportunity", "block", record.Block.Number, "err", err)
+		}
+	}
+	return record
+}
+
+func (b *Bot) LogSummary() {
+	stats := b.scheduler.Stats()
+	attrs := []any{
+		"duration", time.Since(b.started).Round(time.Second),
+		"processed", stats.Processed,
+		"skipped", stats.Skipped,
+		"abandoned", stats.Cancelled,
+		"opportunities", b.found.Load(),
+		"below_margin", b.belowMargin.Load(),
+		"errors", b.errors.Load(),
+	}
+	if refresh, exists := stats.Stages["refresh"]; exists {
+		attrs = append(attrs, "mean_refresh", refresh.Mean())
+	}
+	if strategy, exists := stats.Stages["strategy"]; exists {
+		attrs = append(attrs, "mean_strategy", strategy.Mean())
+	}
+	slog.Info("Session summary", attrs...)
+}
+
+// Format an amount of wei in USD for logging, empty if ETH is not priced
+func (b *Bot) usdValue(wei *big.Int) string {
+	ethUSD := b.prices.Load().ETHUSD()
+	if ethUSD == nil {
+		return ""
+	}
+	usd := new(big.Float).Quo(new(big.Float).SetInt(wei), big.NewFloat(1e18))
+	usd.Mul(usd, ethUSD)
+	return usd.Text('f', 2)
+}
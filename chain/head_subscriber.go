@@ -0,0 +1,150 @@
+package chain
+
+import (
+	"context"
+	"log/slog"
+	"math/big"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+type ConnectionState int32
+
+const (
+	Disconnected ConnectionState = iota
+	Connecting
+	Connected
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case Connecting:
+		return "connecting"
+	case Connected:
+		return "connected"
+	default:
+		return "disconnected"
+	}
+}
+
+// HeadClient is the subset of ethclient.Client needed to follow the chain head
+type HeadClient interface {
+	SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	Close()
+}
+
+type DialFunc func(ctx context.Context) (HeadClient, error)
+
+// Dial a single websocket endpoint
+func DialURL(url string) DialFunc {
+	return func(ctx context.Context) (HeadClient, error) {
+		return ethclient.DialContext(ctx, url)
+	}
+}
+
+// HeadSubscriber follows new heads over a websocket subscription, reconnecting
+// with exponential backoff when the subscription drops. Blocks missed while
+// disconnected are backfilled so every block number is delivered in order.
+type HeadSubscriber struct {
+	resubscriber
+	dial    DialFunc
+	headers chan *types.Header
+	// Gaps larger than this are not backfilled; only the latest header is delivered
+	MaxBackfill int
+
+	missedBlocks atomic.Uint64
+	lastNumber   *big.Int
+}
+
+func NewHeadSubscriber(dial DialFunc) *HeadSubscriber {
+	return &HeadSubscriber{
+		resubscriber: newResubscriber("heads"),
+		dial:         dial,
+		headers:      make(chan *types.Header),
+		MaxBackfill:  64,
+	}
+}
+
+func (h *HeadSubscriber) Headers() <-chan *types.Header {
+	return h.headers
+}
+
+// Number of block numbers that were skipped by the node's subscription
+func (h *HeadSubscriber) MissedBlocks() uint64 {
+	return h.missedBlocks.Load()
+}
+
+// Run follows the chain head until ctx is cancelled, then closes the headers
+// channel
+func (h *HeadSubscriber) Run(ctx context.Context) {
+	defer close(h.headers)
+	resubscribe(ctx, &h.resubscriber, h.connect)
+}
+
+// Dial and subscribe to new heads. Each header is delivered after any that
+// were missed since the last one.
+func (h *HeadSubscriber) connect(ctx context.Context, headers chan<- *types.Header) (*connection[*types.Header], error) {
+	client, err := h.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := client.SubscribeNewHead(ctx, headers)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	handle := func(ctx context.Context, header *types.Header) bool {
+		return h.backfill(ctx, client, header) && h.deliver(ctx, header)
+	}
+	return &connection[*types.Header]{sub: sub, handle: handle, close: client.Close}, nil
+}
+
+// Deliver any headers between the last delivered header and header. Returns
+// false if ctx was cancelled or a missed header could not be fetched, in
+// which case the subscription is redialled and the backfill retried from the
+// last delivered header when the next one arrives.
+func (h *HeadSubscriber) backfill(ctx context.Context, client HeadClient, header *types.Header) bool {
+	if h.lastNumber == nil {
+		return true
+	}
+	gap := new(big.Int).Sub(header.Number, h.lastNumber)
+	if gap.Cmp(big.NewInt(1)) <= 0 {
+		return true
+	}
+	missed := gap.Int64() - 1
+	if missed > int64(h.MaxBackfill) {
+		h.missedBlocks.Add(uint64(missed))
+		slog.Warn("Missed too many blocks, skipping backfill", "block", header.Number, "missed", missed)
+		return true
+	}
+
+	slog.Info("Backfilling missed blocks", "block", header.Number, "missed", missed)
+	for n := new(big.Int).Add(h.lastNumber, big.NewInt(1)); n.Cmp(header.Number) < 0; n.Add(n, big.NewInt(1)) {
+		missedHeader, err := client.HeaderByNumber(ctx, n)
+		if err != nil {
+			slog.Warn("Failed to backfill block", "block", n, "err", err)
+			return false
+		}
+		if !h.deliver(ctx, missedHeader) {
+			return false
+		}
+	}
+	// Counted once the backfill succeeds, so a retried backfill is not
+	// counted twice
+	h.missedBlocks.Add(uint64(missed))
+	return true
+}
+
+func (h *HeadSubscriber) deliver(ctx context.Context, header *types.Header) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case h.headers <- header:
+		h.lastNumber = new(big.Int).Set(header.Number)
+		return true
+	}
+}
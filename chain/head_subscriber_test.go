@@ -0,0 +1,132 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+type fakeSubscription struct {
+	err chan error
+}
+
+func (s *fakeSubscription) Unsubscribe() {}
+
+func (s *fakeSubscription) Err() <-chan error {
+	return s.err
+}
+
+// Sends the given block numbers, then drops the subscription. Fetching the
+// header numbered fail errors.
+type fakeHeadClient struct {
+	numbers []int64
+	fail    int64
+}
+
+func (c *fakeHeadClient) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error) {
+	sub := &fakeSubscription{err: make(chan error, 1)}
+	go func() {
+		for _, n := range c.numbers {
+			select {
+			case ch <- &types.Header{Number: big.NewInt(n)}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		sub.err <- errors.New("connection reset")
+	}()
+	return sub, nil
+}
+
+func (c *fakeHeadClient) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	if number.Int64() == c.fail {
+		return nil, errors.New("header not found")
+	}
+	return &types.Header{Number: new(big.Int).Set(number)}, nil
+}
+
+func (c *fakeHeadClient) Close() {}
+
+func TestHeadSubscriberReconnectAndBackfill(t *testing.T) {
+	fmt.Println("TestHeadSubscriberReconnectAndBackfill")
+	sessions := [][]int64{{1, 2, 5}, {6}}
+	dials := 0
+	dial := func(ctx context.Context) (HeadClient, error) {
+		dials++
+		if dials == 2 {
+			return nil, errors.New("connection refused")
+		}
+		if len(sessions) == 0 {
+			return &fakeHeadClient{}, nil
+		}
+		client := &fakeHeadClient{numbers: sessions[0]}
+		sessions = sessions[1:]
+		return client, nil
+	}
+
+	sub := NewHeadSubscriber(dial)
+	sub.MinBackoff = time.Millisecond
+	sub.MaxBackoff = 2 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sub.Run(ctx)
+
+	for expected := int64(1); expected <= 6; expected++ {
+		select {
+		case header := <-sub.Headers():
+			if header.Number.Int64() != expected {
+				t.Fatalf("Expected block %d, got %s", expected, header.Number)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for block %d", expected)
+		}
+	}
+	if sub.MissedBlocks() != 2 {
+		t.Errorf("Expected 2 missed blocks, got %d", sub.MissedBlocks())
+	}
+	if sub.Reconnects() < 1 {
+		t.Errorf("Expected at least 1 reconnect, got %d", sub.Reconnects())
+	}
+}
+
+func TestHeadSubscriberRetriesBackfill(t *testing.T) {
+	fmt.Println("TestHeadSubscriberRetriesBackfill")
+	// Block 2 cannot be fetched on the first connection, so block 4 is not
+	// delivered until the second connection backfills 2 to 4
+	sessions := []*fakeHeadClient{{numbers: []int64{1, 4}, fail: 2}, {numbers: []int64{5}}}
+	dial := func(ctx context.Context) (HeadClient, error) {
+		if len(sessions) == 0 {
+			return &fakeHeadClient{}, nil
+		}
+		client := sessions[0]
+		sessions = sessions[1:]
+		return client, nil
+	}
+
+	sub := NewHeadSubscriber(dial)
+	sub.MinBackoff = time.Millisecond
+	sub.MaxBackoff = 2 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sub.Run(ctx)
+
+	for expected := int64(1); expected <= 5; expected++ {
+		select {
+		case header := <-sub.Headers():
+			if header.Number.Int64() != expected {
+				t.Fatalf("Expected block %d, got %s", expected, header.Number)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for block %d", expected)
+		}
+	}
+	if sub.Reconnects() < 1 {
+		t.Errorf("Expected a reconnect after the failed backfill, got %d", sub.Reconnects())
+	}
+}
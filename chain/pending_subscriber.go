@@ -0,0 +1,80 @@
+package chain
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// PendingClient is the subscription needed to follow the mempool. gethclient
+// returns a concrete subscription type, so clients are wrapped to satisfy it.
+type PendingClient interface {
+	SubscribeFullPendingTransactions(ctx context.Context, ch chan<- *types.Transaction) (ethereum.Subscription, error)
+	Close()
+}
+
+type PendingDialFunc func(ctx context.Context) (PendingClient, error)
+
+// Transactions queued for the consumer beyond this are dropped
+const pendingBuffer = 1024
+
+// PendingSubscriber follows the node's pending transactions over a websocket
+// subscription, reconnecting with exponential backoff when it drops. Unlike
+// heads, pending transactions are not backfilled, and are dropped rather
+// than delivered late when the consumer falls behind.
+type PendingSubscriber struct {
+	resubscriber
+	dial         PendingDialFunc
+	transactions chan *types.Transaction
+
+	dropped atomic.Uint64
+}
+
+func NewPendingSubscriber(dial PendingDialFunc) *PendingSubscriber {
+	return &PendingSubscriber{
+		resubscriber: newResubscriber("pending transactions"),
+		dial:         dial,
+		transactions: make(chan *types.Transaction, pendingBuffer),
+	}
+}
+
+func (p *PendingSubscriber) Transactions() <-chan *types.Transaction {
+	return p.transactions
+}
+
+// Number of transactions dropped because the consumer fell behind
+func (p *PendingSubscriber) Dropped() uint64 {
+	return p.dropped.Load()
+}
+
+// Run follows pending transactions until ctx is cancelled, then closes the
+// transactions channel
+func (p *PendingSubscriber) Run(ctx context.Context) {
+	defer close(p.transactions)
+	resubscribe(ctx, &p.resubscriber, p.connect)
+}
+
+// Dial and subscribe to pending transactions
+func (p *PendingSubscriber) connect(ctx context.Context, transactions chan<- *types.Transaction) (*connection[*types.Transaction], error) {
+	client, err := p.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := client.SubscribeFullPendingTransactions(ctx, transactions)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &connection[*types.Transaction]{sub: sub, handle: p.deliver, close: client.Close}, nil
+}
+
+func (p *PendingSubscriber) deliver(ctx context.Context, tx *types.Transaction) bool {
+	select {
+	case p.transactions <- tx:
+	default:
+		p.dropped.Add(1)
+	}
+	return true
+}
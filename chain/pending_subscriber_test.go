@@ -0,0 +1,74 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Sends transactions with the given nonces, then drops the subscription
+type fakePendingClient struct {
+	nonces []uint64
+}
+
+func (c *fakePendingClient) SubscribeFullPendingTransactions(ctx context.Context, ch chan<- *types.Transaction) (ethereum.Subscription, error) {
+	sub := &fakeSubscription{err: make(chan error, 1)}
+	go func() {
+		for _, nonce := range c.nonces {
+			select {
+			case ch <- types.NewTx(&types.DynamicFeeTx{Nonce: nonce}):
+			case <-ctx.Done():
+				return
+			}
+		}
+		sub.err <- errors.New("connection reset")
+	}()
+	return sub, nil
+}
+
+func (c *fakePendingClient) Close() {}
+
+func TestPendingSubscriberReconnect(t *testing.T) {
+	fmt.Println("TestPendingSubscriberReconnect")
+	sessions := [][]uint64{{1, 2}, {3}}
+	dial := func(ctx context.Context) (PendingClient, error) {
+		if len(sessions) == 0 {
+			return &fakePendingClient{}, nil
+		}
+		client := &fakePendingClient{nonces: sessions[0]}
+		sessions = sessions[1:]
+		return client, nil
+	}
+
+	sub := NewPendingSubscriber(dial)
+	sub.MinBackoff = time.Millisecond
+	sub.MaxBackoff = 2 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	go sub.Run(ctx)
+
+	for expected := uint64(1); expected <= 3; expected++ {
+		select {
+		case tx := <-sub.Transactions():
+			if tx.Nonce() != expected {
+				t.Fatalf("Expected nonce %d, got %d", expected, tx.Nonce())
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for nonce %d", expected)
+		}
+	}
+	if sub.Reconnects() < 1 {
+		t.Errorf("Expected a reconnect, got %d", sub.Reconnects())
+	}
+
+	cancel()
+	for range sub.Transactions() {
+	}
+	if sub.State() != Disconnected {
+		t.Errorf("Expected disconnected after shutdown, got %s", sub.State())
+	}
+}
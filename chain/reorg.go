@@ -0,0 +1,124 @@
+package chain
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// HeaderFetcher looks up headers of blocks on a new chain that were not
+// delivered by the head subscription
+type HeaderFetcher interface {
+	HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error)
+}
+
+// Reorg describes a switch to a new canonical chain
+type Reorg struct {
+	// Last block shared by the old and new chain, nil if the reorg is deeper
+	// than the tracked window
+	Ancestor *types.Header
+	// Blocks on the old chain that are no longer canonical, oldest first
+	Orphaned []*types.Header
+}
+
+func (r *Reorg) Depth() int {
+	return len(r.Orphaned)
+}
+
+func (r *Reorg) OrphanedHashes() []common.Hash {
+	hashes := make([]common.Hash, len(r.Orphaned))
+	for i, header := range r.Orphaned {
+		hashes[i] = header.Hash()
+	}
+	return hashes
+}
+
+// ReorgDetector tracks a window of recent canonical headers and detects when a
+// new header does not build on the tracked chain
+type ReorgDetector struct {
+	client  HeaderFetcher
+	window  int
+	headers []*types.Header // Oldest first
+}
+
+func NewReorgDetector(client HeaderFetcher, window int) *ReorgDetector {
+	return &ReorgDetector{
+		client: client,
+		window: window,
+	}
+}
+
+// Observe a new head. Returns the reorg if header's chain orphans any tracked
+// headers, or nil if it extends the tracked chain.
+func (d *ReorgDetector) Observe(ctx context.Context, header *types.Header) (*Reorg, error) {
+	if len(d.headers) == 0 {
+		d.headers = append(d.headers, header)
+		return nil, nil
+	}
+	hash := header.Hash()
+	for _, tracked := range d.headers {
+		if tracked.Hash() == hash {
+			return nil, nil
+		}
+	}
+
+	// Too far ahead to walk back to the tracked chain, so start tracking afresh
+	tip := d.headers[len(d.headers)-1]
+	if header.Number.Uint64() > tip.Number.Uint64()+uint64(d.window) {
+		d.headers = []*types.Header{header}
+		return nil, nil
+	}
+
+	// Walk back along header's chain until reaching a tracked header
+	newChain := []*types.Header{header}
+	oldest := d.headers[0].Number.Uint64()
+	current := header
+	for {
+		if i := d.indexOf(current.ParentHash); i >= 0 {
+			ancestor := d.headers[i]
+			orphaned := append([]*types.Header(nil), d.headers[i+1:]...)
+			d.extend(d.headers[:i+1], newChain)
+			if len(orphaned) == 0 {
+				return nil, nil
+			}
+			return &Reorg{Ancestor: ancestor, Orphaned: orphaned}, nil
+		}
+		if current.Number.Uint64() <= oldest {
+			// No common ancestor within the window
+			orphaned := d.headers
+			d.headers = nil
+			d.extend(nil, newChain)
+			return &Reorg{Orphaned: orphaned}, nil
+		}
+		parent, err := d.client.HeaderByHash(ctx, current.ParentHash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get parent of block %s: %v", current.Number, err)
+		}
+		newChain = append(newChain, parent)
+		current = parent
+	}
+}
+
+func (d *ReorgDetector) indexOf(hash common.Hash) int {
+	for i := len(d.headers) - 1; i >= 0; i-- {
+		if d.headers[i].Hash() == hash {
+			return i
+		}
+	}
+	return -1
+}
+
+// Replace the tracked chain with base followed by newChain (newest first),
+// keeping at most window headers
+func (d *ReorgDetector) extend(base []*types.Header, newChain []*types.Header) {
+	headers := append([]*types.Header(nil), base...)
+	for i := len(newChain) - 1; i >= 0; i-- {
+		headers = append(headers, newChain[i])
+	}
+	if len(headers) > d.window {
+		headers = headers[len(headers)-d.window:]
+	}
+	d.headers = headers
+}
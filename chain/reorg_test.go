@@ -0,0 +1,84 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+type fakeHeaderFetcher struct {
+	headers map[common.Hash]*types.Header
+}
+
+func (f *fakeHeaderFetcher) HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error) {
+	header, exists := f.headers[hash]
+	if !exists {
+		return nil, errors.New("not found")
+	}
+	return header, nil
+}
+
+// Build a chain of n headers on top of parent. Extra distinguishes forks.
+func buildChain(f *fakeHeaderFetcher, parent *types.Header, n int, extra string) []*types.Header {
+	chain := make([]*types.Header, 0, n)
+	for i := 0; i < n; i++ {
+		header := &types.Header{
+			Number:     new(big.Int).Add(parent.Number, big.NewInt(1)),
+			ParentHash: parent.Hash(),
+			Extra:      []byte(extra),
+		}
+		f.headers[header.Hash()] = header
+		chain = append(chain, header)
+		parent = header
+	}
+	return chain
+}
+
+func TestReorgDetector(t *testing.T) {
+	fmt.Println("TestReorgDetector")
+	fetcher := &fakeHeaderFetcher{headers: make(map[common.Hash]*types.Header)}
+	genesis := &types.Header{Number: big.NewInt(100)}
+	canonical := buildChain(fetcher, genesis, 5, "a")
+
+	detector := NewReorgDetector(fetcher, 16)
+	ctx := context.Background()
+	for _, header := range append([]*types.Header{genesis}, canonical...) {
+		reorg, err := detector.Observe(ctx, header)
+		if err != nil || reorg != nil {
+			t.Fatalf("Expected no reorg at block %s, got %v %v", header.Number, reorg, err)
+		}
+	}
+
+	// A fork from block 102 that overtakes the canonical chain at 106. Only
+	// its head is delivered, the rest must be fetched.
+	fork := buildChain(fetcher, canonical[1], 4, "b")
+	reorg, err := detector.Observe(ctx, fork[len(fork)-1])
+	if err != nil {
+		t.Fatalf("Failed to observe fork: %v", err)
+	}
+	if reorg == nil {
+		t.Fatalf("Expected reorg")
+	}
+	if reorg.Depth() != 3 {
+		t.Errorf("Expected reorg depth 3, got %d", reorg.Depth())
+	}
+	if reorg.Ancestor.Hash() != canonical[1].Hash() {
+		t.Errorf("Expected ancestor at block %s, got %s", canonical[1].Number, reorg.Ancestor.Number)
+	}
+	for i, hash := range reorg.OrphanedHashes() {
+		if hash != canonical[i+2].Hash() {
+			t.Errorf("Expected orphaned block %s, got %s", canonical[i+2].Number, reorg.Orphaned[i].Number)
+		}
+	}
+
+	// The fork is now canonical
+	next := buildChain(fetcher, fork[len(fork)-1], 1, "b")
+	if reorg, err := detector.Observe(ctx, next[0]); err != nil || reorg != nil {
+		t.Errorf("Expected fork to be extended without reorg, got %v %v", reorg, err)
+	}
+}
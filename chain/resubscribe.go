@@ -0,0 +1,123 @@
+package chain
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+)
+
+// connection is one dialled subscription. handle is called with each item
+// received, and returns false to drop the connection and redial.
+type connection[T any] struct {
+	sub    ethereum.Subscription
+	handle func(ctx context.Context, item T) bool
+	close  func()
+}
+
+// Dial and subscribe, sending items to the given channel
+type connectFunc[T any] func(ctx context.Context, items chan<- T) (*connection[T], error)
+
+// resubscriber holds the connection state shared by the subscribers, which
+// redial with exponential backoff whenever their subscription drops
+type resubscriber struct {
+	name       string // What is subscribed to, for logs
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	state      atomic.Int32
+	reconnects atomic.Uint64
+}
+
+func newResubscriber(name string) resubscriber {
+	return resubscriber{
+		name:       name,
+		MinBackoff: time.Second,
+		MaxBackoff: time.Minute,
+	}
+}
+
+func (r *resubscriber) State() ConnectionState {
+	return ConnectionState(r.state.Load())
+}
+
+// Number of times the subscription has been re-established after dropping
+func (r *resubscriber) Reconnects() uint64 {
+	return r.reconnects.Load()
+}
+
+func (r *resubscriber) setState(state ConnectionState) {
+	if ConnectionState(r.state.Swap(int32(state))) != state {
+		slog.Info("Subscription state changed", "subscription", r.name, "state", state.String())
+	}
+}
+
+// Follow a subscription until ctx is cancelled, redialling whenever it fails.
+// The backoff resets only once an item has arrived, so a node that accepts
+// the subscription but drops it before sending anything keeps backing off.
+func resubscribe[T any](ctx context.Context, r *resubscriber, connect connectFunc[T]) {
+	backoff := r.MinBackoff
+	connected := false
+	for {
+		r.setState(Connecting)
+		if follow(ctx, r, connect, &connected) {
+			backoff = r.MinBackoff
+		}
+		r.setState(Disconnected)
+		if ctx.Err() != nil {
+			return
+		}
+
+		slog.Info("Reconnecting subscription", "subscription", r.name, "backoff", backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = nextBackoff(backoff, r.MaxBackoff)
+	}
+}
+
+// Connect and handle items until the subscription fails. Returns whether an
+// item arrived.
+func follow[T any](ctx context.Context, r *resubscriber, connect connectFunc[T], connected *bool) bool {
+	items := make(chan T)
+	conn, err := connect(ctx, items)
+	if err != nil {
+		slog.Warn("Failed to subscribe", "subscription", r.name, "err", err)
+		return false
+	}
+	defer conn.close()
+	defer conn.sub.Unsubscribe()
+	if *connected {
+		r.reconnects.Add(1)
+	}
+	*connected = true
+	r.setState(Connected)
+
+	received := false
+	for {
+		select {
+		case <-ctx.Done():
+			return received
+		case err := <-conn.sub.Err():
+			slog.Warn("Subscription dropped", "subscription", r.name, "err", err)
+			return received
+		case item := <-items:
+			received = true
+			if !conn.handle(ctx, item) {
+				return received
+			}
+		}
+	}
+}
+
+func nextBackoff(backoff, max time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > max {
+		return max
+	}
+	return backoff
+}
@@ -0,0 +1,177 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gethmate/metrics"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+type BlockHandler func(ctx context.Context, header *types.Header)
+
+// Cause of a run's context being cancelled by a newer header
+var errSuperseded = errors.New("superseded by a newer block")
+
+// Scheduler runs a block handler on the most recent header only. A header that
+// arrives while the handler is busy cancels the in-flight run, and headers
+// superseded before they were started are skipped.
+type Scheduler struct {
+	handler BlockHandler
+
+	mu      sync.Mutex
+	pending *types.Header
+	cancel  context.CancelCauseFunc
+	wake    chan struct{}
+
+	processed atomic.Uint64
+	skipped   atomic.Uint64
+	cancelled atomic.Uint64
+
+	stagesMu sync.Mutex
+	stages   map[string]*StageStats
+}
+
+// StageStats summarises the latency of one stage of block processing
+type StageStats struct {
+	Count uint64        `json:"count"`
+	Last  time.Duration `json:"last"`
+	Max   time.Duration `json:"max"`
+	Total time.Duration `json:"total"`
+}
+
+func (s StageStats) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+type SchedulerStats struct {
+	Processed uint64                `json:"processed"`
+	Skipped   uint64                `json:"skipped"`
+	Cancelled uint64                `json:"cancelled"`
+	Stages    map[string]StageStats `json:"stages"`
+}
+
+func NewScheduler(handler BlockHandler) *Scheduler {
+	return &Scheduler{
+		handler: handler,
+		wake:    make(chan struct{}, 1),
+		stages:  make(map[string]*StageStats),
+	}
+}
+
+// Run schedules headers until the channel is closed or ctx is cancelled, then
+// waits for the in-flight run to finish. Cancelling ctx does not cancel the
+// in-flight run, so shutdown lets the current block complete.
+func (s *Scheduler) Run(ctx context.Context, headers <-chan *types.Header) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.work(context.WithoutCancel(ctx))
+	}()
+
+	defer func() {
+		close(s.wake)
+		<-done
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Drop any header that has not started yet
+			s.mu.Lock()
+			s.pending = nil
+			s.mu.Unlock()
+			return
+		case header, ok := <-headers:
+			if !ok {
+				return
+			}
+			s.schedule(header)
+		}
+	}
+}
+
+func (s *Scheduler) schedule(header *types.Header) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending != nil {
+		s.skipped.Add(1)
+		metrics.Blocks.With("skipped").Inc()
+	}
+	s.pending = header
+	if s.cancel != nil {
+		s.cancel(errSuperseded)
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) work(ctx context.Context) {
+	for range s.wake {
+		s.mu.Lock()
+		header := s.pending
+		s.pending = nil
+		if header == nil {
+			s.mu.Unlock()
+			continue
+		}
+		runCtx, cancel := context.WithCancelCause(ctx)
+		s.cancel = cancel
+		s.mu.Unlock()
+
+		s.handler(runCtx, header)
+		if errors.Is(context.Cause(runCtx), errSuperseded) {
+			s.cancelled.Add(1)
+			metrics.Blocks.With("abandoned").Inc()
+		} else {
+			s.processed.Add(1)
+			metrics.Blocks.With("processed").Inc()
+		}
+
+		s.mu.Lock()
+		s.cancel = nil
+		s.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+// Record how long a stage of processing a block took
+func (s *Scheduler) RecordStage(stage string, duration time.Duration) {
+	s.stagesMu.Lock()
+	defer s.stagesMu.Unlock()
+	stats, exists := s.stages[stage]
+	if !exists {
+		stats = &StageStats{}
+		s.stages[stage] = stats
+	}
+	stats.Count++
+	stats.Last = duration
+	stats.Total += duration
+	if duration > stats.Max {
+		stats.Max = duration
+	}
+}
+
+func (s *Scheduler) Stats() SchedulerStats {
+	s.stagesMu.Lock()
+	defer s.stagesMu.Unlock()
+	stages := make(map[string]StageStats, len(s.stages))
+	for stage, stats := range s.stages {
+		stages[stage] = *stats
+	}
+	return SchedulerStats{
+		Processed: s.processed.Load(),
+		Skipped:   s.skipped.Load(),
+		Cancelled: s.cancelled.Load(),
+		Stages:    stages,
+	}
+}
@@ -0,0 +1,69 @@
+package chain
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestSchedulerProcessesLatestHeader(t *testing.T) {
+	fmt.Println("TestSchedulerProcessesLatestHeader")
+	started := make(chan int64, 16)
+	var mu sync.Mutex
+	completed := make([]int64, 0)
+
+	// Block 1 runs until cancelled by a newer header, later blocks complete
+	handler := func(ctx context.Context, header *types.Header) {
+		n := header.Number.Int64()
+		started <- n
+		if n == 1 {
+			<-ctx.Done()
+			return
+		}
+		mu.Lock()
+		completed = append(completed, n)
+		mu.Unlock()
+	}
+	scheduler := NewScheduler(handler)
+	headers := make(chan *types.Header)
+	done := make(chan struct{})
+	go func() {
+		scheduler.Run(context.Background(), headers)
+		close(done)
+	}()
+
+	headers <- &types.Header{Number: big.NewInt(1)}
+	if n := <-started; n != 1 {
+		t.Fatalf("Expected block 1 to start, got %d", n)
+	}
+
+	// Block 2 cancels block 1. Blocks 2 and 3 may be skipped in favour of 4.
+	for n := int64(2); n <= 4; n++ {
+		headers <- &types.Header{Number: big.NewInt(n)}
+	}
+	close(headers)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for scheduler")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(completed) == 0 || completed[len(completed)-1] != 4 {
+		t.Errorf("Expected block 4 to be processed last, got %v", completed)
+	}
+	stats := scheduler.Stats()
+	if stats.Cancelled != 1 {
+		t.Errorf("Expected 1 cancelled run, got %d", stats.Cancelled)
+	}
+	if stats.Skipped+stats.Processed != 3 {
+		t.Errorf("Expected blocks 2 to 4 to be processed or skipped, got %d processed and %d skipped", stats.Processed, stats.Skipped)
+	}
+}
@@ -0,0 +1,259 @@
+package eth
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/big"
+	"strings"
+	"sync"
+
+	"gethmate/utils"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var BalancerVaultAddress = common.HexToAddress("0xBA12222222228d8Ba445958a75a0704d566BF2C8") // Balancer V2 Vault on Eth mainnet
+
+const balancerABIJSON = `[
+	{"name":"getPoolTokens","type":"function","stateMutability":"view",
+	 "inputs":[{"name":"poolId","type":"bytes32"}],
+	 "outputs":[{"name":"tokens","type":"address[]"},{"name":"balances","type":"uint256[]"},{"name":"lastChangeBlock","type":"uint256"}]},
+	{"name":"getNormalizedWeights","type":"function","stateMutability":"view",
+	 "inputs":[],
+	 "outputs":[{"name":"","type":"uint256[]"}]}
+]`
+
+var (
+	balancerABI, _ = abi.JSON(strings.NewReader(balancerABIJSON))
+	balancerOne    = new(big.Float).SetInt64(1e18)
+
+	// The weighted math rejects trades larger than 30% of the pool's balances
+	balancerMaxInRatio  = big.NewFloat(0.3)
+	balancerMaxOutRatio = big.NewFloat(0.3)
+)
+
+// BalancerPool is a Balancer V2 weighted pool. Token balances live in the
+// Vault, so reserves are refreshed through Vault.getPoolTokens.
+type BalancerPool struct {
+	ContractAddress common.Address `json:"contract_address"`
+	PoolId          common.Hash    `json:"pool_id"`
+	Tokens          []*ERC20Token  `json:"tokens"`
+	Balances        []*big.Int     `json:"balances"`
+	Weights         []*big.Int     `json:"weights"`  // Normalised weights, scaled by 1e18
+	SwapFee         *big.Int       `json:"swap_fee"` // Swap fee percentage, scaled by 1e18
+	Initialized     bool
+}
+
+func NewBalancerPool(contractAddress string) *BalancerPool {
+	return &BalancerPool{
+		ContractAddress: common.HexToAddress(contractAddress),
+		Initialized:     false,
+	}
+}
+
+func (b *BalancerPool) Initialize(ctx context.Context, client Client, tokens *sync.Map) {
+	logger := slog.With("pool", b.ContractAddress)
+	// Pool id
+	callMsg := ethereum.CallMsg{
+		To:   &b.ContractAddress,
+		Data: utils.GetFunctionSelector("getPoolId()"),
+	}
+	result, err := client.CallContract(ctx, callMsg, nil)
+	if err != nil || len(result) < 32 {
+		logger.Warn("Failed to get pool id", "err", err)
+		return
+	}
+	b.PoolId = common.BytesToHash(result[:32])
+
+	// Weights
+	callMsg.Data = utils.GetFunctionSelector("getNormalizedWeights()")
+	result, err = client.CallContract(ctx, callMsg, nil)
+	if err != nil || len(result) == 0 {
+		logger.Warn("Failed to get weights", "err", err)
+		return
+	}
+	data, err := balancerABI.Unpack("getNormalizedWeights", result)
+	if err != nil {
+		logger.Warn("Failed to unpack weights", "err", err)
+		return
+	}
+	b.Weights = data[0].([]*big.Int)
+
+	// Swap fee
+	callMsg.Data = utils.GetFunctionSelector("getSwapFeePercentage()")
+	result, err = client.CallContract(ctx, callMsg, nil)
+	if err != nil || len(result) == 0 {
+		logger.Warn("Failed to get swap fee", "err", err)
+		return
+	}
+	b.SwapFee = new(big.Int).SetBytes(result)
+
+	// Tokens and balances
+	tokenAddresses, balances, err := b.getPoolTokens(ctx, client, nil)
+	if err != nil {
+		logger.Warn("Failed to get pool tokens", "err", err)
+		return
+	}
+	if len(tokenAddresses) != len(b.Weights) {
+		logger.Warn("Token and weight count mismatch", "tokens", len(tokenAddresses), "weights", len(b.Weights))
+		return
+	}
+	for _, tokenAddr := range tokenAddresses {
+		token := loadToken(ctx, client, tokens, tokenAddr)
+		if token == nil {
+			logger.Warn("Failed to initialise token", "token", tokenAddr)
+			return
+		}
+		b.Tokens = append(b.Tokens, token)
+	}
+	b.Balances = balances
+	b.Initialized = true
+}
+
+func (b *BalancerPool) UpdateReserves(ctx context.Context, client Client, blockHash *common.Hash) error {
+	_, balances, err := b.getPoolTokens(ctx, client, blockHash)
+	if err != nil {
+		return fmt.Errorf("failed to get balances for %s: %v", b.ContractAddress, err)
+	}
+	if len(balances) != len(b.Tokens) {
+		return fmt.Errorf("token count changed for %s", b.ContractAddress)
+	}
+	b.Balances = balances
+	return nil
+}
+
+func (b *BalancerPool) getPoolTokens(ctx context.Context, client Client, blockHash *common.Hash) ([]common.Address, []*big.Int, error) {
+	input, err := balancerABI.Pack("getPoolTokens", b.PoolId)
+	if err != nil {
+		return nil, nil, err
+	}
+	callMsg := ethereum.CallMsg{
+		To:   &BalancerVaultAddress,
+		Data: input,
+	}
+	result, err := callContract(ctx, client, callMsg, blockHash)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := balancerABI.Unpack("getPoolTokens", result)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data[0].([]common.Address), data[1].([]*big.Int), nil
+}
+
+func (b *BalancerPool) Clone() Pool {
+	clone := *b
+	clone.Balances = append([]*big.Int(nil), b.Balances...)
+	return &clone
+}
+
+func (b *BalancerPool) ApplySwap(tokenIn, tokenOut *ERC20Token, amountIn, amountOut *big.Int) {
+	applySwap(b.Tokens, b.Balances, tokenIn, tokenOut, amountIn, amountOut)
+}
+
+func (b *BalancerPool) GetAddress() common.Address {
+	return b.ContractAddress
+}
+
+func (b *BalancerPool) GetTokens() []*ERC20Token {
+	return b.Tokens
+}
+
+func (b *BalancerPool) IsInitialized() bool {
+	return b.Initialized
+}
+
+func (b *BalancerPool) GetReserve(token *ERC20Token) *big.Int {
+	i := tokenIndex(b.Tokens, token)
+	if i < 0 {
+		return big.NewInt(0)
+	}
+	return b.Balances[i]
+}
+
+// Spot price of tokenIn in tokenOut, excluding fees:
+// (balanceOut / weightOut) / (balanceIn / weightIn)
+func (b *BalancerPool) GetSpotPrice(tokenIn, tokenOut *ERC20Token) *big.Float {
+	i := tokenIndex(b.Tokens, tokenIn)
+	j := tokenIndex(b.Tokens, tokenOut)
+	if i < 0 || j < 0 || i == j || b.Balances[i].Sign() == 0 || b.Balances[j].Sign() == 0 {
+		return big.NewFloat(0)
+	}
+	numerator := new(big.Float).Mul(new(big.Float).SetInt(b.Balances[j]), new(big.Float).SetInt(b.Weights[i]))
+	denominator := new(big.Float).Mul(new(big.Float).SetInt(b.Balances[i]), new(big.Float).SetInt(b.Weights[j]))
+	price := numerator.Quo(numerator, denominator)
+	price.Mul(price, big.NewFloat(math.Pow10(tokenIn.Decimals-tokenOut.Decimals)))
+	return price
+}
+
+// Weighted math outGivenIn, with the swap fee taken from amountIn:
+// amountOut = balanceOut * (1 - (balanceIn / (balanceIn + amountIn)) ^ (weightIn / weightOut))
+// The power is evaluated in float64, which matches the formula to a few parts
+// in 1e16 and a wei. The Vault instead rounds its fixed point power up by up
+// to 1e-14 to cover LogExpMath's error, so it can pay up to about 2e-14 of
+// balanceOut less than quoted. That is most of a small trade, so the pool
+// IsApproximate.
+func (b *BalancerPool) GetAmountOut(tokenIn, tokenOut *ERC20Token, amountIn *big.Int) *big.Int {
+	i := tokenIndex(b.Tokens, tokenIn)
+	j := tokenIndex(b.Tokens, tokenOut)
+	if i < 0 || j < 0 || i == j || amountIn.Sign() <= 0 || b.Balances[i].Sign() == 0 {
+		return big.NewInt(0)
+	}
+	balanceIn := new(big.Float).SetInt(b.Balances[i])
+	balanceOut := new(big.Float).SetInt(b.Balances[j])
+
+	// Subtract swap fee
+	amountInf := new(big.Float).SetInt(amountIn)
+	fee := new(big.Float).Quo(new(big.Float).SetInt(b.SwapFee), balancerOne)
+	amountInf.Mul(amountInf, new(big.Float).Sub(big.NewFloat(1), fee))
+
+	if new(big.Float).Quo(amountInf, balanceIn).Cmp(balancerMaxInRatio) == 1 {
+		return big.NewInt(0)
+	}
+
+	// 1 - base^ratio as -expm1(ratio * log1p(base - 1)), which keeps its
+	// precision when the trade is small and the power close to 1
+	ratio, _ := new(big.Float).Quo(amountInf, new(big.Float).Add(balanceIn, amountInf)).Float64()
+	complement := -math.Expm1(b.weightRatio(i, j) * math.Log1p(-ratio))
+	amountOut := balanceOut.Mul(balanceOut, big.NewFloat(complement))
+	result, _ := amountOut.Int(nil)
+	return result
+}
+
+// Weighted math inGivenOut, with the swap fee added to the returned amount:
+// amountIn = balanceIn * ((balanceOut / (balanceOut - amountOut)) ^ (weightOut / weightIn) - 1)
+func (b *BalancerPool) GetAmountIn(tokenIn, tokenOut *ERC20Token, amountOut *big.Int) *big.Int {
+	i := tokenIndex(b.Tokens, tokenIn)
+	j := tokenIndex(b.Tokens, tokenOut)
+	if i < 0 || j < 0 || i == j || amountOut.Sign() <= 0 || b.Balances[j].Sign() == 0 {
+		return big.NewInt(0)
+	}
+	balanceIn := new(big.Float).SetInt(b.Balances[i])
+	balanceOut := new(big.Float).SetInt(b.Balances[j])
+	amountOutf := new(big.Float).SetInt(amountOut)
+
+	if new(big.Float).Quo(amountOutf, balanceOut).Cmp(balancerMaxOutRatio) == 1 {
+		return big.NewInt(0)
+	}
+
+	// base^ratio - 1 as expm1(-ratio * log1p(-amountOut / balanceOut))
+	ratio, _ := new(big.Float).Quo(amountOutf, balanceOut).Float64()
+	power := math.Expm1(-b.weightRatio(j, i) * math.Log1p(-ratio))
+	amountIn := balanceIn.Mul(balanceIn, big.NewFloat(power))
+
+	// Add swap fee
+	fee := new(big.Float).Quo(new(big.Float).SetInt(b.SwapFee), balancerOne)
+	amountIn.Quo(amountIn, new(big.Float).Sub(big.NewFloat(1), fee))
+	result, _ := amountIn.Int(nil)
+	return result
+}
+
+func (b *BalancerPool) weightRatio(i, j int) float64 {
+	ratio, _ := new(big.Float).Quo(new(big.Float).SetInt(b.Weights[i]), new(big.Float).SetInt(b.Weights[j])).Float64()
+	return ratio
+}
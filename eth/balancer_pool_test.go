@@ -0,0 +1,106 @@
+package eth
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func newTestToken(address string, symbol string, decimals int) *ERC20Token {
+	token := NewERC20Token(common.HexToAddress(address))
+	token.Symbol = symbol
+	token.Decimals = decimals
+	token.Initalized = true
+	return token
+}
+
+func newTestBalancerPool(weight0, weight1 int64, balance0, balance1 *big.Int) *BalancerPool {
+	pool := NewBalancerPool("0x5c6Ee304399DBdB9C8Ef030aB642B10820DB8F56")
+	pool.Tokens = []*ERC20Token{
+		newTestToken("0xba100000625a3754423978a60c9317c58a424e3D", "BAL", 18),
+		newTestToken("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2", "WETH", 18),
+	}
+	pool.Balances = []*big.Int{balance0, balance1}
+	pool.Weights = []*big.Int{big.NewInt(weight0), big.NewInt(weight1)}
+	pool.SwapFee = big.NewInt(0)
+	pool.Initialized = true
+	return pool
+}
+
+func withinRelative(a, b *big.Int, tolerance float64) bool {
+	diff := new(big.Float).SetInt(new(big.Int).Sub(a, b))
+	diff.Abs(diff)
+	bound := new(big.Float).Mul(new(big.Float).SetInt(b), big.NewFloat(tolerance))
+	return diff.Cmp(bound) <= 0
+}
+
+func TestBalancerPoolWeightedMath(t *testing.T) {
+	fmt.Println("TestBalancerPoolWeightedMath")
+	ether := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	balance0 := new(big.Int).Mul(big.NewInt(1_000_000), ether)
+	balance1 := new(big.Int).Mul(big.NewInt(2_000), ether)
+
+	// A 50/50 pool without fees is a constant product pool
+	pool := newTestBalancerPool(5e17, 5e17, balance0, balance1)
+	amountIn := new(big.Int).Mul(big.NewInt(10_000), ether)
+	expected := new(big.Int).Mul(amountIn, balance1)
+	expected.Quo(expected, new(big.Int).Add(balance0, amountIn))
+	amountOut := pool.GetAmountOut(pool.Tokens[0], pool.Tokens[1], amountIn)
+	if !withinRelative(amountOut, expected, 1e-12) {
+		t.Errorf("Expected %s, got %s", expected, amountOut)
+	}
+
+	// Small trades keep their precision although the power is close to 1
+	amountIn = new(big.Int).Exp(big.NewInt(10), big.NewInt(15), nil)
+	expected = new(big.Int).Mul(amountIn, balance1)
+	expected.Quo(expected, new(big.Int).Add(balance0, amountIn))
+	amountOut = pool.GetAmountOut(pool.Tokens[0], pool.Tokens[1], amountIn)
+	if !withinRelative(amountOut, expected, 1e-12) {
+		t.Errorf("Expected %s, got %s", expected, amountOut)
+	}
+	amountIn = new(big.Int).Mul(big.NewInt(10_000), ether)
+
+	// inGivenOut inverts outGivenIn on an 80/20 pool with fees
+	pool = newTestBalancerPool(8e17, 2e17, balance0, balance1)
+	pool.SwapFee = big.NewInt(3e15)
+	amountOut = pool.GetAmountOut(pool.Tokens[0], pool.Tokens[1], amountIn)
+	roundTrip := pool.GetAmountIn(pool.Tokens[0], pool.Tokens[1], amountOut)
+	if !withinRelative(roundTrip, amountIn, 1e-9) {
+		t.Errorf("Expected %s, got %s", amountIn, roundTrip)
+	}
+
+	// Spot price of BAL in WETH: (2000 / 0.2) / (1000000 / 0.8) = 0.008
+	price, _ := pool.GetSpotPrice(pool.Tokens[0], pool.Tokens[1]).Float64()
+	if price < 0.008-1e-12 || price > 0.008+1e-12 {
+		t.Errorf("Expected spot price 0.008, got %v", price)
+	}
+
+	// Trades over the max in ratio are rejected
+	tooLarge := new(big.Int).Quo(balance0, big.NewInt(2))
+	if out := pool.GetAmountOut(pool.Tokens[0], pool.Tokens[1], tooLarge); out.Sign() != 0 {
+		t.Errorf("Expected 0 for trade over max in ratio, got %s", out)
+	}
+}
+
+func TestBalancerPool(t *testing.T) {
+	fmt.Println("TestBalancerPool")
+	poolAddr := "0x5c6Ee304399DBdB9C8Ef030aB642B10820DB8F56" // 80BAL-20WETH
+	pool := NewBalancerPool(poolAddr)
+	client := dialTestNode(t)
+	var tokens = &sync.Map{}
+	pool.Initialize(context.Background(), client, tokens)
+	if !pool.Initialized || len(pool.Tokens) != 2 {
+		t.Fatalf("Failed to initialise pool %s", poolAddr)
+	}
+	totalWeight := new(big.Int)
+	for _, weight := range pool.Weights {
+		totalWeight.Add(totalWeight, weight)
+	}
+	if totalWeight.Cmp(big.NewInt(1e18)) != 0 {
+		t.Errorf("Expected weights to sum to 1e18, got %s", totalWeight)
+	}
+}
@@ -0,0 +1,80 @@
+package eth
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Client is the subset of the node API used to read pool and token state.
+// It is satisfied by *ethclient.Client.
+type Client interface {
+	CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
+	CallContractAtHash(ctx context.Context, msg ethereum.CallMsg, blockHash common.Hash) ([]byte, error)
+}
+
+type timeoutClient struct {
+	client  Client
+	timeout time.Duration
+}
+
+// Bound every call made through client by timeout, so a hung node cannot
+// block a caller forever. A zero timeout leaves calls unbounded.
+func WithCallTimeout(client Client, timeout time.Duration) Client {
+	if timeout <= 0 {
+		return client
+	}
+	return &timeoutClient{client: client, timeout: timeout}
+}
+
+func (c *timeoutClient) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.client.CallContract(ctx, msg, blockNumber)
+}
+
+func (c *timeoutClient) CallContractAtHash(ctx context.Context, msg ethereum.CallMsg, blockHash common.Hash) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.client.CallContractAtHash(ctx, msg, blockHash)
+}
+
+// RateLimiter paces requests, blocking until the next one may start
+type RateLimiter interface {
+	Wait(ctx context.Context) error
+}
+
+type rateLimitedClient struct {
+	client  Client
+	limiter RateLimiter
+}
+
+// Pace every call made through client with limiter
+func WithRateLimit(client Client, limiter RateLimiter) Client {
+	return &rateLimitedClient{client: client, limiter: limiter}
+}
+
+func (c *rateLimitedClient) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.client.CallContract(ctx, msg, blockNumber)
+}
+
+func (c *rateLimitedClient) CallContractAtHash(ctx context.Context, msg ethereum.CallMsg, blockHash common.Hash) ([]byte, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.client.CallContractAtHash(ctx, msg, blockHash)
+}
+
+// Call a contract at blockHash, or at the latest block if blockHash is nil
+func callContract(ctx context.Context, client Client, msg ethereum.CallMsg, blockHash *common.Hash) ([]byte, error) {
+	if blockHash == nil {
+		return client.CallContract(ctx, msg, nil)
+	}
+	return client.CallContractAtHash(ctx, msg, *blockHash)
+}
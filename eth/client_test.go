@@ -0,0 +1,70 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// Dial the mainnet node named by GETHMATE_NODE_URL, skipping the test when
+// none is set or -short is given
+func dialTestNode(t *testing.T) *ethclient.Client {
+	t.Helper()
+	url := os.Getenv("GETHMATE_NODE_URL")
+	if url == "" || testing.Short() {
+		t.Skip("Set GETHMATE_NODE_URL to a mainnet node to run tests against live pools")
+	}
+	client, err := ethclient.Dial(url)
+	if err != nil {
+		t.Fatalf("Failed to connect to the Ethereum client: %v", err)
+	}
+	return client
+}
+
+// Blocks every call until its context is done
+type hungClient struct{}
+
+func (c *hungClient) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (c *hungClient) CallContractAtHash(ctx context.Context, msg ethereum.CallMsg, blockHash common.Hash) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestWithCallTimeout(t *testing.T) {
+	fmt.Println("TestWithCallTimeout")
+	client := WithCallTimeout(&hungClient{}, 10*time.Millisecond)
+	pool := NewUniswapPool("0x0d4a11d5eeaac28ec3f61d100daf4d40471f1852")
+	blockHash := common.HexToHash("0x01")
+
+	done := make(chan error, 1)
+	go func() {
+		done <- pool.UpdateReserves(context.Background(), client, &blockHash)
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("Expected timeout error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Call was not bounded by timeout")
+	}
+
+	// Cancelling the parent context also aborts the call
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := WithCallTimeout(&hungClient{}, time.Hour).CallContract(ctx, ethereum.CallMsg{}, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
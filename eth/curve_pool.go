@@ -0,0 +1,321 @@
+package eth
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/big"
+	"sync"
+
+	"gethmate/utils"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const curveMaxCoins = 8
+
+var (
+	curveFeeDenominator = big.NewInt(10_000_000_000)
+	curvePrecision      = new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+)
+
+// CurvePool is a plain Curve StableSwap pool. Rates are derived from coin
+// decimals, so lending pools whose rates come from cTokens/yTokens are not
+// supported.
+type CurvePool struct {
+	ContractAddress common.Address `json:"contract_address"`
+	Coins           []*ERC20Token  `json:"coins"`
+	Balances        []*big.Int     `json:"balances"`
+	A               *big.Int       `json:"a"`
+	Fee             *big.Int       `json:"fee"`
+	Initialized     bool
+
+	// Older pools (e.g. 3pool) index coins and balances with int128 instead of uint256
+	Int128Indices bool `json:"int128_indices"`
+}
+
+func NewCurvePool(contractAddress string) *CurvePool {
+	return &CurvePool{
+		ContractAddress: common.HexToAddress(contractAddress),
+		Initialized:     false,
+	}
+}
+
+func (c *CurvePool) Initialize(ctx context.Context, client Client, tokens *sync.Map) {
+	logger := slog.With("pool", c.ContractAddress)
+	// Coins. The number of coins is not exposed, so read until the call reverts.
+	for i := 0; i < curveMaxCoins; i++ {
+		result, err := c.callIndexed(ctx, client, "coins", i, nil)
+		if (err != nil || len(result) == 0) && i == 0 && !c.Int128Indices {
+			c.Int128Indices = true
+			result, err = c.callIndexed(ctx, client, "coins", i, nil)
+		}
+		if err != nil || len(result) == 0 {
+			break
+		}
+		coinAddr := common.HexToAddress(hex.EncodeToString(result))
+		coin := loadToken(ctx, client, tokens, coinAddr)
+		if coin == nil {
+			logger.Warn("Failed to initialise coin", "index", i, "token", coinAddr)
+			return
+		}
+		c.Coins = append(c.Coins, coin)
+	}
+	if len(c.Coins) < 2 {
+		logger.Warn("Failed to get coins", "coins", len(c.Coins))
+		return
+	}
+
+	// Amplification coefficient
+	callMsg := ethereum.CallMsg{
+		To:   &c.ContractAddress,
+		Data: utils.GetFunctionSelector("A()"),
+	}
+	result, err := client.CallContract(ctx, callMsg, nil)
+	if err != nil || len(result) == 0 {
+		logger.Warn("Failed to get A", "err", err)
+		return
+	}
+	c.A = new(big.Int).SetBytes(result)
+
+	// Fee
+	callMsg.Data = utils.GetFunctionSelector("fee()")
+	result, err = client.CallContract(ctx, callMsg, nil)
+	if err != nil || len(result) == 0 {
+		logger.Warn("Failed to get fee", "err", err)
+		return
+	}
+	c.Fee = new(big.Int).SetBytes(result)
+
+	// Balances
+	if err := c.UpdateReserves(ctx, client, nil); err != nil {
+		logger.Warn("Failed to get balances", "err", err)
+		return
+	}
+	c.Initialized = true
+}
+
+func (c *CurvePool) UpdateReserves(ctx context.Context, client Client, blockHash *common.Hash) error {
+	balances := make([]*big.Int, len(c.Coins))
+	for i := range c.Coins {
+		result, err := c.callIndexed(ctx, client, "balances", i, blockHash)
+		if err != nil || len(result) == 0 {
+			return fmt.Errorf("failed to get balance %d for %s: %v", i, c.ContractAddress, err)
+		}
+		balances[i] = new(big.Int).SetBytes(result)
+	}
+	c.Balances = balances
+	return nil
+}
+
+func (c *CurvePool) callIndexed(ctx context.Context, client Client, method string, i int, blockHash *common.Hash) ([]byte, error) {
+	signature := method + "(uint256)"
+	if c.Int128Indices {
+		signature = method + "(int128)"
+	}
+	callMsg := ethereum.CallMsg{
+		To:   &c.ContractAddress,
+		Data: utils.GetFunctionSelector(signature),
+	}
+	callMsg.Data = append(callMsg.Data, common.LeftPadBytes(big.NewInt(int64(i)).Bytes(), 32)...)
+	return callContract(ctx, client, callMsg, blockHash)
+}
+
+func (c *CurvePool) Clone() Pool {
+	clone := *c
+	clone.Balances = append([]*big.Int(nil), c.Balances...)
+	return &clone
+}
+
+// The admin share of the fee, which also leaves the pool, is ignored
+func (c *CurvePool) ApplySwap(tokenIn, tokenOut *ERC20Token, amountIn, amountOut *big.Int) {
+	applySwap(c.Coins, c.Balances, tokenIn, tokenOut, amountIn, amountOut)
+}
+
+func (c *CurvePool) GetAddress() common.Address {
+	return c.ContractAddress
+}
+
+func (c *CurvePool) GetTokens() []*ERC20Token {
+	return c.Coins
+}
+
+func (c *CurvePool) IsInitialized() bool {
+	return c.Initialized
+}
+
+func (c *CurvePool) GetReserve(token *ERC20Token) *big.Int {
+	i := tokenIndex(c.Coins, token)
+	if i < 0 {
+		return big.NewInt(0)
+	}
+	return c.Balances[i]
+}
+
+// Marginal price of tokenIn in tokenOut, excluding fees. Approximated by
+// quoting a trade of one millionth of the input balance.
+func (c *CurvePool) GetSpotPrice(tokenIn, tokenOut *ERC20Token) *big.Float {
+	i := tokenIndex(c.Coins, tokenIn)
+	j := tokenIndex(c.Coins, tokenOut)
+	if i < 0 || j < 0 || i == j || c.Balances[i].Sign() == 0 || c.Balances[j].Sign() == 0 {
+		return big.NewFloat(0)
+	}
+	dx := new(big.Int).Quo(c.Balances[i], big.NewInt(1_000_000))
+	if dx.Sign() == 0 {
+		dx.SetInt64(1)
+	}
+	dy := c.getDy(i, j, dx)
+	if dy.Sign() <= 0 {
+		return big.NewFloat(0)
+	}
+	price := new(big.Float).Quo(new(big.Float).SetInt(dy), new(big.Float).SetInt(dx))
+	price.Mul(price, big.NewFloat(math.Pow10(tokenIn.Decimals-tokenOut.Decimals)))
+	return price
+}
+
+// Mirrors the pool's get_dy(i, j, dx), including the swap fee
+func (c *CurvePool) GetAmountOut(tokenIn, tokenOut *ERC20Token, amountIn *big.Int) *big.Int {
+	i := tokenIndex(c.Coins, tokenIn)
+	j := tokenIndex(c.Coins, tokenOut)
+	if i < 0 || j < 0 || i == j || amountIn.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+	dy := c.getDy(i, j, amountIn)
+	if dy.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+	fee := new(big.Int).Mul(c.Fee, dy)
+	fee.Quo(fee, curveFeeDenominator)
+	return dy.Sub(dy, fee)
+}
+
+// Get the output of swapping dx of coin i for coin j before fees are taken
+func (c *CurvePool) getDy(i, j int, dx *big.Int) *big.Int {
+	rates := c.rates()
+	xp := c.xp(rates)
+	for _, x := range xp {
+		if x.Sign() == 0 {
+			return big.NewInt(0)
+		}
+	}
+
+	x := new(big.Int).Mul(dx, rates[i])
+	x.Quo(x, curvePrecision)
+	x.Add(x, xp[i])
+	y := c.getY(i, j, x, xp)
+
+	// dy = (xp[j] - y - 1) * PRECISION / rates[j]
+	dy := new(big.Int).Sub(xp[j], y)
+	dy.Sub(dy, big.NewInt(1))
+	dy.Mul(dy, curvePrecision)
+	return dy.Quo(dy, rates[j])
+}
+
+// Rate of each coin that normalises its balance to 18 decimals, scaled by 1e18
+func (c *CurvePool) rates() []*big.Int {
+	rates := make([]*big.Int, len(c.Coins))
+	for i, coin := range c.Coins {
+		rates[i] = new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(36-coin.Decimals)), nil)
+	}
+	return rates
+}
+
+func (c *CurvePool) xp(rates []*big.Int) []*big.Int {
+	xp := make([]*big.Int, len(c.Balances))
+	for i, balance := range c.Balances {
+		xp[i] = new(big.Int).Mul(rates[i], balance)
+		xp[i].Quo(xp[i], curvePrecision)
+	}
+	return xp
+}
+
+// Solve the StableSwap invariant for D by Newton's method
+func (c *CurvePool) getD(xp []*big.Int) *big.Int {
+	n := big.NewInt(int64(len(xp)))
+	s := new(big.Int)
+	for _, x := range xp {
+		s.Add(s, x)
+	}
+	if s.Sign() == 0 {
+		return s
+	}
+
+	d := new(big.Int).Set(s)
+	ann := new(big.Int).Mul(c.A, n)
+	for k := 0; k < 255; k++ {
+		dP := new(big.Int).Set(d)
+		for _, x := range xp {
+			dP.Mul(dP, d)
+			dP.Quo(dP, new(big.Int).Mul(x, n))
+		}
+		dPrev := d
+
+		// D = (Ann * S + D_P * N) * D / ((Ann - 1) * D + (N + 1) * D_P)
+		numerator := new(big.Int).Mul(ann, s)
+		numerator.Add(numerator, new(big.Int).Mul(dP, n))
+		numerator.Mul(numerator, d)
+		denominator := new(big.Int).Sub(ann, big.NewInt(1))
+		denominator.Mul(denominator, d)
+		denominator.Add(denominator, new(big.Int).Mul(new(big.Int).Add(n, big.NewInt(1)), dP))
+		d = numerator.Quo(numerator, denominator)
+
+		if withinOne(d, dPrev) {
+			break
+		}
+	}
+	return d
+}
+
+// Solve the StableSwap invariant for the new balance of coin j given a new
+// balance x of coin i
+func (c *CurvePool) getY(i, j int, x *big.Int, xp []*big.Int) *big.Int {
+	n := big.NewInt(int64(len(xp)))
+	d := c.getD(xp)
+	ann := new(big.Int).Mul(c.A, n)
+
+	cc := new(big.Int).Set(d)
+	s := new(big.Int)
+	for k := range xp {
+		var xk *big.Int
+		if k == i {
+			xk = x
+		} else if k != j {
+			xk = xp[k]
+		} else {
+			continue
+		}
+		s.Add(s, xk)
+		cc.Mul(cc, d)
+		cc.Quo(cc, new(big.Int).Mul(xk, n))
+	}
+	cc.Mul(cc, d)
+	cc.Quo(cc, new(big.Int).Mul(ann, n))
+	b := new(big.Int).Quo(d, ann)
+	b.Add(b, s)
+
+	y := new(big.Int).Set(d)
+	for k := 0; k < 255; k++ {
+		yPrev := y
+
+		// y = (y*y + c) / (2 * y + b - D)
+		numerator := new(big.Int).Mul(y, y)
+		numerator.Add(numerator, cc)
+		denominator := new(big.Int).Lsh(y, 1)
+		denominator.Add(denominator, b)
+		denominator.Sub(denominator, d)
+		y = numerator.Quo(numerator, denominator)
+
+		if withinOne(y, yPrev) {
+			break
+		}
+	}
+	return y
+}
+
+func withinOne(a, b *big.Int) bool {
+	diff := new(big.Int).Sub(a, b)
+	return diff.CmpAbs(big.NewInt(1)) <= 0
+}
@@ -0,0 +1,106 @@
+package eth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"testing"
+
+	"gethmate/utils"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type curveFixture struct {
+	Address string `json:"address"`
+	Coins   []struct {
+		Address  string `json:"address"`
+		Symbol   string `json:"symbol"`
+		Decimals int    `json:"decimals"`
+	} `json:"coins"`
+	Balances []string `json:"balances"`
+	A        string   `json:"a"`
+	Fee      string   `json:"fee"`
+	GetDy    []struct {
+		I  int    `json:"i"`
+		J  int    `json:"j"`
+		Dx string `json:"dx"`
+		Dy string `json:"dy"`
+	} `json:"get_dy"`
+}
+
+func mustBigInt(t *testing.T, s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		t.Fatalf("Invalid integer %s", s)
+	}
+	return n
+}
+
+func TestCurvePoolGetDy(t *testing.T) {
+	fmt.Println("TestCurvePoolGetDy")
+	jsonBytes, err := os.ReadFile("testdata/curve_3pool.json")
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+	var fixture curveFixture
+	if err := json.Unmarshal(jsonBytes, &fixture); err != nil {
+		t.Fatalf("Failed to parse fixture: %v", err)
+	}
+
+	pool := NewCurvePool(fixture.Address)
+	for _, coin := range fixture.Coins {
+		pool.Coins = append(pool.Coins, newTestToken(coin.Address, coin.Symbol, coin.Decimals))
+	}
+	for _, balance := range fixture.Balances {
+		pool.Balances = append(pool.Balances, mustBigInt(t, balance))
+	}
+	pool.A = mustBigInt(t, fixture.A)
+	pool.Fee = mustBigInt(t, fixture.Fee)
+	pool.Initialized = true
+
+	for _, c := range fixture.GetDy {
+		dy := pool.GetAmountOut(pool.Coins[c.I], pool.Coins[c.J], mustBigInt(t, c.Dx))
+		if dy.Cmp(mustBigInt(t, c.Dy)) != 0 {
+			t.Errorf("get_dy(%d, %d, %s): expected %s, got %s", c.I, c.J, c.Dx, c.Dy, dy)
+		}
+	}
+
+	price := pool.GetSpotPrice(pool.Coins[0], pool.Coins[1])
+	if f, _ := price.Float64(); f < 0.99 || f > 1.01 {
+		t.Errorf("Expected DAI/USDC spot price close to 1, got %v", price)
+	}
+}
+
+func TestCurvePool(t *testing.T) {
+	fmt.Println("TestCurvePool")
+	poolAddr := "0xbEbc44782C7dB0a1A60Cb6fe97d0b483032FF1C7" // 3pool
+	pool := NewCurvePool(poolAddr)
+	client := dialTestNode(t)
+	var tokens = &sync.Map{}
+	pool.Initialize(context.Background(), client, tokens)
+	if !pool.Initialized || len(pool.Coins) != 3 {
+		t.Fatalf("Failed to initialise 3pool")
+	}
+
+	// Compare offline get_dy against the contract
+	dx := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(pool.Coins[0].Decimals+3)), nil)
+	data := utils.GetFunctionSelector("get_dy(int128,int128,uint256)")
+	data = append(data, common.LeftPadBytes(big.NewInt(0).Bytes(), 32)...)
+	data = append(data, common.LeftPadBytes(big.NewInt(1).Bytes(), 32)...)
+	data = append(data, common.LeftPadBytes(dx.Bytes(), 32)...)
+	address := pool.ContractAddress
+	result, err := client.CallContract(context.Background(), ethereum.CallMsg{To: &address, Data: data}, nil)
+	if err != nil {
+		t.Fatalf("Failed to call get_dy: %v", err)
+	}
+	expected := new(big.Int).SetBytes(result)
+	dy := pool.GetAmountOut(pool.Coins[0], pool.Coins[1], dx)
+	if dy.Cmp(expected) != 0 {
+		t.Errorf("Expected get_dy %s, got %s", expected, dy)
+	}
+}
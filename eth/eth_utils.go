@@ -3,126 +3,130 @@ package eth
 import (
 	"context"
 	"encoding/hex"
-	"log"
+	"fmt"
+	"log/slog"
 	"math/big"
 	"sync"
 
 	"gethmate/utils"
+	"gethmate/workers"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
 )
 
-func GetUniswapPools(client *ethclient.Client) []UniswapPool {
+func GetUniswapPools(ctx context.Context, client Client, workers *workers.Pool, tokens *sync.Map) ([]UniswapPool, error) {
 	filename := "prod_addresses.txt"
 	addresses, err := utils.ReadAddressesFromFile(filename)
 	if err != nil {
-		log.Fatalf("Failed to read addresses from file: %v", err)
+		return nil, fmt.Errorf("failed to read pool addresses: %v", err)
 	}
-	numRoutines := 12
-	ch := make(chan int, numRoutines)
-	pools := make([][]UniswapPool, numRoutines)
-	var tokens = &sync.Map{} // TODO: Benchmark whether it is faster to thread and use sync.Map or to run single process with map
-	for i := 0; i < numRoutines; i++ {
-		start := i * int(len(addresses)) / numRoutines
-		end := (i + 1) * int(len(addresses)) / numRoutines
+	pools := make([]*UniswapPool, len(addresses))
+	workers.Run(ctx, len(addresses), func(ctx context.Context, i int) error {
+		pools[i] = NewUniswapPool(addresses[i])
+		pools[i].Initialize(ctx, client, tokens)
+		return nil
+	})
+	return initializedUniswapPools(pools), nil
+}
 
-		pools[i] = make([]UniswapPool, 0)
-		go GetPoolsSubRoutine(client, &addresses, start, end, &pools[i], tokens, ch)
-	}
-	// Wait for all goroutines to finish
-	for i := 0; i < numRoutines; i++ {
-		<-ch
+func GetCurvePools(ctx context.Context, client Client, workers *workers.Pool, tokens *sync.Map) ([]CurvePool, error) {
+	filename := "curve_addresses.txt"
+	addresses, err := utils.ReadAddressesFromFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pool addresses: %v", err)
 	}
-
-	// Combine all pools
-	var allPools []UniswapPool
-	for _, p := range pools {
-		allPools = append(allPools, p...)
+	pools := make([]*CurvePool, len(addresses))
+	workers.Run(ctx, len(addresses), func(ctx context.Context, i int) error {
+		pools[i] = NewCurvePool(addresses[i])
+		pools[i].Initialize(ctx, client, tokens)
+		return nil
+	})
+	var allPools []CurvePool
+	for _, pool := range pools {
+		if pool != nil && pool.Initialized {
+			allPools = append(allPools, *pool)
+		}
 	}
-
-	return allPools
+	return allPools, nil
 }
 
-func GetUniswapPoolsFromFactory(client *ethclient.Client) []UniswapPool {
-	factoryAddress := common.HexToAddress("0x5C69bEe701ef814a2B6a3EDD4B1652CB9cc5aA6f") // Hardcoded uniswap v2 factory address
-	allPairsLength := getAllPairsLength(factoryAddress, client)
-	numRoutines := 12
-	ch := make(chan int, numRoutines)
-	pools := make([][]UniswapPool, numRoutines)
-	var tokens = &sync.Map{} // TODO: Benchmark whether it is faster to thread and use sync.Map or to run single process with map
-
-	for i := 0; i < numRoutines; i++ {
-		start := i * int(allPairsLength) / numRoutines
-		end := (i + 1) * int(allPairsLength) / numRoutines
-
-		pools[i] = make([]UniswapPool, 0)
-		go GetPoolsSubRoutineFromFactory(client, factoryAddress, start, end, &pools[i], tokens, ch)
-	}
-
-	// Wait for all goroutines to finish
-	for i := 0; i < numRoutines; i++ {
-		<-ch
+func GetBalancerPools(ctx context.Context, client Client, workers *workers.Pool, tokens *sync.Map) ([]BalancerPool, error) {
+	filename := "balancer_addresses.txt"
+	addresses, err := utils.ReadAddressesFromFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pool addresses: %v", err)
 	}
-
-	// Combine all pools
-	var allPools []UniswapPool
-	for _, p := range pools {
-		allPools = append(allPools, p...)
+	pools := make([]*BalancerPool, len(addresses))
+	workers.Run(ctx, len(addresses), func(ctx context.Context, i int) error {
+		pools[i] = NewBalancerPool(addresses[i])
+		pools[i].Initialize(ctx, client, tokens)
+		return nil
+	})
+	var allPools []BalancerPool
+	for _, pool := range pools {
+		if pool != nil && pool.Initialized {
+			allPools = append(allPools, *pool)
+		}
 	}
-
-	return allPools
+	return allPools, nil
 }
 
-func GetPoolsSubRoutine(client *ethclient.Client, addresses *[]string, start, end int, pools *[]UniswapPool, tokens *sync.Map, ch chan int) {
-	for i := start; i < end; i++ {
-		pool := NewUniswapPool((*addresses)[i])
-		pool.Initialize(client, tokens)
-		if pool.Initialized {
-			*pools = append(*pools, *pool)
-		}
+func GetUniswapPoolsFromFactory(ctx context.Context, client Client, workers *workers.Pool, tokens *sync.Map) ([]UniswapPool, error) {
+	factoryAddress := UniswapV2FactoryAddress
+	allPairsLength, err := getAllPairsLength(ctx, factoryAddress, client)
+	if err != nil {
+		return nil, err
 	}
-	ch <- 1
+	pools := make([]*UniswapPool, allPairsLength)
+	workers.Run(ctx, len(pools), func(ctx context.Context, i int) error {
+		pool, err := CreateUniswapPair(ctx, factoryAddress, i, client, tokens)
+		if err != nil {
+			slog.Warn("Failed to get pair", "index", i, "err", err)
+			return err
+		}
+		pools[i] = &pool
+		return nil
+	})
+	return initializedUniswapPools(pools), nil
 }
 
-func GetPoolsSubRoutineFromFactory(client *ethclient.Client, factoryAddress common.Address, start, end int, pools *[]UniswapPool, tokens *sync.Map, ch chan int) {
-	for i := start; i < end; i++ {
-		tmpPool := CreateUniswapPair(factoryAddress, i, client, tokens)
-		if tmpPool.Initialized {
-			*pools = append(*pools, tmpPool)
+// Pools that were not reached because the context was cancelled are nil
+func initializedUniswapPools(pools []*UniswapPool) []UniswapPool {
+	var allPools []UniswapPool
+	for _, pool := range pools {
+		if pool != nil && pool.Initialized {
+			allPools = append(allPools, *pool)
 		}
 	}
-	ch <- 1
+	return allPools
 }
 
-func getAllPairsLength(factoryAddress common.Address, client *ethclient.Client) int64 {
+func getAllPairsLength(ctx context.Context, factoryAddress common.Address, client Client) (int64, error) {
 	callMsg := ethereum.CallMsg{
 		To:   &factoryAddress,
 		Data: utils.GetFunctionSelector("allPairsLength()"),
 	}
 
-	ctx := context.Background()
 	result, err := client.CallContract(ctx, callMsg, nil)
 	if err != nil {
-		log.Fatalf("1 Utils.go - %v", err)
+		return 0, fmt.Errorf("failed to get pair count from factory %s: %v", factoryAddress, err)
 	}
 
 	allPairsLength := new(big.Int).SetBytes(result)
-	return allPairsLength.Int64()
+	return allPairsLength.Int64(), nil
 }
 
-func CreateUniswapPair(factoryAddress common.Address, i int, client *ethclient.Client, tokens *sync.Map) UniswapPool {
+func CreateUniswapPair(ctx context.Context, factoryAddress common.Address, i int, client Client, tokens *sync.Map) (UniswapPool, error) {
 	callMsg := ethereum.CallMsg{
 		To:   &factoryAddress,
 		Data: utils.GetFunctionSelector("allPairs(uint256)"),
 	}
 	callMsg.Data = append(callMsg.Data, common.LeftPadBytes(big.NewInt(int64(i)).Bytes(), 32)...)
-	ctx := context.Background()
 
 	result, err := client.CallContract(ctx, callMsg, nil)
 	if err != nil {
-		log.Fatalf("2 Utils.go - %v", err)
+		return UniswapPool{}, fmt.Errorf("failed to get pair %d from factory %s: %v", i, factoryAddress, err)
 	}
 
 	addr := common.HexToAddress(hex.EncodeToString(result))
@@ -130,9 +134,9 @@ func CreateUniswapPair(factoryAddress common.Address, i int, client *ethclient.C
 	pool := UniswapPool{
 		ContractAddress: addr,
 	}
-	pool.Initialize(client, tokens)
+	pool.Initialize(ctx, client, tokens)
 	if !pool.Initialized {
-		log.Printf("Failed to initialise pool %s\n", pool.ContractAddress)
+		slog.Warn("Failed to initialise pool", "pool", pool.ContractAddress)
 	}
-	return pool
+	return pool, nil
 }
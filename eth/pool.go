@@ -0,0 +1,143 @@
+package eth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Pool is a liquidity source that can be traversed by the graph. A pool with
+// n tokens contributes an edge for every pair of its tokens.
+type Pool interface {
+	GetAddress() common.Address
+	GetTokens() []*ERC20Token
+	IsInitialized() bool
+
+	// Refresh reserves as of blockHash (EIP-1898), or the latest block if nil
+	UpdateReserves(ctx context.Context, client Client, blockHash *common.Hash) error
+
+	// Copy the pool, so that refreshing the copy leaves the original unchanged.
+	// Tokens are shared between copies.
+	Clone() Pool
+
+	// Move the reserves by a swap of amountIn of tokenIn for amountOut of
+	// tokenOut, projecting the pool's state after the swap. Only call this on
+	// a Clone.
+	ApplySwap(tokenIn, tokenOut *ERC20Token, amountIn, amountOut *big.Int)
+
+	// Get the spot price of tokenIn in tokenOut, adjusted for decimals
+	GetSpotPrice(tokenIn, tokenOut *ERC20Token) *big.Float
+
+	// Get the amount of tokenOut received for amountIn of tokenIn, including
+	// fees. This is exact, as the pool contract would compute it, unless
+	// IsApproximate reports the pool's maths is only approximated.
+	GetAmountOut(tokenIn, tokenOut *ERC20Token, amountIn *big.Int) *big.Int
+
+	// Get the pool's balance of token
+	GetReserve(token *ERC20Token) *big.Int
+}
+
+func tokenIndex(tokens []*ERC20Token, token *ERC20Token) int {
+	for i, t := range tokens {
+		if t.Equals(token) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Move the balances of a pool holding tokens by a swap. Balances are
+// replaced rather than modified, as a Clone shares them with the original.
+func applySwap(tokens []*ERC20Token, balances []*big.Int, tokenIn, tokenOut *ERC20Token, amountIn, amountOut *big.Int) {
+	i, j := tokenIndex(tokens, tokenIn), tokenIndex(tokens, tokenOut)
+	if i < 0 || j < 0 {
+		return
+	}
+	balances[i] = new(big.Int).Add(balances[i], amountIn)
+	balances[j] = new(big.Int).Sub(balances[j], amountOut)
+}
+
+// Get a token from the shared token cache, initialising and storing it on a miss.
+// Returns nil if the token could not be initialised.
+func loadToken(ctx context.Context, client Client, tokens *sync.Map, address common.Address) *ERC20Token {
+	key := strings.ToLower(address.String())
+	if t, exists := tokens.Load(key); exists {
+		return t.(*ERC20Token)
+	}
+	token := NewERC20Token(address)
+	token.Initialize(ctx, client)
+	if !token.Initalized {
+		return nil
+	}
+	actual, _ := tokens.LoadOrStore(key, token)
+	return actual.(*ERC20Token)
+}
+
+const (
+	UniswapPoolType  = "uniswap_v2"
+	CurvePoolType    = "curve"
+	BalancerPoolType = "balancer_weighted"
+)
+
+type poolJSON struct {
+	Type string          `json:"type"`
+	Pool json.RawMessage `json:"pool"`
+}
+
+func GetPoolType(pool Pool) string {
+	switch pool.(type) {
+	case *UniswapPool:
+		return UniswapPoolType
+	case *CurvePool:
+		return CurvePoolType
+	case *BalancerPool:
+		return BalancerPoolType
+	default:
+		return "unknown"
+	}
+}
+
+// Whether a pool's GetAmountOut only approximates the contract. Balancer
+// weighted pools evaluate their power in float64 rather than the Vault's
+// fixed point LogExpMath, which the Vault rounds by up to 1e-14 of the pool's
+// balance, too far to set a transaction's minimum output from.
+func IsApproximate(pool Pool) bool {
+	_, balancer := pool.(*BalancerPool)
+	return balancer
+}
+
+// Encode a pool with its type, so it can be decoded by UnmarshalPool
+func MarshalPool(pool Pool) ([]byte, error) {
+	data, err := json.Marshal(pool)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(poolJSON{Type: GetPoolType(pool), Pool: data})
+}
+
+func UnmarshalPool(data []byte) (Pool, error) {
+	var envelope poolJSON
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, err
+	}
+	var pool Pool
+	switch envelope.Type {
+	case UniswapPoolType:
+		pool = &UniswapPool{}
+	case CurvePoolType:
+		pool = &CurvePool{}
+	case BalancerPoolType:
+		pool = &BalancerPool{}
+	default:
+		return nil, fmt.Errorf("unknown pool type %q", envelope.Type)
+	}
+	if err := json.Unmarshal(envelope.Pool, pool); err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
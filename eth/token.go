@@ -2,7 +2,7 @@ package eth
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"os"
 	"strings"
 
@@ -11,7 +11,6 @@ import (
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
 )
 
 type ERC20Token struct {
@@ -29,10 +28,12 @@ func NewERC20Token(contractAddress common.Address) *ERC20Token {
 	}
 }
 
-func (t *ERC20Token) Initialize(client *ethclient.Client) {
+func (t *ERC20Token) Initialize(ctx context.Context, client Client) {
+	logger := slog.With("token", t.ContractAddress)
 	jsonBytes, err := os.ReadFile("eth/TokenERC20.json")
 	if err != nil {
-		log.Fatalf("Failed to read TokenERC20.json: %v", err)
+		logger.Error("Failed to read TokenERC20.json", "err", err)
+		return
 	}
 	parsedABI, _ := abi.JSON(strings.NewReader(string(jsonBytes)))
 
@@ -40,16 +41,15 @@ func (t *ERC20Token) Initialize(client *ethclient.Client) {
 		To:   &t.ContractAddress,
 		Data: utils.GetFunctionSelector("name()"),
 	}
-	ctx := context.Background()
 
 	result, err := client.CallContract(ctx, callMsg, nil)
 	if err != nil || len(result) == 0 {
-		log.Printf("failed name initialise (%s)", t.ContractAddress)
+		logger.Warn("Failed to get name", "err", err)
 		return
 	} else {
 		data, err := parsedABI.Unpack("name", result)
 		if err != nil {
-			log.Printf("failed name initialise (%s)", t.ContractAddress)
+			logger.Warn("Failed to get name", "err", err)
 			return
 		} else {
 			t.Name = data[0].(string)
@@ -59,12 +59,12 @@ func (t *ERC20Token) Initialize(client *ethclient.Client) {
 	callMsg.Data = utils.GetFunctionSelector("symbol()")
 	result, err = client.CallContract(ctx, callMsg, nil)
 	if err != nil || len(result) == 0 {
-		log.Printf("failed symbol initialise (%s)", t.ContractAddress)
+		logger.Warn("Failed to get symbol", "err", err)
 		return
 	} else {
 		data, err := parsedABI.Unpack("symbol", result)
 		if err != nil {
-			log.Printf("failed symbol initialise (%s)", t.ContractAddress)
+			logger.Warn("Failed to get symbol", "err", err)
 			return
 		} else {
 			t.Symbol = data[0].(string)
@@ -74,7 +74,7 @@ func (t *ERC20Token) Initialize(client *ethclient.Client) {
 	callMsg.Data = utils.GetFunctionSelector("decimals()")
 	result, err = client.CallContract(ctx, callMsg, nil)
 	if err != nil || len(result) == 0 {
-		log.Printf("failed decimals initialize (%s)", t.ContractAddress)
+		logger.Warn("Failed to get decimals", "err", err)
 		return
 	} else {
 		t.Decimals = int(uint8(result[len(result)-1]))
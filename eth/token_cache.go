@@ -0,0 +1,50 @@
+package eth
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"gethmate/utils"
+)
+
+// Load a token cache written by WriteTokenCache, keyed like the token maps
+// passed to pool initialisation. A missing file gives an empty cache.
+func LoadTokenCache(filename string) (*sync.Map, error) {
+	tokens := &sync.Map{}
+	jsonBytes, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return tokens, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var list []*ERC20Token
+	if err := json.Unmarshal(jsonBytes, &list); err != nil {
+		return nil, err
+	}
+	for _, token := range list {
+		if token.Initalized {
+			tokens.Store(strings.ToLower(token.ContractAddress.String()), token)
+		}
+	}
+	return tokens, nil
+}
+
+func WriteTokenCache(filename string, tokens *sync.Map) error {
+	list := make([]*ERC20Token, 0)
+	tokens.Range(func(key, value any) bool {
+		list = append(list, value.(*ERC20Token))
+		return true
+	})
+	sort.Slice(list, func(i, j int) bool {
+		return strings.ToLower(list[i].ContractAddress.String()) < strings.ToLower(list[j].ContractAddress.String())
+	})
+	jsonBytes, err := json.MarshalIndent(list, "", "    ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(filename, jsonBytes)
+}
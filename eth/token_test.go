@@ -1,6 +1,7 @@
 package eth
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"testing"
@@ -19,9 +20,7 @@ func TestERC20Token(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to connect to the Ethereum client: %v", err)
 	}
-	ch := make(chan int)
-	token.Initialize(client)
-	<-ch
+	token.Initialize(context.Background(), client)
 	if token.Name != "Wrapped Ether" {
 		fmt.Println([]byte(token.Name))
 		fmt.Println([]byte("Wrapped Ether"))
@@ -3,7 +3,8 @@ package eth
 import (
 	"context"
 	"encoding/hex"
-	"log"
+	"fmt"
+	"log/slog"
 	"math"
 	"math/big"
 	"strings"
@@ -13,9 +14,28 @@ import (
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/crypto"
 )
 
+var (
+	UniswapV2FactoryAddress = common.HexToAddress("0x5C69bEe701ef814a2B6a3EDD4B1652CB9cc5aA6f") // Uniswap V2 factory on Eth mainnet
+	UniswapV2RouterAddress  = common.HexToAddress("0x7a250d5630B4cF539739dF2C5dAcb4c659F2488D") // UniswapV2Router02 on Eth mainnet
+)
+
+// keccak256 of the UniswapV2Pair creation code, which the factory deploys
+// every pair with through CREATE2
+var uniswapV2PairInitCodeHash = common.HexToHash("0x96e8ac4277198ff8b6f785478aa9a39f403cb768dd02cbee326c3e7da348845f")
+
+// Get the address of the pair for two tokens without calling the factory.
+// Mirrors UniswapV2Library.pairFor.
+func UniswapPairAddress(factory, tokenA, tokenB common.Address) common.Address {
+	if tokenB.Cmp(tokenA) < 0 {
+		tokenA, tokenB = tokenB, tokenA
+	}
+	salt := crypto.Keccak256Hash(tokenA.Bytes(), tokenB.Bytes())
+	return crypto.CreateAddress2(factory, salt, uniswapV2PairInitCodeHash.Bytes())
+}
+
 type UniswapPool struct {
 	ContractAddress common.Address `json:"contract_address"`
 	Token0          *ERC20Token    `json:"token0"`
@@ -32,31 +52,24 @@ func NewUniswapPool(contractAddress string) *UniswapPool {
 	}
 }
 
-func (u *UniswapPool) Initialize(client *ethclient.Client, tokens *sync.Map) {
+func (u *UniswapPool) Initialize(ctx context.Context, client Client, tokens *sync.Map) {
+	logger := slog.With("pool", u.ContractAddress)
 	// Token0 address
 	callMsg := ethereum.CallMsg{
 		To:   &u.ContractAddress,
 		Data: utils.GetFunctionSelector("token0()"),
 	}
 
-	ctx := context.Background()
 	result, err := client.CallContract(ctx, callMsg, nil)
 	if err != nil || len(result) == 0 {
-		log.Printf("Failed to get token0 for %s: %v\n", u.ContractAddress, err)
+		logger.Warn("Failed to get token0", "err", err)
 		return
 	}
 	t0Addr := common.HexToAddress(hex.EncodeToString(result))
-	t0, exists := tokens.Load(strings.ToLower(t0Addr.String()))
-	if !exists {
-		u.Token0 = NewERC20Token(t0Addr)
-		u.Token0.Initialize(client)
-		if !u.Token0.Initalized {
-			log.Printf("Failed to initialise token0 %s\n", t0Addr.String())
-			return
-		}
-		tokens.Store(strings.ToLower(t0Addr.String()), u.Token0)
-	} else {
-		u.Token0 = t0.(*ERC20Token)
+	u.Token0 = loadToken(ctx, client, tokens, t0Addr)
+	if u.Token0 == nil {
+		logger.Warn("Failed to initialise token0", "token", t0Addr)
+		return
 	}
 
 	// Token1 address
@@ -64,42 +77,41 @@ func (u *UniswapPool) Initialize(client *ethclient.Client, tokens *sync.Map) {
 	callMsg.Data = utils.GetFunctionSelector("token1()")
 	result, err = client.CallContract(ctx, callMsg, nil)
 	if err != nil || len(result) == 0 {
-		log.Printf("Failed to get token1 for %s: %v\n", u.ContractAddress, err)
+		logger.Warn("Failed to get token1", "err", err)
 		return
 	}
 	t1Addr := common.HexToAddress(hex.EncodeToString(result))
-	t1, exists := tokens.Load(strings.ToLower(t1Addr.String()))
-	if !exists {
-		u.Token1 = NewERC20Token(t1Addr)
-		u.Token1.Initialize(client)
-		if !u.Token1.Initalized {
-			log.Printf("Failed to initialise token1 %s\n", t1Addr.String())
-			return
-		}
-		tokens.Store(strings.ToLower(t0Addr.String()), u.Token0)
-	} else {
-		u.Token1 = t1.(*ERC20Token)
+	u.Token1 = loadToken(ctx, client, tokens, t1Addr)
+	if u.Token1 == nil {
+		logger.Warn("Failed to initialise token1", "token", t1Addr)
+		return
 	}
 
 	// Reserves
-	u.UpdateReserves(client)
+	if err := u.UpdateReserves(ctx, client, nil); err != nil {
+		logger.Warn("Failed to get reserves", "err", err)
+		return
+	}
 	u.Initialized = true
 }
 
-func (u *UniswapPool) UpdateReserves(client *ethclient.Client) {
+func (u *UniswapPool) UpdateReserves(ctx context.Context, client Client, blockHash *common.Hash) error {
 	callMsg := ethereum.CallMsg{
 		To:   &u.ContractAddress,
 		Data: utils.GetFunctionSelector("getReserves()"),
 	}
 
-	ctx := context.Background()
-	result, err := client.CallContract(ctx, callMsg, nil)
-	if err != nil || len(result) == 0 {
-		log.Fatalf("Failed to get reserves for %s: %v", u.ContractAddress, err)
+	result, err := callContract(ctx, client, callMsg, blockHash)
+	if err != nil {
+		return fmt.Errorf("failed to get reserves for %s: %v", u.ContractAddress, err)
+	}
+	if len(result) < 64 {
+		return fmt.Errorf("short getReserves result for %s: %d bytes", u.ContractAddress, len(result))
 	}
 
 	u.Reserve0 = new(big.Int).SetBytes(result[0:32])
 	u.Reserve1 = new(big.Int).SetBytes(result[32:64])
+	return nil
 }
 
 func (u UniswapPool) GetK() big.Int {
@@ -132,7 +144,7 @@ func (u UniswapPool) GetPrice(tokenIn string) *big.Float {
 	} else if strings.EqualFold(tokenIn, u.Token1.ContractAddress.String()) {
 		return u.GetToken1Price()
 	} else {
-		log.Println("Token not in pool")
+		slog.Error("Token not in pool", "pool", u.ContractAddress, "token", tokenIn)
 		return &big.Float{}
 	}
 }
@@ -169,7 +181,7 @@ func (u UniswapPool) GetTokenAmountOut(tokenIn ERC20Token, amountIn big.Int) *bi
 	} else if strings.EqualFold(tokenIn.ContractAddress.String(), u.Token1.ContractAddress.String()) {
 		return u.GetToken0Out(amountIn)
 	} else {
-		log.Fatalf("Token %s is not in pool %s", tokenIn.ContractAddress, u.ContractAddress)
+		slog.Error("Token not in pool", "pool", u.ContractAddress, "token", tokenIn.ContractAddress)
 		return &big.Float{}
 	}
 }
@@ -183,3 +195,84 @@ func (u UniswapPool) GetReservesFromTokenContract(contractAddress string) big.In
 		return *big.NewInt(0)
 	}
 }
+
+func (u *UniswapPool) Clone() Pool {
+	clone := *u
+	return &clone
+}
+
+func (u *UniswapPool) ApplySwap(tokenIn, tokenOut *ERC20Token, amountIn, amountOut *big.Int) {
+	if tokenIn.Equals(u.Token0) {
+		u.Reserve0 = new(big.Int).Add(u.Reserve0, amountIn)
+		u.Reserve1 = new(big.Int).Sub(u.Reserve1, amountOut)
+	} else if tokenIn.Equals(u.Token1) {
+		u.Reserve1 = new(big.Int).Add(u.Reserve1, amountIn)
+		u.Reserve0 = new(big.Int).Sub(u.Reserve0, amountOut)
+	}
+}
+
+func (u *UniswapPool) GetAddress() common.Address {
+	return u.ContractAddress
+}
+
+func (u *UniswapPool) GetTokens() []*ERC20Token {
+	return []*ERC20Token{u.Token0, u.Token1}
+}
+
+func (u *UniswapPool) IsInitialized() bool {
+	return u.Initialized
+}
+
+func (u *UniswapPool) GetSpotPrice(tokenIn, tokenOut *ERC20Token) *big.Float {
+	return u.GetPrice(tokenIn.ContractAddress.String())
+}
+
+// Mirrors UniswapV2Library.getAmountOut, including the 0.3% swap fee
+func (u *UniswapPool) GetAmountOut(tokenIn, tokenOut *ERC20Token, amountIn *big.Int) *big.Int {
+	var reserveIn, reserveOut *big.Int
+	if tokenIn.Equals(u.Token0) {
+		reserveIn, reserveOut = u.Reserve0, u.Reserve1
+	} else if tokenIn.Equals(u.Token1) {
+		reserveIn, reserveOut = u.Reserve1, u.Reserve0
+	} else {
+		return big.NewInt(0)
+	}
+	if amountIn.Sign() <= 0 || reserveIn.Sign() == 0 || reserveOut.Sign() == 0 {
+		return big.NewInt(0)
+	}
+
+	amountInWithFee := new(big.Int).Mul(amountIn, big.NewInt(997))
+	numerator := new(big.Int).Mul(amountInWithFee, reserveOut)
+	denominator := new(big.Int).Mul(reserveIn, big.NewInt(1000))
+	denominator.Add(denominator, amountInWithFee)
+	return numerator.Quo(numerator, denominator)
+}
+
+// Get the input needed for amountOut of tokenOut. Mirrors
+// UniswapV2Library.getAmountIn, returning nil when the pool cannot provide
+// amountOut.
+func (u *UniswapPool) GetAmountIn(tokenIn, tokenOut *ERC20Token, amountOut *big.Int) *big.Int {
+	var reserveIn, reserveOut *big.Int
+	if tokenIn.Equals(u.Token0) {
+		reserveIn, reserveOut = u.Reserve0, u.Reserve1
+	} else if tokenIn.Equals(u.Token1) {
+		reserveIn, reserveOut = u.Reserve1, u.Reserve0
+	} else {
+		return nil
+	}
+	if amountOut.Sign() <= 0 || reserveIn.Sign() == 0 || amountOut.Cmp(reserveOut) >= 0 {
+		return nil
+	}
+
+	numerator := new(big.Int).Mul(reserveIn, amountOut)
+	numerator.Mul(numerator, big.NewInt(1000))
+	denominator := new(big.Int).Sub(reserveOut, amountOut)
+	denominator.Mul(denominator, big.NewInt(997))
+	amountIn := numerator.Quo(numerator, denominator)
+	return amountIn.Add(amountIn, big.NewInt(1))
+}
+
+func (u *UniswapPool) GetReserve(token *ERC20Token) *big.Int {
+	reserve := u.GetReservesFromTokenContract(token.ContractAddress.String())
+	return &reserve
+}
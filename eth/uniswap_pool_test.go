@@ -1,11 +1,15 @@
 package eth
 
 import (
+	"context"
 	"fmt"
+	"math/big"
 	"strings"
 	"sync"
 	"testing"
 
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethclient"
 )
 
@@ -18,7 +22,10 @@ func TestUniswapPool(t *testing.T) {
 		t.Fatalf("Failed to connect to the Ethereum client: %v", err)
 	}
 	var tokens = &sync.Map{}
-	pool.Initialize(client, tokens)
+	pool.Initialize(context.Background(), client, tokens)
+	if !pool.Initialized {
+		t.Fatalf("Failed to initialise pool %s", poolAddr)
+	}
 	if !strings.EqualFold(pool.Token0.ContractAddress.String(), "0xc02aaa39b223fe8d0a0e5c4f27ead9083c756cc2") {
 		t.Errorf("Expected 0xc02aaa39b223fe8d0a0e5c4f27ead9083c756cc2, got %s", pool.Token0.ContractAddress.String())
 	}
@@ -30,3 +37,56 @@ func TestUniswapPool(t *testing.T) {
 	fmt.Printf("Reserve0: %v\n", pool.Reserve0)
 	fmt.Printf("Reserve1: %v\n", pool.Reserve1)
 }
+
+func TestUniswapPairAddress(t *testing.T) {
+	fmt.Println("TestUniswapPairAddress")
+	weth := common.HexToAddress("0xc02aaa39b223fe8d0a0e5c4f27ead9083c756cc2")
+	usdt := common.HexToAddress("0xdac17f958d2ee523a2206206994597c13d831ec7")
+	expected := common.HexToAddress("0x0d4a11d5eeaac28ec3f61d100daf4d40471f1852")
+	if pair := UniswapPairAddress(UniswapV2FactoryAddress, weth, usdt); pair != expected {
+		t.Errorf("Expected %s, got %s", expected, pair)
+	}
+	if pair := UniswapPairAddress(UniswapV2FactoryAddress, usdt, weth); pair != expected {
+		t.Errorf("Expected the same pair with the tokens swapped, got %s", pair)
+	}
+}
+
+func TestUniswapGetAmountIn(t *testing.T) {
+	fmt.Println("TestUniswapGetAmountIn")
+	token0 := &ERC20Token{ContractAddress: common.HexToAddress("0x01"), Decimals: 18}
+	token1 := &ERC20Token{ContractAddress: common.HexToAddress("0x02"), Decimals: 18}
+	pool := &UniswapPool{Token0: token0, Token1: token1, Reserve0: big.NewInt(1_000_000), Reserve1: big.NewInt(3_000_000), Initialized: true}
+	amountIn := pool.GetAmountIn(token0, token1, big.NewInt(30_000))
+	// The least input that returns the output
+	if out := pool.GetAmountOut(token0, token1, amountIn); out.Cmp(big.NewInt(30_000)) < 0 {
+		t.Errorf("Expected %s in to return at least 30000, got %s", amountIn, out)
+	}
+	if out := pool.GetAmountOut(token0, token1, new(big.Int).Sub(amountIn, big.NewInt(2))); out.Cmp(big.NewInt(30_000)) >= 0 {
+		t.Errorf("Expected less than %s in to return less than 30000, got %s", amountIn, out)
+	}
+	if pool.GetAmountIn(token0, token1, big.NewInt(3_000_000)) != nil {
+		t.Errorf("Expected the whole reserve to be unobtainable")
+	}
+}
+
+// Returns the same result for every call
+type staticClient struct {
+	result []byte
+}
+
+func (c *staticClient) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return c.result, nil
+}
+
+func (c *staticClient) CallContractAtHash(ctx context.Context, msg ethereum.CallMsg, blockHash common.Hash) ([]byte, error) {
+	return c.result, nil
+}
+
+func TestUniswapUpdateReservesShortResult(t *testing.T) {
+	fmt.Println("TestUniswapUpdateReservesShortResult")
+	pool := NewUniswapPool("0x01")
+	err := pool.UpdateReserves(context.Background(), &staticClient{result: make([]byte, 32)}, nil)
+	if err == nil || !strings.Contains(err.Error(), "short getReserves result") || strings.Contains(err.Error(), "nil") {
+		t.Errorf("Expected a short result error, got %v", err)
+	}
+}
@@ -0,0 +1,277 @@
+// Package executor builds the transactions that execute arbitrage
+// opportunities through an arbitrage contract.
+//
+// The contract is expected to expose
+//
+//	flashArbitrage(address pair, uint256 amount0Out, uint256 amount1Out, bytes data)
+//
+// which calls pair.swap(amount0Out, amount1Out, address(this), data). In its
+// uniswapV2Call callback the contract decodes data as
+//
+//	(Hop[] hops, address repayToken, uint256 repayAmount, uint256 minProfit)
+//
+// trades the borrowed tokens through each hop, reverting if a hop returns
+// less than its minAmountOut, repays repayAmount of repayToken to the pair,
+// and reverts unless at least minProfit of repayToken is left over.
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"gethmate/eth"
+	"gethmate/gas"
+	"gethmate/graph"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Kinds of pool a hop trades through, as the contract dispatches on them
+const (
+	UniswapHop uint8 = 0 // Data is empty
+	CurveHop   uint8 = 1 // Data is abi.encode(int128 i, int128 j)
+)
+
+// Gas limit headroom over the model's estimate, in percent
+const gasHeadroom = 20
+
+const arbitrageABIJSON = `[
+	{"name":"flashArbitrage","type":"function","stateMutability":"nonpayable",
+	 "inputs":[{"name":"pair","type":"address"},{"name":"amount0Out","type":"uint256"},{"name":"amount1Out","type":"uint256"},{"name":"data","type":"bytes"}],
+	 "outputs":[]}
+]`
+
+var arbitrageABI, _ = abi.JSON(strings.NewReader(arbitrageABIJSON))
+
+var (
+	hopsType, _    = abi.NewType("tuple[]", "", hopComponents)
+	addressType, _ = abi.NewType("address", "", nil)
+	uint256Type, _ = abi.NewType("uint256", "", nil)
+	int128Type, _  = abi.NewType("int128", "", nil)
+
+	hopComponents = []abi.ArgumentMarshaling{
+		{Name: "kind", Type: "uint8"},
+		{Name: "pool", Type: "address"},
+		{Name: "tokenIn", Type: "address"},
+		{Name: "tokenOut", Type: "address"},
+		{Name: "minAmountOut", Type: "uint256"},
+		{Name: "data", Type: "bytes"},
+	}
+	// The callback data passed through the pair's swap
+	callbackArguments = abi.Arguments{{Type: hopsType}, {Type: addressType}, {Type: uint256Type}, {Type: uint256Type}}
+	curveArguments    = abi.Arguments{{Type: int128Type}, {Type: int128Type}}
+)
+
+var (
+	ErrNotFlashSwappable = errors.New("the first pool cannot flash swap")
+	ErrApproximate       = errors.New("the pool's output is only approximated")
+)
+
+// Hop is a swap the contract makes after borrowing from the pair
+type Hop struct {
+	Kind         uint8
+	Pool         common.Address
+	TokenIn      common.Address
+	TokenOut     common.Address
+	MinAmountOut *big.Int
+	Data         []byte
+}
+
+// Plan is how an opportunity is executed: borrow the first hop's output from
+// its pair, trade it through the remaining hops, and repay the pair
+type Plan struct {
+	Pair        common.Address
+	Amount0Out  *big.Int
+	Amount1Out  *big.Int
+	Hops        []Hop
+	RepayToken  common.Address
+	RepayAmount *big.Int
+	MinProfit   *big.Int
+	AmountOut   *big.Int // Simulated amount of the repay token the last hop returns
+}
+
+// Config is the arbitrage contract transactions are built for, and the
+// protections they carry
+type Config struct {
+	Contract common.Address
+	ChainID  *big.Int
+	// Tolerance on each hop's simulated output, in basis points
+	SlippageBps uint64
+	// Least profit in the start token's smallest unit the contract must keep
+	// after repaying the pair, zero if nil
+	MinProfit *big.Int
+}
+
+// Executor builds unsigned transactions executing opportunities
+type Executor struct {
+	config Config
+	gas    *gas.Model
+}
+
+func New(config Config, model *gas.Model) *Executor {
+	return &Executor{config: config, gas: model}
+}
+
+// Plan the execution of an opportunity at the reserves it was found on
+func (e *Executor) Plan(opportunity *graph.Opportunity) (*Plan, error) {
+	if len(opportunity.Path) < 2 {
+		return nil, fmt.Errorf("a path of %d hops cannot be flash swapped", len(opportunity.Path))
+	}
+	pair, ok := opportunity.Path[0].Pool.(*eth.UniswapPool)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is a %s pool", ErrNotFlashSwappable, opportunity.Path[0].Pool.GetAddress(), eth.GetPoolType(opportunity.Path[0].Pool))
+	}
+
+	// Simulate every hop at the opportunity's reserves
+	amountIn := graph.ToUnits(opportunity.AmountIn, opportunity.Start.Token.Decimals)
+	nodes := []*graph.Node{opportunity.Start}
+	amounts := []*big.Int{amountIn}
+	for i, edge := range opportunity.Path {
+		current := nodes[i]
+		next := edge.Other(current)
+		amountOut := edge.Pool.GetAmountOut(current.Token, next.Token, amounts[i])
+		if amountOut.Sign() <= 0 {
+			return nil, fmt.Errorf("hop %d through %s returns nothing", i+1, edge.Pool.GetAddress())
+		}
+		nodes = append(nodes, next)
+		amounts = append(amounts, amountOut)
+	}
+	if nodes[len(nodes)-1] != opportunity.Start {
+		return nil, fmt.Errorf("path does not return to %s", opportunity.Start.Token.Symbol)
+	}
+
+	plan := &Plan{
+		Pair:        pair.ContractAddress,
+		Amount0Out:  new(big.Int),
+		Amount1Out:  new(big.Int),
+		RepayToken:  opportunity.Start.Token.ContractAddress,
+		RepayAmount: amountIn,
+		MinProfit:   new(big.Int),
+		AmountOut:   amounts[len(amounts)-1],
+	}
+	if e.config.MinProfit != nil {
+		plan.MinProfit.Set(e.config.MinProfit)
+	}
+	// The first hop's output is borrowed exactly. If the pair's reserves have
+	// moved against us, its constant product check reverts the swap.
+	if nodes[1].Token.Equals(pair.Token0) {
+		plan.Amount0Out = amounts[1]
+	} else {
+		plan.Amount1Out = amounts[1]
+	}
+	minReturn := new(big.Int).Add(plan.RepayAmount, plan.MinProfit)
+	if plan.AmountOut.Cmp(minReturn) < 0 {
+		return nil, fmt.Errorf("path returns %s, less than the %s needed to repay with the minimum profit", plan.AmountOut, minReturn)
+	}
+
+	for i := 1; i < len(opportunity.Path); i++ {
+		hop, err := newHop(opportunity.Path[i].Pool, nodes[i].Token, nodes[i+1].Token)
+		if err != nil {
+			return nil, err
+		}
+		hop.MinAmountOut = e.withSlippage(amounts[i+1])
+		plan.Hops = append(plan.Hops, hop)
+	}
+	// The last hop must also return enough to repay and keep the profit
+	last := &plan.Hops[len(plan.Hops)-1]
+	if last.MinAmountOut.Cmp(minReturn) < 0 {
+		last.MinAmountOut = minReturn
+	}
+	return plan, nil
+}
+
+func (e *Executor) withSlippage(amount *big.Int) *big.Int {
+	bps := e.config.SlippageBps
+	if bps > 10_000 {
+		bps = 10_000
+	}
+	min := new(big.Int).Mul(amount, new(big.Int).SetUint64(10_000-bps))
+	return min.Quo(min, big.NewInt(10_000))
+}
+
+func newHop(pool eth.Pool, tokenIn, tokenOut *eth.ERC20Token) (Hop, error) {
+	// A minimum output set from an approximate quote could revert a trade
+	// the pool would make, or let through one it should not
+	if eth.IsApproximate(pool) {
+		return Hop{}, fmt.Errorf("%w: %s is a %s pool", ErrApproximate, pool.GetAddress(), eth.GetPoolType(pool))
+	}
+	hop := Hop{Pool: pool.GetAddress(), TokenIn: tokenIn.ContractAddress, TokenOut: tokenOut.ContractAddress, Data: []byte{}}
+	switch p := pool.(type) {
+	case *eth.UniswapPool:
+		hop.Kind = UniswapHop
+	case *eth.CurvePool:
+		i, j := coinIndex(p.Coins, tokenIn), coinIndex(p.Coins, tokenOut)
+		if i < 0 || j < 0 {
+			return Hop{}, fmt.Errorf("curve pool %s does not trade %s for %s", p.ContractAddress, tokenIn.Symbol, tokenOut.Symbol)
+		}
+		data, err := curveArguments.Pack(big.NewInt(int64(i)), big.NewInt(int64(j)))
+		if err != nil {
+			return Hop{}, err
+		}
+		hop.Kind, hop.Data = CurveHop, data
+	default:
+		return Hop{}, fmt.Errorf("cannot execute a swap through a %s pool", eth.GetPoolType(pool))
+	}
+	return hop, nil
+}
+
+func coinIndex(coins []*eth.ERC20Token, token *eth.ERC20Token) int {
+	for i, coin := range coins {
+		if coin.Equals(token) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Encode the call to the arbitrage contract executing plan
+func (p *Plan) Calldata() ([]byte, error) {
+	data, err := callbackArguments.Pack(p.Hops, p.RepayToken, p.RepayAmount, p.MinProfit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode callback data: %v", err)
+	}
+	return arbitrageABI.Pack("flashArbitrage", p.Pair, p.Amount0Out, p.Amount1Out, data)
+}
+
+// Build an unsigned EIP-1559 transaction from the arbitrage contract's
+// owner, with the given nonce, executing opportunity in the block after
+// header. The fee cap allows the base fee to double.
+func (e *Executor) Build(opportunity *graph.Opportunity, header *types.Header, nonce uint64) (*types.Transaction, error) {
+	plan, err := e.Plan(opportunity)
+	if err != nil {
+		return nil, err
+	}
+	data, err := plan.Calldata()
+	if err != nil {
+		return nil, err
+	}
+
+	gasUnits := opportunity.GasUnits
+	if gasUnits == 0 {
+		pools := make([]eth.Pool, len(opportunity.Path))
+		for i, edge := range opportunity.Path {
+			pools[i] = edge.Pool
+		}
+		gasUnits = e.gas.EstimatePath(pools)
+	}
+	tip := e.gas.PriorityFee()
+	feeCap := new(big.Int).Set(tip)
+	if header.BaseFee != nil {
+		feeCap.Add(feeCap, new(big.Int).Mul(header.BaseFee, big.NewInt(2)))
+	}
+	contract := e.config.Contract
+	return types.NewTx(&types.DynamicFeeTx{
+		ChainID:   e.config.ChainID,
+		Nonce:     nonce,
+		GasTipCap: tip,
+		GasFeeCap: feeCap,
+		Gas:       gasUnits + gasUnits*gasHeadroom/100,
+		To:        &contract,
+		Value:     new(big.Int),
+		Data:      data,
+	}), nil
+}
@@ -0,0 +1,142 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"gethmate/eth"
+	"gethmate/gas"
+	"gethmate/graph"
+	"gethmate/internal/testutil"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// A WETH -> DAI -> USDC -> WETH cycle where DAI is cheap in the first pool
+func newTestOpportunity() (*graph.Opportunity, *graph.Graph) {
+	market := testutil.CheapDAI()
+	weth, dai, usdc := market.WETH, market.DAI, market.USDC
+	g := graph.NewGraph()
+	market.AddTo(g)
+	path := []*graph.Edge{
+		g.GetEdge(common.HexToAddress("0x01").String(), weth.ContractAddress.String(), dai.ContractAddress.String()),
+		g.GetEdge(common.HexToAddress("0x02").String(), dai.ContractAddress.String(), usdc.ContractAddress.String()),
+		g.GetEdge(common.HexToAddress("0x03").String(), usdc.ContractAddress.String(), weth.ContractAddress.String()),
+	}
+	return &graph.Opportunity{Start: g.GetNode(graph.WETHAddress), Path: path, AmountIn: big.NewFloat(1)}, g
+}
+
+func TestBuild(t *testing.T) {
+	fmt.Println("TestBuild")
+	opportunity, _ := newTestOpportunity()
+	contract := common.HexToAddress("0x0000000000000000000000000000000000000abc")
+	model := gas.NewModel(big.NewInt(2e9))
+	executor := New(Config{Contract: contract, ChainID: big.NewInt(1), SlippageBps: 50, MinProfit: big.NewInt(1e15)}, model)
+	header := &types.Header{Number: big.NewInt(100), BaseFee: big.NewInt(20e9)}
+
+	tx, err := executor.Build(opportunity, header, 7)
+	if err != nil {
+		t.Fatalf("Failed to build: %v", err)
+	}
+	if tx.Type() != types.DynamicFeeTxType || *tx.To() != contract || tx.Nonce() != 7 || tx.ChainId().Int64() != 1 {
+		t.Errorf("Unexpected transaction %+v", tx)
+	}
+	if tx.GasTipCap().Int64() != 2e9 || tx.GasFeeCap().Int64() != 42e9 {
+		t.Errorf("Expected a 2 gwei tip and 42 gwei fee cap, got %s and %s", tx.GasTipCap(), tx.GasFeeCap())
+	}
+	estimate := gas.DefaultBaseGas + 3*gas.DefaultSwapGas[eth.UniswapPoolType]
+	if tx.Gas() != estimate*12/10 {
+		t.Errorf("Expected %d gas, got %d", estimate*12/10, tx.Gas())
+	}
+
+	// The calldata borrows the first hop's DAI and carries the rest of the path
+	method, err := arbitrageABI.MethodById(tx.Data()[:4])
+	if err != nil || method.Name != "flashArbitrage" {
+		t.Fatalf("Expected a flashArbitrage call, got %v", err)
+	}
+	args, err := method.Inputs.Unpack(tx.Data()[4:])
+	if err != nil {
+		t.Fatalf("Failed to decode call: %v", err)
+	}
+	first := opportunity.Path[0].Pool
+	borrowed := first.GetAmountOut(opportunity.Start.Token, opportunity.Path[0].Dest.Token, testutil.Ether(1))
+	if args[0].(common.Address) != first.GetAddress() || args[1].(*big.Int).Sign() != 0 || args[2].(*big.Int).Cmp(borrowed) != 0 {
+		t.Errorf("Expected to borrow %s DAI as token1 of %s, got %v", borrowed, first.GetAddress(), args[:3])
+	}
+	callback, err := callbackArguments.Unpack(args[3].([]byte))
+	if err != nil {
+		t.Fatalf("Failed to decode callback data: %v", err)
+	}
+	hops := *abi.ConvertType(callback[0], new([]Hop)).(*[]Hop)
+	if len(hops) != 2 || hops[0].Pool != common.HexToAddress("0x02") || hops[1].TokenOut != opportunity.Start.Token.ContractAddress {
+		t.Fatalf("Unexpected hops %+v", hops)
+	}
+	if callback[1].(common.Address) != opportunity.Start.Token.ContractAddress || callback[2].(*big.Int).Cmp(testutil.Ether(1)) != 0 ||
+		callback[3].(*big.Int).Int64() != 1e15 {
+		t.Errorf("Expected to repay 1 WETH keeping 0.001, got %v", callback[1:])
+	}
+
+	// Each hop's minimum is its simulated output less the slippage
+	plan, err := executor.Plan(opportunity)
+	if err != nil {
+		t.Fatalf("Failed to plan: %v", err)
+	}
+	usdcOut := opportunity.Path[1].Pool.GetAmountOut(opportunity.Path[1].Start.Token, opportunity.Path[1].Dest.Token, borrowed)
+	minUSDC := new(big.Int).Quo(new(big.Int).Mul(usdcOut, big.NewInt(9_950)), big.NewInt(10_000))
+	if hops[0].MinAmountOut.Cmp(minUSDC) != 0 {
+		t.Errorf("Expected a minimum of %s USDC, got %s", minUSDC, hops[0].MinAmountOut)
+	}
+	minWETH := new(big.Int).Quo(new(big.Int).Mul(plan.AmountOut, big.NewInt(9_950)), big.NewInt(10_000))
+	if hops[1].MinAmountOut.Cmp(minWETH) != 0 {
+		t.Errorf("Expected a minimum of %s WETH, got %s", minWETH, hops[1].MinAmountOut)
+	}
+
+	// A minimum profit the path cannot make is refused rather than sent to revert
+	executor = New(Config{Contract: contract, ChainID: big.NewInt(1), MinProfit: testutil.Ether(1)}, model)
+	if _, err := executor.Build(opportunity, header, 7); err == nil {
+		t.Errorf("Expected a path that cannot make the minimum profit to be refused")
+	}
+}
+
+func TestPlanRequiresFlashSwap(t *testing.T) {
+	fmt.Println("TestPlanRequiresFlashSwap")
+	opportunity, g := newTestOpportunity()
+	curve := &eth.CurvePool{
+		ContractAddress: common.HexToAddress("0x04"),
+		Coins:           []*eth.ERC20Token{opportunity.Start.Token, opportunity.Path[0].Dest.Token},
+		Balances:        []*big.Int{testutil.Ether(100), testutil.Ether(330_000)},
+		A:               big.NewInt(100),
+		Fee:             big.NewInt(4_000_000),
+		Initialized:     true,
+	}
+	g.AddPool(curve)
+	opportunity.Path[0] = g.GetEdge(curve.ContractAddress.String(), opportunity.Start.Token.ContractAddress.String(), opportunity.Path[0].Dest.Token.ContractAddress.String())
+	executor := New(Config{Contract: common.HexToAddress("0xabc"), ChainID: big.NewInt(1)}, gas.NewModel(big.NewInt(0)))
+	if _, err := executor.Plan(opportunity); !errors.Is(err, ErrNotFlashSwappable) {
+		t.Errorf("Expected ErrNotFlashSwappable, got %v", err)
+	}
+}
+
+func TestPlanRefusesApproximatePools(t *testing.T) {
+	fmt.Println("TestPlanRefusesApproximatePools")
+	opportunity, g := newTestOpportunity()
+	dai, usdc := opportunity.Path[1].Start.Token, opportunity.Path[1].Dest.Token
+	balancer := &eth.BalancerPool{
+		ContractAddress: common.HexToAddress("0x04"),
+		Tokens:          []*eth.ERC20Token{dai, usdc},
+		Balances:        []*big.Int{testutil.Ether(10_000_000), big.NewInt(10_000_000e6)},
+		Weights:         []*big.Int{big.NewInt(5e17), big.NewInt(5e17)},
+		SwapFee:         big.NewInt(1e15),
+		Initialized:     true,
+	}
+	g.AddPool(balancer)
+	opportunity.Path[1] = g.GetEdge(balancer.ContractAddress.String(), dai.ContractAddress.String(), usdc.ContractAddress.String())
+	executor := New(Config{Contract: common.HexToAddress("0xabc"), ChainID: big.NewInt(1)}, gas.NewModel(big.NewInt(0)))
+	if _, err := executor.Plan(opportunity); !errors.Is(err, ErrApproximate) {
+		t.Errorf("Expected ErrApproximate, got %v", err)
+	}
+}
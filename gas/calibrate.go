@@ -0,0 +1,152 @@
+package gas
+
+import (
+	"context"
+	"log/slog"
+	"math/big"
+	"strings"
+
+	"gethmate/eth"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Sender of calibration swaps: the beacon deposit contract, which holds more
+// ETH than any swap needs. eth_estimateGas does not require the sender to be
+// an externally owned account, so no funded key is needed.
+var calibrationSender = common.HexToAddress("0x00000000219ab540356cBB839Cbe05303d7705Fa")
+
+// ETH sold by each calibration swap
+var calibrationAmount = big.NewInt(params.Ether / 10)
+
+// Swaps are given a deadline far in the future, as they are only estimated
+var calibrationDeadline = new(big.Int).SetUint64(1 << 40)
+
+const calibrationABIJSON = `[
+	{"name":"swapExactETHForTokens","type":"function","stateMutability":"payable",
+	 "inputs":[{"name":"amountOutMin","type":"uint256"},{"name":"path","type":"address[]"},{"name":"to","type":"address"},{"name":"deadline","type":"uint256"}],
+	 "outputs":[{"name":"amounts","type":"uint256[]"}]},
+	{"name":"swap","type":"function","stateMutability":"payable",
+	 "inputs":[
+		{"name":"singleSwap","type":"tuple","components":[
+			{"name":"poolId","type":"bytes32"},{"name":"kind","type":"uint8"},{"name":"assetIn","type":"address"},
+			{"name":"assetOut","type":"address"},{"name":"amount","type":"uint256"},{"name":"userData","type":"bytes"}]},
+		{"name":"funds","type":"tuple","components":[
+			{"name":"sender","type":"address"},{"name":"fromInternalBalance","type":"bool"},
+			{"name":"recipient","type":"address"},{"name":"toInternalBalance","type":"bool"}]},
+		{"name":"limit","type":"uint256"},{"name":"deadline","type":"uint256"}],
+	 "outputs":[{"name":"amountCalculated","type":"uint256"}]}
+]`
+
+var calibrationABI, _ = abi.JSON(strings.NewReader(calibrationABIJSON))
+
+// Vault.swap arguments, matching the tuples in calibrationABI
+type balancerSingleSwap struct {
+	PoolId   [32]byte
+	Kind     uint8
+	AssetIn  common.Address
+	AssetOut common.Address
+	Amount   *big.Int
+	UserData []byte
+}
+
+type balancerFunds struct {
+	Sender              common.Address
+	FromInternalBalance bool
+	Recipient           common.Address
+	ToInternalBalance   bool
+}
+
+// Calibrate the swap gas of each pool type from a swap of ETH through the
+// pool of that type holding the most WETH. Uniswap pairs are swapped through
+// the V2 router and Balancer pools through the Vault, so the estimates carry
+// the router or Vault's overhead too. Curve pools trading native ETH are not
+// loaded, so Curve, like any type without a pool to swap through, keeps its
+// default.
+func (m *Model) CalibratePools(ctx context.Context, estimator Estimator, pools []eth.Pool, weth common.Address) {
+	calls := make(map[string]ethereum.CallMsg)
+	deepest := make(map[string]*big.Int)
+	for _, pool := range pools {
+		reserve := wethReserve(pool, weth)
+		poolType := eth.GetPoolType(pool)
+		if reserve == nil || (deepest[poolType] != nil && reserve.Cmp(deepest[poolType]) <= 0) {
+			continue
+		}
+		if msg, ok := swapCall(pool, weth); ok {
+			calls[poolType] = msg
+			deepest[poolType] = reserve
+		}
+	}
+
+	for _, poolType := range []string{eth.UniswapPoolType, eth.CurvePoolType, eth.BalancerPoolType} {
+		msg, exists := calls[poolType]
+		if !exists {
+			slog.Info("No pool to calibrate swap gas from, keeping the default", "stage", "calibrate", "type", poolType, "gas", m.SwapGas(poolType))
+			continue
+		}
+		if err := m.Calibrate(ctx, estimator, poolType, msg); err != nil {
+			slog.Warn("Failed to calibrate swap gas, keeping the default", "stage", "calibrate", "type", poolType, "pool", msg.To, "err", err)
+			continue
+		}
+		slog.Info("Calibrated swap gas", "stage", "calibrate", "type", poolType, "pool", msg.To, "gas", m.SwapGas(poolType))
+	}
+}
+
+// Get the gas used by a swap through poolType
+func (m *Model) SwapGas(poolType string) uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.swapGas[poolType]
+}
+
+// The pool's WETH balance, or nil if it does not hold WETH
+func wethReserve(pool eth.Pool, weth common.Address) *big.Int {
+	if !pool.IsInitialized() {
+		return nil
+	}
+	for _, token := range pool.GetTokens() {
+		if token.ContractAddress == weth {
+			return pool.GetReserve(token)
+		}
+	}
+	return nil
+}
+
+// Build a call swapping ETH for the other token of a pool holding WETH, for
+// the pool types whose swaps can be paid for in ETH
+func swapCall(pool eth.Pool, weth common.Address) (ethereum.CallMsg, bool) {
+	var other common.Address
+	for _, token := range pool.GetTokens() {
+		if token.ContractAddress != weth {
+			other = token.ContractAddress
+			break
+		}
+	}
+
+	switch p := pool.(type) {
+	case *eth.UniswapPool:
+		// The router only trades the factory's own pairs
+		if eth.UniswapPairAddress(eth.UniswapV2FactoryAddress, p.Token0.ContractAddress, p.Token1.ContractAddress) != p.ContractAddress {
+			return ethereum.CallMsg{}, false
+		}
+		data, err := calibrationABI.Pack("swapExactETHForTokens", big.NewInt(0), []common.Address{weth, other}, calibrationSender, calibrationDeadline)
+		if err != nil {
+			return ethereum.CallMsg{}, false
+		}
+		return ethereum.CallMsg{From: calibrationSender, To: &eth.UniswapV2RouterAddress, Value: calibrationAmount, Data: data}, true
+	case *eth.BalancerPool:
+		// The zero address stands for ETH, which the Vault wraps
+		swap := balancerSingleSwap{PoolId: p.PoolId, AssetOut: other, Amount: calibrationAmount, UserData: []byte{}}
+		funds := balancerFunds{Sender: calibrationSender, Recipient: calibrationSender}
+		data, err := calibrationABI.Pack("swap", swap, funds, big.NewInt(0), calibrationDeadline)
+		if err != nil {
+			return ethereum.CallMsg{}, false
+		}
+		return ethereum.CallMsg{From: calibrationSender, To: &eth.BalancerVaultAddress, Value: calibrationAmount, Data: data}, true
+	default:
+		return ethereum.CallMsg{}, false
+	}
+}
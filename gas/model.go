@@ -0,0 +1,103 @@
+package gas
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"gethmate/eth"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Gas used by an arbitrage transaction before any swaps: the intrinsic
+// transaction cost plus the executing contract's own overhead
+const DefaultBaseGas uint64 = 50_000
+
+// Gas used by a single swap, by pool type. A swap includes the token
+// transfers in and out of the pool.
+var DefaultSwapGas = map[string]uint64{
+	eth.UniswapPoolType:  60_000,
+	eth.CurvePoolType:    130_000,
+	eth.BalancerPoolType: 110_000,
+}
+
+// Estimator is satisfied by *ethclient.Client and *rpcpool.Pool
+type Estimator interface {
+	EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64, error)
+}
+
+// Model estimates the gas used by a trade along a path of pools, and what it
+// costs under EIP-1559
+type Model struct {
+	mu          sync.RWMutex
+	baseGas     uint64
+	swapGas     map[string]uint64
+	priorityFee *big.Int // Tip paid per gas, in wei
+}
+
+func NewModel(priorityFee *big.Int) *Model {
+	swapGas := make(map[string]uint64, len(DefaultSwapGas))
+	for poolType, gas := range DefaultSwapGas {
+		swapGas[poolType] = gas
+	}
+	return &Model{
+		baseGas:     DefaultBaseGas,
+		swapGas:     swapGas,
+		priorityFee: priorityFee,
+	}
+}
+
+// Estimate the gas used by a transaction swapping through pools in order
+func (m *Model) EstimatePath(pools []eth.Pool) uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	gas := m.baseGas
+	for _, pool := range pools {
+		swapGas, exists := m.swapGas[eth.GetPoolType(pool)]
+		if !exists {
+			swapGas = DefaultSwapGas[eth.UniswapPoolType]
+		}
+		gas += swapGas
+	}
+	return gas
+}
+
+// Calibrate the gas used by a swap through poolType from eth_estimateGas on
+// msg, a transaction making a single swap through a pool of that type
+func (m *Model) Calibrate(ctx context.Context, estimator Estimator, poolType string, msg ethereum.CallMsg) error {
+	gas, err := estimator.EstimateGas(ctx, msg)
+	if err != nil {
+		return fmt.Errorf("failed to estimate %s swap gas: %v", poolType, err)
+	}
+	if gas <= params.TxGas {
+		return fmt.Errorf("estimate of %d gas for a %s swap is below the intrinsic cost", gas, poolType)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.swapGas[poolType] = gas - params.TxGas
+	return nil
+}
+
+// Get the price paid per gas in a block with header: its base fee plus the
+// priority fee
+func (m *Model) GasPrice(header *types.Header) *big.Int {
+	price := new(big.Int).Set(m.priorityFee)
+	if header.BaseFee != nil {
+		price.Add(price, header.BaseFee)
+	}
+	return price
+}
+
+// Get the tip paid per gas, in wei
+func (m *Model) PriorityFee() *big.Int {
+	return new(big.Int).Set(m.priorityFee)
+}
+
+// Get the cost in wei of gas used in a block with header
+func (m *Model) Cost(gas uint64, header *types.Header) *big.Int {
+	return new(big.Int).Mul(new(big.Int).SetUint64(gas), m.GasPrice(header))
+}
@@ -0,0 +1,112 @@
+package gas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"gethmate/eth"
+	"gethmate/internal/testutil"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Estimates gas by the contract called, recording each call
+type recordingEstimator struct {
+	gas   map[common.Address]uint64
+	calls []ethereum.CallMsg
+}
+
+func (e *recordingEstimator) EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64, error) {
+	e.calls = append(e.calls, msg)
+	return e.gas[*msg.To], nil
+}
+
+type fixedEstimator struct {
+	gas uint64
+	err error
+}
+
+func (e *fixedEstimator) EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64, error) {
+	return e.gas, e.err
+}
+
+func TestModel(t *testing.T) {
+	fmt.Println("TestModel")
+	model := NewModel(big.NewInt(2 * params.GWei))
+	pools := []eth.Pool{eth.NewUniswapPool("0x01"), eth.NewCurvePool("0x02"), eth.NewUniswapPool("0x03")}
+	expected := DefaultBaseGas + 2*DefaultSwapGas[eth.UniswapPoolType] + DefaultSwapGas[eth.CurvePoolType]
+	if gas := model.EstimatePath(pools); gas != expected {
+		t.Errorf("Expected %d gas, got %d", expected, gas)
+	}
+
+	// Cost is charged at the header's base fee plus the priority fee
+	header := &types.Header{BaseFee: big.NewInt(30 * params.GWei)}
+	if cost := model.Cost(100_000, header); cost.Cmp(big.NewInt(100_000*32*params.GWei)) != 0 {
+		t.Errorf("Expected cost of 100000 gas at 32 gwei, got %s", cost)
+	}
+
+	// Calibration replaces the default swap gas with the estimate less the intrinsic cost
+	if err := model.Calibrate(context.Background(), &fixedEstimator{gas: params.TxGas + 95_000}, eth.CurvePoolType, ethereum.CallMsg{}); err != nil {
+		t.Fatalf("Failed to calibrate: %v", err)
+	}
+	expected = DefaultBaseGas + 2*DefaultSwapGas[eth.UniswapPoolType] + 95_000
+	if gas := model.EstimatePath(pools); gas != expected {
+		t.Errorf("Expected %d gas after calibration, got %d", expected, gas)
+	}
+	if err := model.Calibrate(context.Background(), &fixedEstimator{err: errors.New("execution reverted")}, eth.CurvePoolType, ethereum.CallMsg{}); err == nil {
+		t.Errorf("Expected calibration error when estimation fails")
+	}
+	if gas := model.EstimatePath(pools); gas != expected {
+		t.Errorf("Expected failed calibration to keep %d gas, got %d", expected, gas)
+	}
+}
+
+func TestCalibratePools(t *testing.T) {
+	fmt.Println("TestCalibratePools")
+	weth, dai, usdc := testutil.NewToken("WETH", 18), testutil.NewToken("DAI", 18), testutil.NewToken("USDC", 6)
+	balancer := &eth.BalancerPool{
+		ContractAddress: common.HexToAddress("0x04"),
+		PoolId:          common.HexToHash("0x04"),
+		Tokens:          []*eth.ERC20Token{weth, dai},
+		Balances:        []*big.Int{testutil.Ether(100), testutil.Ether(300_000)},
+		Weights:         []*big.Int{big.NewInt(5e17), big.NewInt(5e17)},
+		SwapFee:         big.NewInt(1e15),
+		Initialized:     true,
+	}
+	pools := []eth.Pool{
+		testutil.NewPair(weth, dai, testutil.Ether(100), testutil.Ether(300_000)),
+		testutil.NewPair(usdc, weth, big.NewInt(3_000_000e6), testutil.Ether(1_000)),
+		// Deeper, but not a factory pair the router can trade
+		testutil.NewPool("0x03", weth, dai, testutil.Ether(10_000), testutil.Ether(30_000_000)),
+		balancer,
+	}
+	estimator := &recordingEstimator{gas: map[common.Address]uint64{
+		eth.UniswapV2RouterAddress: params.TxGas + 100_000,
+		eth.BalancerVaultAddress:   params.TxGas + 120_000,
+	}}
+	model := NewModel(big.NewInt(0))
+	model.CalibratePools(context.Background(), estimator, pools, weth.ContractAddress)
+
+	if len(estimator.calls) != 2 {
+		t.Fatalf("Expected a Uniswap and a Balancer swap estimated, got %d calls", len(estimator.calls))
+	}
+	args, err := calibrationABI.Methods["swapExactETHForTokens"].Inputs.Unpack(estimator.calls[0].Data[4:])
+	if err != nil {
+		t.Fatalf("Failed to decode router swap: %v", err)
+	}
+	if path := args[1].([]common.Address); path[1] != usdc.ContractAddress {
+		t.Errorf("Expected the deepest factory pair, WETH/USDC, swapped, got %v", path)
+	}
+	if model.SwapGas(eth.UniswapPoolType) != 100_000 || model.SwapGas(eth.BalancerPoolType) != 120_000 {
+		t.Errorf("Expected calibrated swap gas, got %d and %d", model.SwapGas(eth.UniswapPoolType), model.SwapGas(eth.BalancerPoolType))
+	}
+	if model.SwapGas(eth.CurvePoolType) != DefaultSwapGas[eth.CurvePoolType] {
+		t.Errorf("Expected Curve to keep its default, got %d", model.SwapGas(eth.CurvePoolType))
+	}
+}
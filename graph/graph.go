@@ -1,22 +1,42 @@
 package graph
 
 import (
-	"container/list"
+	"context"
 	"fmt"
-	"log"
-	"math"
+	"log/slog"
 	"math/big"
-	"os"
+	"sort"
 	"strings"
+	"sync"
 
 	"gethmate/eth"
+	"gethmate/metrics"
+	"gethmate/workers"
 
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 )
 
+const WETHAddress = "0xc02aaa39b223fe8d0a0e5c4f27ead9083c756cc2" // Hardcoded WETH contract on Eth mainnet
+
+// Graph is safe for concurrent use through its methods. Code that uses the
+// maps directly must not share the graph. Refreshing reserves replaces pools
+// rather than modifying them, so a Copy is an immutable snapshot.
 type Graph struct {
+	mu sync.RWMutex
+
 	Nodes map[string]*Node
 	Edges map[string]*Edge
+	Pools map[string]eth.Pool
+
+	// Block that each pool's reserves were last read at, keyed like Pools
+	ReserveBlocks map[string]BlockRef
+}
+
+// BlockRef identifies the block a pool's reserves reflect
+type BlockRef struct {
+	Number uint64      `json:"number"`
+	Hash   common.Hash `json:"hash"`
 }
 
 type Node struct {
@@ -27,17 +47,32 @@ type Node struct {
 type Edge struct {
 	Start *Node
 	Dest  *Node
-	Pool  *eth.UniswapPool
+	Pool  eth.Pool
 }
 
 func NewGraph() *Graph {
 	return &Graph{
 		Nodes: make(map[string]*Node),
 		Edges: make(map[string]*Edge),
+		Pools: make(map[string]eth.Pool),
+
+		ReserveBlocks: make(map[string]BlockRef),
 	}
 }
 
+// Edges are keyed by pool and token pair, as multi-token pools contribute an
+// edge for every pair of their tokens
+func edgeKey(poolAddress, startAddress, destAddress string) string {
+	return strings.ToLower(poolAddress + "/" + startAddress + "/" + destAddress)
+}
+
 func (g *Graph) GetNode(tokenAddress string) *Node {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.getNode(tokenAddress)
+}
+
+func (g *Graph) getNode(tokenAddress string) *Node {
 	node, exists := g.Nodes[strings.ToLower(tokenAddress)]
 	if !exists {
 		return nil
@@ -46,8 +81,17 @@ func (g *Graph) GetNode(tokenAddress string) *Node {
 	}
 }
 
-func (g *Graph) GetEdge(poolAddress string) *Edge {
-	edge, exists := g.Edges[strings.ToLower(poolAddress)]
+func (g *Graph) GetEdge(poolAddress, startAddress, destAddress string) *Edge {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.getEdge(poolAddress, startAddress, destAddress)
+}
+
+func (g *Graph) getEdge(poolAddress, startAddress, destAddress string) *Edge {
+	edge, exists := g.Edges[edgeKey(poolAddress, startAddress, destAddress)]
+	if !exists {
+		edge, exists = g.Edges[edgeKey(poolAddress, destAddress, startAddress)]
+	}
 	if !exists {
 		return nil
 	} else {
@@ -55,29 +99,162 @@ func (g *Graph) GetEdge(poolAddress string) *Edge {
 	}
 }
 
-func (g *Graph) AddEdge(pool *eth.UniswapPool) {
-	_, exists := g.Edges[pool.ContractAddress.String()]
+func (g *Graph) GetPool(poolAddress string) eth.Pool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.getPool(poolAddress)
+}
+
+func (g *Graph) getPool(poolAddress string) eth.Pool {
+	pool, exists := g.Pools[strings.ToLower(poolAddress)]
+	if !exists {
+		return nil
+	} else {
+		return pool
+	}
+}
+
+// Get all edges contributed by a pool
+func (g *Graph) GetPoolEdges(poolAddress string) []*Edge {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.getPoolEdges(poolAddress)
+}
+
+func (g *Graph) getPoolEdges(poolAddress string) []*Edge {
+	pool := g.getPool(poolAddress)
+	if pool == nil {
+		return nil
+	}
+	edges := make([]*Edge, 0)
+	tokens := pool.GetTokens()
+	for i := 0; i < len(tokens); i++ {
+		for j := i + 1; j < len(tokens); j++ {
+			edge := g.getEdge(poolAddress, tokens[i].ContractAddress.String(), tokens[j].ContractAddress.String())
+			if edge != nil {
+				edges = append(edges, edge)
+			}
+		}
+	}
+	return edges
+}
+
+// Get every pool that trades a token, ordered by address
+func (g *Graph) GetTokenPools(tokenAddress string) []eth.Pool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	node := g.getNode(tokenAddress)
+	if node == nil {
+		return nil
+	}
+	seen := make(map[eth.Pool]bool)
+	pools := make([]eth.Pool, 0)
+	for _, edge := range node.Edges {
+		if !seen[edge.Pool] {
+			seen[edge.Pool] = true
+			pools = append(pools, edge.Pool)
+		}
+	}
+	sort.Slice(pools, func(i, j int) bool {
+		return strings.ToLower(pools[i].GetAddress().String()) < strings.ToLower(pools[j].GetAddress().String())
+	})
+	return pools
+}
+
+// Get the block a pool's reserves were last read at, false if unknown
+func (g *Graph) GetReserveBlock(poolAddress string) (BlockRef, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	block, exists := g.ReserveBlocks[strings.ToLower(poolAddress)]
+	return block, exists
+}
+
+// Get the pools whose reserves differ from the same pool's in prev, including
+// pools that prev does not have, ordered by address
+func (g *Graph) ChangedPools(prev *Graph) []eth.Pool {
+	if g == prev {
+		return nil
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	prev.mu.RLock()
+	defer prev.mu.RUnlock()
+	changed := make([]eth.Pool, 0)
+	for key, pool := range g.Pools {
+		if prevPool := prev.getPool(key); prevPool == nil || !sameReserves(pool, prevPool) {
+			changed = append(changed, pool)
+		}
+	}
+	sort.Slice(changed, func(i, j int) bool {
+		return strings.ToLower(changed[i].GetAddress().String()) < strings.ToLower(changed[j].GetAddress().String())
+	})
+	return changed
+}
+
+func sameReserves(a, b eth.Pool) bool {
+	if a == b {
+		return true
+	}
+	for _, token := range a.GetTokens() {
+		reserveA, reserveB := a.GetReserve(token), b.GetReserve(token)
+		if reserveA == nil || reserveB == nil {
+			if reserveA != reserveB {
+				return false
+			}
+		} else if reserveA.Cmp(reserveB) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Stats counts the nodes, edges and pools in a graph
+type Stats struct {
+	Nodes int `json:"nodes"`
+	Edges int `json:"edges"`
+	Pools int `json:"pools"`
+}
+
+func (g *Graph) Stats() Stats {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return Stats{Nodes: len(g.Nodes), Edges: len(g.Edges), Pools: len(g.Pools)}
+}
+
+// Add a pool to the graph with an edge for every pair of its tokens
+func (g *Graph) AddPool(pool eth.Pool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	poolAddressLower := strings.ToLower(pool.GetAddress().String())
+	_, exists := g.Pools[poolAddressLower]
 	if exists {
 		return
 	}
-	t0AddressLower := strings.ToLower(pool.Token0.ContractAddress.String())
-	t1AddressLower := strings.ToLower(pool.Token1.ContractAddress.String())
-	startNode, exists := g.Nodes[t0AddressLower]
-	if !exists {
-		g.Nodes[t0AddressLower] = &Node{
-			Token: pool.Token0,
-			Edges: make([]*Edge, 0),
+	g.Pools[poolAddressLower] = pool
+	tokens := pool.GetTokens()
+	for i := 0; i < len(tokens); i++ {
+		for j := i + 1; j < len(tokens); j++ {
+			g.addEdge(pool, tokens[i], tokens[j])
 		}
-		startNode = g.Nodes[t0AddressLower]
 	}
-	destNode, exists := g.Nodes[t1AddressLower]
+}
+
+func (g *Graph) getOrAddNode(token *eth.ERC20Token) *Node {
+	addressLower := strings.ToLower(token.ContractAddress.String())
+	node, exists := g.Nodes[addressLower]
 	if !exists {
-		g.Nodes[t1AddressLower] = &Node{
-			Token: pool.Token1,
+		node = &Node{
+			Token: token,
 			Edges: make([]*Edge, 0),
 		}
-		destNode = g.Nodes[t1AddressLower]
+		g.Nodes[addressLower] = node
 	}
+	return node
+}
+
+func (g *Graph) addEdge(pool eth.Pool, start, dest *eth.ERC20Token) {
+	startNode := g.getOrAddNode(start)
+	destNode := g.getOrAddNode(dest)
 
 	edge := &Edge{
 		Start: startNode,
@@ -85,18 +262,24 @@ func (g *Graph) AddEdge(pool *eth.UniswapPool) {
 		Pool:  pool,
 	}
 
-	g.Edges[strings.ToLower(pool.ContractAddress.String())] = edge
+	g.Edges[edgeKey(pool.GetAddress().String(), start.ContractAddress.String(), dest.ContractAddress.String())] = edge
 
 	startNode.Edges = append(startNode.Edges, edge)
 	destNode.Edges = append(destNode.Edges, edge)
 }
 
 func (g *Graph) RemoveEdge(edge *Edge) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.removeEdge(edge)
+}
+
+func (g *Graph) removeEdge(edge *Edge) {
 	start := edge.Start
 	dest := edge.Dest
-	delete(g.Edges, strings.ToLower(edge.Pool.ContractAddress.String()))
+	delete(g.Edges, edgeKey(edge.Pool.GetAddress().String(), start.Token.ContractAddress.String(), dest.Token.ContractAddress.String()))
 	for i, e := range start.Edges {
-		if strings.EqualFold(e.Pool.ContractAddress.String(), edge.Pool.ContractAddress.String()) {
+		if e == edge {
 			start.Edges = append(start.Edges[:i], start.Edges[i+1:]...)
 			break
 		}
@@ -105,7 +288,7 @@ func (g *Graph) RemoveEdge(edge *Edge) {
 		delete(g.Nodes, strings.ToLower(start.Token.ContractAddress.String()))
 	}
 	for i, e := range dest.Edges {
-		if strings.EqualFold(e.Pool.ContractAddress.String(), edge.Pool.ContractAddress.String()) {
+		if e == edge {
 			dest.Edges = append(dest.Edges[:i], dest.Edges[i+1:]...)
 			break
 		}
@@ -113,131 +296,202 @@ func (g *Graph) RemoveEdge(edge *Edge) {
 	if len(dest.Edges) == 0 {
 		delete(g.Nodes, strings.ToLower(dest.Token.ContractAddress.String()))
 	}
+
+	// Drop the pool once none of its edges remain
+	poolAddress := edge.Pool.GetAddress().String()
+	if len(g.getPoolEdges(poolAddress)) == 0 {
+		delete(g.Pools, strings.ToLower(poolAddress))
+		delete(g.ReserveBlocks, strings.ToLower(poolAddress))
+	}
 }
 
 func (g *Graph) RemoveNode(node *Node) {
-	for _, edge := range node.Edges {
-		g.RemoveEdge(edge)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.removeNode(node)
+}
+
+func (g *Graph) removeNode(node *Node) {
+	// removeEdge mutates node.Edges, so iterate over a copy
+	edges := append([]*Edge(nil), node.Edges...)
+	for _, edge := range edges {
+		g.removeEdge(edge)
 	}
 }
 
-func (g *Graph) TrimNodes(threshold big.Float) {
-	src, exists := g.Nodes["0xc02aaa39b223fe8d0a0e5c4f27ead9083c756cc2"] // Hardcoded WETH contract on Eth mainnet
-	if !exists {
-		log.Fatalln("WETH not found in graph")
+// Refresh the reserves of every pool as of header's block, so that all
+// pools reflect the same state. Pools that fail to refresh keep the block
+// they were last read at. Refreshed copies of the pools are swapped in once
+// every pool has been read, leaving earlier copies of the graph untouched.
+func (g *Graph) UpdateAllEdges(ctx context.Context, client eth.Client, workers *workers.Pool, header *types.Header) error {
+	g.mu.RLock()
+	keys := make([]string, 0, len(g.Pools))
+	pools := make([]eth.Pool, 0, len(g.Pools))
+	for key, pool := range g.Pools {
+		keys = append(keys, key)
+		pools = append(pools, pool)
 	}
+	g.mu.RUnlock()
 
-	for _, edge := range src.Edges {
-		var reserves *big.Float
-		decimals := new(big.Float).SetInt64(int64(math.Pow10(src.Token.Decimals)))
-		if strings.EqualFold(src.Token.ContractAddress.String(), edge.Start.Token.ContractAddress.String()) {
-			reserves = new(big.Float).SetInt(edge.Pool.Reserve0)
-		} else {
-			reserves = new(big.Float).SetInt(edge.Pool.Reserve1)
+	blockHash := header.Hash()
+	refreshed := make([]eth.Pool, len(pools))
+	errs := workers.Run(ctx, len(pools), func(ctx context.Context, i int) error {
+		pool := pools[i].Clone()
+		if err := pool.UpdateReserves(ctx, client, &blockHash); err != nil {
+			return err
 		}
+		refreshed[i] = pool
+		return nil
+	})
 
-		reserves.Quo(reserves, decimals)
-		if reserves.Cmp(&threshold) == -1 {
-			g.RemoveEdge(edge)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	block := BlockRef{Number: header.Number.Uint64(), Hash: blockHash}
+	failed := 0
+	var firstErr error
+	for i, key := range keys {
+		if errs[i] != nil {
+			slog.Debug("Failed to refresh pool", "block", block.Number, "pool", key, "stage", "refresh", "err", errs[i])
+			failed++
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+			continue
 		}
-	}
-
-	// Trim graph with edges removed
-	visited := make(map[*Node]bool)
-	for _, node := range g.Nodes {
-		visited[node] = false
-	}
-	// BFS to find reachable nodes
-	// Delete nodes that have an edge length of 1
-	queue := list.New()
-	queue.PushBack(src)
-
-	for queue.Len() > 0 {
-		// Get current node
-		current := queue.Front().Value.(*Node)
-		queue.Remove(queue.Front())
-		if len(current.Edges) < 2 {
-			g.RemoveNode(current)
-		} else {
-			visited[current] = true
+		// Skip pools removed or replaced while the refresh was running
+		if g.Pools[key] != pools[i] {
+			continue
 		}
-		for _, edge := range current.Edges {
-			if strings.EqualFold(current.Token.ContractAddress.String(), edge.Start.Token.ContractAddress.String()) {
-				if !visited[edge.Dest] {
-					queue.PushBack(edge.Dest)
-				}
-			} else {
-				if !visited[edge.Start] {
-					queue.PushBack(edge.Start)
-				}
-			}
+		g.replacePool(key, refreshed[i])
+		g.ReserveBlocks[key] = block
+	}
+	if failed > 0 {
+		// Pools that failed because the block was abandoned are not skipped
+		// pools, as the block will not be searched anyway
+		if ctx.Err() != nil {
+			return fmt.Errorf("refresh at block %d abandoned with %d of %d pools unread: %w", block.Number, failed, len(keys), context.Cause(ctx))
 		}
+		metrics.PoolsSkipped.With().Add(float64(failed))
+		return fmt.Errorf("failed to update %d of %d pools at block %d: %v", failed, len(keys), block.Number, firstErr)
 	}
+	return nil
+}
 
-	// Iterate through map and remove unvisited nodes
-	for key, val := range visited {
-		if !val {
-			g.RemoveNode(key)
-		}
+func (g *Graph) replacePool(key string, pool eth.Pool) {
+	for _, edge := range g.getPoolEdges(key) {
+		edge.Pool = pool
 	}
-	// Print addresses of nodes that are still in graph to file
-	file, err := os.OpenFile("dev_addresses.txt", os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Println("Failed to open file")
+	g.Pools[key] = pool
+}
+
+// Copy the graph's nodes and edges, sharing its pools and tokens. As pools
+// are replaced rather than modified when refreshed, the copy is unaffected by
+// later changes to the graph.
+func (g *Graph) Copy() *Graph {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	c := NewGraph()
+	nodes := make(map[*Node]*Node, len(g.Nodes))
+	for key, node := range g.Nodes {
+		nodes[node] = &Node{Token: node.Token, Edges: make([]*Edge, 0, len(node.Edges))}
+		c.Nodes[key] = nodes[node]
 	}
-	defer file.Close()
-	for _, edge := range g.Edges {
-		file.WriteString(edge.Pool.ContractAddress.String() + "\n")
+	edges := make(map[*Edge]*Edge, len(g.Edges))
+	for key, edge := range g.Edges {
+		edges[edge] = &Edge{Start: nodes[edge.Start], Dest: nodes[edge.Dest], Pool: edge.Pool}
+		c.Edges[key] = edges[edge]
 	}
-	err = file.Sync()
-	if err != nil {
-		log.Println("Failed to write to file")
+	// Keep each node's edges in the same order, as Strategy depends on it
+	for node, copied := range nodes {
+		for _, edge := range node.Edges {
+			copied.Edges = append(copied.Edges, edges[edge])
+		}
 	}
-	fmt.Println("Trimmed graph")
-}
-
-func (g *Graph) UpdateAllEdges(client *ethclient.Client) {
-	numRoutines := 24
-	ch := make(chan int, numRoutines)
-	keys := make([]string, 0, len(g.Edges))
-	for key := range g.Edges {
-		keys = append(keys, key)
+	for key, pool := range g.Pools {
+		c.Pools[key] = pool
 	}
-	for i := 0; i < numRoutines; i++ {
-		start := i * int(len(g.Edges)) / numRoutines
-		end := (i + 1) * int(len(g.Edges)) / numRoutines
-		go g.updateEdges(client, keys, start, end, ch)
+	for key, block := range g.ReserveBlocks {
+		c.ReserveBlocks[key] = block
 	}
+	return c
+}
 
-	// Wait for all goroutines to finish
-	for i := 0; i < numRoutines; i++ {
-		<-ch
+// Copy the graph with the given pools in place of the pools at their
+// addresses, such as to project the state after a pending swap. Pools not in
+// the graph are ignored.
+func (g *Graph) WithPools(pools ...eth.Pool) *Graph {
+	c := g.Copy()
+	for _, pool := range pools {
+		key := strings.ToLower(pool.GetAddress().String())
+		if c.getPool(key) != nil {
+			c.replacePool(key, pool)
+		}
 	}
+	return c
 }
 
-func (g *Graph) updateEdges(client *ethclient.Client, keys []string, start, end int, ch chan int) {
+// Copy the graph without the pools whose reserves were not read at header's
+// block, such as pools that failed to refresh, returning the number dropped.
+// The copy passes CheckBlockConsistency for header.
+func (g *Graph) CopyAt(header *types.Header) (*Graph, int) {
+	c := g.Copy()
+	blockHash := header.Hash()
+	dropped := 0
+	for key := range c.Pools {
+		if block, exists := c.ReserveBlocks[key]; exists && block.Hash == blockHash {
+			continue
+		}
+		for _, edge := range c.getPoolEdges(key) {
+			c.removeEdge(edge)
+		}
+		// removeEdge drops the pool with its last edge, but not a pool
+		// that has no edges
+		delete(c.Pools, key)
+		delete(c.ReserveBlocks, key)
+		dropped++
+	}
+	return c, dropped
+}
 
-	for i := start; i < end; i++ {
-		edge := g.Edges[keys[i]]
-		edge.Pool.UpdateReserves(client)
+// Check that every pool's reserves were read at header's block. Strategies
+// must not run on a snapshot that mixes state from different blocks.
+func (g *Graph) CheckBlockConsistency(header *types.Header) error {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	blockHash := header.Hash()
+	stale := 0
+	for key := range g.Pools {
+		if block, exists := g.ReserveBlocks[key]; !exists || block.Hash != blockHash {
+			stale++
+		}
 	}
-	ch <- 1
+	if stale > 0 {
+		return fmt.Errorf("%d of %d pools do not reflect block %s (%s)", stale, len(g.Pools), header.Number, blockHash)
+	}
+	return nil
 }
 
 func (g *Graph) PrintGraph() {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
 	for _, node := range g.Nodes {
 		fmt.Printf("Token address: %s\n", node.Token.ContractAddress.String())
 		fmt.Printf("Edges %d\n", len(node.Edges))
 		for _, edge := range node.Edges {
-			fmt.Printf("%s\n", edge.Pool.ContractAddress.String())
+			fmt.Printf("%s\n", edge.Pool.GetAddress().String())
 		}
 	}
 }
 
-func (g *Graph) Strategy(startAmountIn *big.Float) []*Edge {
-	src, exists := g.Nodes["0xc02aaa39b223fe8d0a0e5c4f27ead9083c756cc2"] // Hardcoded WETH contract on Eth mainnet
+// Search for a profitable cycle through WETH. Returns an empty path if none
+// is found or ctx is cancelled.
+func (g *Graph) Strategy(ctx context.Context, startAmountIn *big.Float) []*Edge {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	src, exists := g.Nodes[WETHAddress]
 	if !exists {
-		log.Fatalln("WETH not found in graph")
+		slog.Error("WETH not found in graph", "token", WETHAddress, "stage", "strategy")
+		return make([]*Edge, 0)
 	}
 
 	dist := make(map[*Node]*big.Float)
@@ -252,11 +506,14 @@ func (g *Graph) Strategy(startAmountIn *big.Float) []*Edge {
 
 	// Bellman ford algorithm to traverse graph
 	for _, node := range g.Nodes {
+		if ctx.Err() != nil {
+			return make([]*Edge, 0)
+		}
 		for _, edge := range node.Edges {
 			// If there is a distance to edge.Start, then we can update the distance to edge.Dest
 			if distIn, exists := dist[edge.Start]; exists {
 				// Calculate distance to edge.Dest
-				distOut := new(big.Float).Mul(distIn, edge.Pool.GetPrice(edge.Start.Token.ContractAddress.String()))
+				distOut := new(big.Float).Mul(distIn, edge.Pool.GetSpotPrice(edge.Start.Token, edge.Dest.Token))
 				if distOut.Cmp(dist[edge.Dest]) == 1 {
 					// Only update if edge is not already in prev[edge.Start], otherwise we are going in cycles
 					flag := false
@@ -275,7 +532,7 @@ func (g *Graph) Strategy(startAmountIn *big.Float) []*Edge {
 			// IF there is a distance to edge.Dest, then we can update the distance to edge.Start
 			if distIn, exists := dist[edge.Dest]; exists {
 				// Calculate distance to edge.Start
-				distOut := new(big.Float).Mul(distIn, edge.Pool.GetPrice(edge.Dest.Token.ContractAddress.String()))
+				distOut := new(big.Float).Mul(distIn, edge.Pool.GetSpotPrice(edge.Dest.Token, edge.Start.Token))
 				if distOut.Cmp(dist[edge.Start]) == 1 {
 					// Only update if edge is not already in prev[edge.Start], otherwise we are going in cycles
 					flag := false
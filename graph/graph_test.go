@@ -0,0 +1,734 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gethmate/eth"
+	"gethmate/gas"
+	"gethmate/internal/testutil"
+	"gethmate/workers"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Serves getReserves() for Uniswap pools from an in-memory table
+type fakeClient struct {
+	reserves map[common.Address][2]*big.Int
+	failing  map[common.Address]bool
+}
+
+func (c *fakeClient) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return c.CallContractAtHash(ctx, msg, common.Hash{})
+}
+
+func (c *fakeClient) CallContractAtHash(ctx context.Context, msg ethereum.CallMsg, blockHash common.Hash) ([]byte, error) {
+	if c.failing[*msg.To] {
+		return nil, errors.New("header not found")
+	}
+	reserves, exists := c.reserves[*msg.To]
+	if !exists {
+		return nil, errors.New("execution reverted")
+	}
+	result := common.LeftPadBytes(reserves[0].Bytes(), 32)
+	result = append(result, common.LeftPadBytes(reserves[1].Bytes(), 32)...)
+	return result, nil
+}
+
+func TestUpdateAllEdgesBlockConsistency(t *testing.T) {
+	fmt.Println("TestUpdateAllEdgesBlockConsistency")
+	weth := testutil.NewToken("WETH", 18)
+	dai := testutil.NewToken("DAI", 18)
+	usdc := testutil.NewToken("USDC", 6)
+	pool0 := testutil.NewPool("0x01", weth, dai, testutil.Ether(100), testutil.Ether(300_000))
+	pool1 := testutil.NewPool("0x02", dai, usdc, testutil.Ether(1_000_000), big.NewInt(1_000_000e6))
+
+	g := NewGraph()
+	g.AddPool(pool0)
+	g.AddPool(pool1)
+
+	client := &fakeClient{
+		reserves: map[common.Address][2]*big.Int{
+			pool0.ContractAddress: {testutil.Ether(101), testutil.Ether(297_000)},
+			pool1.ContractAddress: {testutil.Ether(1_000_001), big.NewInt(999_999e6)},
+		},
+		failing: make(map[common.Address]bool),
+	}
+	header := &types.Header{Number: big.NewInt(100)}
+	if err := g.UpdateAllEdges(context.Background(), client, workers.New(4, 0), header); err != nil {
+		t.Fatalf("Failed to update edges: %v", err)
+	}
+	if err := g.CheckBlockConsistency(header); err != nil {
+		t.Errorf("Expected consistent snapshot, got %v", err)
+	}
+	if reserve := g.GetPool(pool0.ContractAddress.String()).GetReserve(weth); reserve.Cmp(testutil.Ether(101)) != 0 {
+		t.Errorf("Expected reserve0 %s, got %s", testutil.Ether(101), reserve)
+	}
+
+	// A pool that fails to refresh leaves the snapshot mixed
+	client.failing[pool1.ContractAddress] = true
+	next := &types.Header{Number: big.NewInt(101), ParentHash: header.Hash()}
+	if err := g.UpdateAllEdges(context.Background(), client, workers.New(4, 0), next); err == nil {
+		t.Errorf("Expected update error for failing pool")
+	}
+	if err := g.CheckBlockConsistency(next); err == nil {
+		t.Errorf("Expected mixed block snapshot to be rejected")
+	}
+	if block := g.ReserveBlocks[strings.ToLower(pool1.ContractAddress.String())]; block.Number != 100 {
+		t.Errorf("Expected failing pool to still reflect block 100, got %d", block.Number)
+	}
+
+	// A copy at the block leaves the failing pool out
+	view, dropped := g.CopyAt(next)
+	if dropped != 1 {
+		t.Errorf("Expected 1 pool dropped, got %d", dropped)
+	}
+	if err := view.CheckBlockConsistency(next); err != nil {
+		t.Errorf("Expected consistent copy, got %v", err)
+	}
+	if view.GetPool(pool1.ContractAddress.String()) != nil || view.GetNode(usdc.ContractAddress.String()) != nil {
+		t.Errorf("Expected failing pool and its only token to be dropped from the copy")
+	}
+	if view.GetPool(pool0.ContractAddress.String()) == nil {
+		t.Errorf("Expected refreshed pool to remain in the copy")
+	}
+	if g.GetPool(pool1.ContractAddress.String()) == nil {
+		t.Errorf("Expected failing pool to remain in the graph")
+	}
+
+	// Pools left unread by an abandoned block are reported as abandoned
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	last := &types.Header{Number: big.NewInt(102), ParentHash: next.Hash()}
+	if err := g.UpdateAllEdges(ctx, client, workers.New(4, 0), last); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected cancelled refresh, got %v", err)
+	}
+}
+
+func TestCopyIsUnaffectedByRefresh(t *testing.T) {
+	fmt.Println("TestCopyIsUnaffectedByRefresh")
+	weth := testutil.NewToken("WETH", 18)
+	dai := testutil.NewToken("DAI", 18)
+	usdc := testutil.NewToken("USDC", 6)
+	pool0 := testutil.NewPool("0x01", weth, dai, testutil.Ether(100), testutil.Ether(300_000))
+	pool1 := testutil.NewPool("0x02", dai, usdc, testutil.Ether(1_000_000), big.NewInt(1_000_000e6))
+	pool2 := testutil.NewPool("0x03", usdc, weth, big.NewInt(3_000_000e6), testutil.Ether(1_000))
+	g := NewGraph()
+	g.AddPool(pool0)
+	g.AddPool(pool1)
+	g.AddPool(pool2)
+	client := &fakeClient{
+		reserves: map[common.Address][2]*big.Int{
+			pool0.ContractAddress: {testutil.Ether(100), testutil.Ether(300_000)},
+			pool1.ContractAddress: {testutil.Ether(1_000_000), big.NewInt(1_000_000e6)},
+			pool2.ContractAddress: {big.NewInt(3_000_000e6), testutil.Ether(1_000)},
+		},
+		failing: make(map[common.Address]bool),
+	}
+	workerPool := workers.New(4, 0)
+	defer workerPool.Close()
+	header := &types.Header{Number: big.NewInt(100)}
+	if err := g.UpdateAllEdges(context.Background(), client, workerPool, header); err != nil {
+		t.Fatalf("Failed to update edges: %v", err)
+	}
+	snapshot := g.Copy()
+
+	// Strategies run on copies while later blocks are refreshed
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			g.Copy().Strategy(context.Background(), big.NewFloat(0.1))
+		}
+	}()
+	client.reserves[pool0.ContractAddress] = [2]*big.Int{testutil.Ether(90), testutil.Ether(330_000)}
+	next := &types.Header{Number: big.NewInt(101), ParentHash: header.Hash()}
+	for i := 0; i < 20; i++ {
+		if err := g.UpdateAllEdges(context.Background(), client, workerPool, next); err != nil {
+			t.Fatalf("Failed to update edges: %v", err)
+		}
+	}
+	wg.Wait()
+
+	if err := snapshot.CheckBlockConsistency(header); err != nil {
+		t.Errorf("Expected copy to still reflect block 100, got %v", err)
+	}
+	edge := snapshot.GetEdge(pool0.ContractAddress.String(), WETHAddress, dai.ContractAddress.String())
+	if reserve := edge.Pool.GetReserve(weth); reserve.Cmp(testutil.Ether(100)) != 0 {
+		t.Errorf("Expected copy to keep reserve %s, got %s", testutil.Ether(100), reserve)
+	}
+	if reserve := g.GetPool(pool0.ContractAddress.String()).GetReserve(weth); reserve.Cmp(testutil.Ether(90)) != 0 {
+		t.Errorf("Expected graph reserve %s, got %s", testutil.Ether(90), reserve)
+	}
+	if len(snapshot.GetNode(WETHAddress).Edges) != 2 {
+		t.Errorf("Expected copied WETH node to keep 2 edges")
+	}
+}
+
+func TestInvalidateOrphanedState(t *testing.T) {
+	fmt.Println("TestInvalidateOrphanedState")
+	weth := testutil.NewToken("WETH", 18)
+	dai := testutil.NewToken("DAI", 18)
+	pool := testutil.NewPool("0x01", weth, dai, testutil.Ether(100), testutil.Ether(300_000))
+	g := NewGraph()
+	g.AddPool(pool)
+
+	orphaned := BlockRef{Number: 100, Hash: common.HexToHash("0xaa")}
+	canonical := BlockRef{Number: 100, Hash: common.HexToHash("0xbb")}
+	g.ReserveBlocks[strings.ToLower(pool.ContractAddress.String())] = orphaned
+
+	book := NewOpportunityBook(8)
+	book.Add(&Opportunity{Block: canonical, AmountIn: big.NewFloat(0.1)})
+	book.Add(&Opportunity{Block: orphaned, AmountIn: big.NewFloat(0.1)})
+
+	if affected := g.InvalidateReserves([]common.Hash{orphaned.Hash}); len(affected) != 1 {
+		t.Errorf("Expected 1 affected pool, got %d", len(affected))
+	}
+	if len(g.ReserveBlocks) != 0 {
+		t.Errorf("Expected orphaned reserves block to be forgotten")
+	}
+	if invalidated := book.Invalidate([]common.Hash{orphaned.Hash}); len(invalidated) != 1 {
+		t.Errorf("Expected 1 invalidated opportunity, got %d", len(invalidated))
+	}
+	latest := book.Latest()
+	if len(latest) != 1 || latest[0].Block != canonical {
+		t.Errorf("Expected latest opportunity on canonical block, got %v", latest)
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	fmt.Println("TestSnapshotRoundTrip")
+	weth := testutil.NewToken("WETH", 18)
+	dai := testutil.NewToken("DAI", 18)
+	usdc := testutil.NewToken("USDC", 6)
+	g := NewGraph()
+	g.AddPool(testutil.NewPool("0x01", weth, dai, testutil.Ether(100), testutil.Ether(300_000)))
+	g.AddPool(testutil.NewPool("0x02", dai, usdc, testutil.Ether(1_000_000), big.NewInt(1_000_000e6)))
+	block := BlockRef{Number: 100, Hash: common.HexToHash("0xaa")}
+	g.ReserveBlocks[strings.ToLower(common.HexToAddress("0x01").String())] = block
+
+	filename := t.TempDir() + "/snapshot.json"
+	if err := g.WriteSnapshot(filename); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	loaded, err := LoadSnapshot(filename)
+	if err != nil {
+		t.Fatalf("Failed to load snapshot: %v", err)
+	}
+	if len(loaded.Pools) != 2 || len(loaded.Nodes) != 3 || len(loaded.Edges) != 2 {
+		t.Fatalf("Expected 2 pools, 3 nodes and 2 edges, got %d, %d and %d", len(loaded.Pools), len(loaded.Nodes), len(loaded.Edges))
+	}
+	edge := loaded.GetEdge("0x0000000000000000000000000000000000000001", WETHAddress, dai.ContractAddress.String())
+	if edge == nil {
+		t.Fatalf("Expected WETH/DAI edge in loaded graph")
+	}
+	if reserve := edge.Pool.GetReserve(loaded.GetNode(WETHAddress).Token); reserve.Cmp(testutil.Ether(100)) != 0 {
+		t.Errorf("Expected WETH reserve %s after round trip, got %s", testutil.Ether(100), reserve)
+	}
+	// Pools sharing a token point at the same node's token after loading
+	daiNode := loaded.GetNode(dai.ContractAddress.String())
+	for _, pool := range loaded.Pools {
+		for _, token := range pool.GetTokens() {
+			if token.ContractAddress == dai.ContractAddress && token != daiNode.Token {
+				t.Errorf("Expected pool %s to share the DAI node's token", pool.GetAddress())
+			}
+		}
+	}
+	if loaded.ReserveBlocks[strings.ToLower(common.HexToAddress("0x01").String())] != block {
+		t.Errorf("Expected reserves block %v to be restored", block)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	fmt.Println("TestPrune")
+	weth := testutil.NewToken("WETH", 18)
+	dai := testutil.NewToken("DAI", 18)
+	usdc := testutil.NewToken("USDC", 6)
+	link := testutil.NewToken("LINK", 18)
+	foo := testutil.NewToken("FOO", 18)
+	bar := testutil.NewToken("BAR", 18)
+	g := NewGraph()
+	g.AddPool(testutil.NewPool("0x01", weth, dai, testutil.Ether(100), testutil.Ether(300_000)))
+	g.AddPool(testutil.NewPool("0x02", dai, usdc, testutil.Ether(1_000_000), big.NewInt(1_000_000e6)))
+	g.AddPool(testutil.NewPool("0x03", usdc, weth, big.NewInt(300_000e6), testutil.Ether(100)))
+	g.AddPool(testutil.NewPool("0x04", weth, link, testutil.Ether(1), testutil.Ether(200)))         // Below threshold
+	g.AddPool(testutil.NewPool("0x05", link, dai, testutil.Ether(10_000), testutil.Ether(150_000))) // Dead end once 0x04 is gone
+	g.AddPool(testutil.NewPool("0x06", foo, bar, testutil.Ether(1_000), testutil.Ether(1_000)))     // Not connected to WETH
+
+	report, err := g.Prune(WETHAddress, big.NewFloat(30))
+	if err != nil {
+		t.Fatalf("Failed to prune: %v", err)
+	}
+	address := func(hex string) string {
+		return common.HexToAddress(hex).String()
+	}
+	if len(report.LowLiquidity) != 1 || report.LowLiquidity[0] != address("0x04") {
+		t.Errorf("Expected pool 0x04 to be below threshold, got %v", report.LowLiquidity)
+	}
+	if len(report.Unpriced) != 1 || report.Unpriced[0] != address("0x06") {
+		t.Errorf("Expected pool 0x06 to be unpriced, got %v", report.Unpriced)
+	}
+	if len(report.DeadEnds) != 1 || report.DeadEnds[0] != link.ContractAddress.String() {
+		t.Errorf("Expected LINK to be a dead end, got %v", report.DeadEnds)
+	}
+	if report.PoolsBefore != 6 || report.PoolsAfter != 3 || len(report.Kept) != 3 {
+		t.Errorf("Expected 3 of 6 pools to remain, got %d of %d (%v)", report.PoolsAfter, report.PoolsBefore, report.Kept)
+	}
+	if len(g.Nodes) != 3 || g.GetPool(address("0x05")) != nil {
+		t.Errorf("Expected only WETH, DAI and USDC to remain, got %d nodes", len(g.Nodes))
+	}
+
+	// Pruning again removes nothing
+	report, err = g.Prune(WETHAddress, big.NewFloat(30))
+	if err != nil || report.PoolsAfter != 3 || report.Passes != 1 {
+		t.Errorf("Expected pruning to be at a fixed point, got %v (%v)", report, err)
+	}
+	if _, err := g.Prune(link.ContractAddress.String(), big.NewFloat(30)); err == nil {
+		t.Errorf("Expected error for missing base token")
+	}
+}
+
+func TestPricer(t *testing.T) {
+	fmt.Println("TestPricer")
+	weth := testutil.NewToken("WETH", 18)
+	usdc := testutil.NewToken("USDC", 6)
+	link := testutil.NewToken("LINK", 18)
+	orphan := testutil.NewToken("ORPHAN", 18)
+	g := NewGraph()
+	g.AddPool(testutil.NewPool("0x01", weth, usdc, testutil.Ether(10_000), big.NewInt(30_000_000e6)))
+	// LINK trades at 0.005 WETH in a deep pool via USDC and at 0.01 WETH in a thin WETH pool
+	g.AddPool(testutil.NewPool("0x02", usdc, link, big.NewInt(1_500_000e6), testutil.Ether(100_000)))
+	g.AddPool(testutil.NewPool("0x03", weth, link, testutil.Ether(1), testutil.Ether(100)))
+	g.AddPool(testutil.NewPool("0x04", orphan, testutil.NewToken("OTHER", 18), testutil.Ether(1), testutil.Ether(1)))
+
+	pricer := NewPricer()
+	if err := pricer.Update(g); err != nil {
+		t.Fatalf("Failed to update prices: %v", err)
+	}
+	if ethUSD, _ := pricer.ETHUSD().Float64(); ethUSD < 3_000-1e-6 || ethUSD > 3_000+1e-6 {
+		t.Errorf("Expected ETH/USD 3000, got %v", ethUSD)
+	}
+	price, exists := pricer.PriceOf(link)
+	if !exists {
+		t.Fatalf("Expected LINK to be priced")
+	}
+	if eth, _ := price.ETH.Float64(); eth < 0.005-1e-12 || eth > 0.005+1e-12 {
+		t.Errorf("Expected LINK to be priced through the deep pool at 0.005 ETH, got %v", eth)
+	}
+	if usd, _ := price.ValueInUSD(testutil.Ether(2)).Float64(); usd < 30-1e-9 || usd > 30+1e-9 {
+		t.Errorf("Expected 2 LINK to be worth 30 USD, got %v", usd)
+	}
+	if price.Hops != 2 {
+		t.Errorf("Expected LINK to be priced in 2 hops, got %d", price.Hops)
+	}
+	wethPrice, _ := pricer.PriceOf(weth)
+	if wethPrice.Confidence != 1 || price.Confidence >= wethPrice.Confidence || price.Confidence <= 0 {
+		t.Errorf("Expected LINK confidence in (0, 1), got %v", price.Confidence)
+	}
+	if _, exists := pricer.PriceOf(orphan); exists {
+		t.Errorf("Expected token not connected to WETH to be unpriced")
+	}
+}
+
+func TestEvaluateOpportunity(t *testing.T) {
+	fmt.Println("TestEvaluateOpportunity")
+	market := testutil.CheapDAI()
+	dai, usdc := market.DAI, market.USDC
+	g := NewGraph()
+	market.AddTo(g)
+
+	start := g.GetNode(WETHAddress)
+	path := []*Edge{
+		g.GetEdge(common.HexToAddress("0x01").String(), WETHAddress, dai.ContractAddress.String()),
+		g.GetEdge(common.HexToAddress("0x02").String(), dai.ContractAddress.String(), usdc.ContractAddress.String()),
+		g.GetEdge(common.HexToAddress("0x03").String(), usdc.ContractAddress.String(), WETHAddress),
+	}
+
+	// Each hop matches the pool's own swap maths
+	amountIn := testutil.Ether(1)
+	expected := amountIn
+	current := start
+	for _, edge := range path {
+		next := edge.Other(current)
+		expected = edge.Pool.GetAmountOut(current.Token, next.Token, expected)
+		current = next
+	}
+	if out := SimulatePath(start, path, amountIn); out.Cmp(expected) != 0 {
+		t.Fatalf("Expected %s out, got %s", expected, out)
+	}
+
+	opportunity := &Opportunity{Start: start, Path: path, AmountIn: big.NewFloat(1)}
+	model := gas.NewModel(big.NewInt(1e9))
+	header := &types.Header{Number: big.NewInt(100), BaseFee: big.NewInt(20e9)}
+	opportunity.Evaluate(model, header)
+	if opportunity.GrossProfit.Sign() <= 0 {
+		t.Fatalf("Expected positive gross profit, got %s", opportunity.GrossProfit)
+	}
+	gasCost := new(big.Int).Mul(new(big.Int).SetUint64(gas.DefaultBaseGas+3*gas.DefaultSwapGas[eth.UniswapPoolType]), big.NewInt(21e9))
+	if opportunity.GasCost.Cmp(gasCost) != 0 {
+		t.Errorf("Expected gas cost %s, got %s", gasCost, opportunity.GasCost)
+	}
+	if net := new(big.Int).Sub(opportunity.GrossProfit, gasCost); opportunity.NetProfit.Cmp(net) != 0 {
+		t.Errorf("Expected net profit %s, got %s", net, opportunity.NetProfit)
+	}
+	if !opportunity.IsProfitable(big.NewInt(0)) {
+		t.Errorf("Expected opportunity to beat gas")
+	}
+	if opportunity.IsProfitable(opportunity.GrossProfit) {
+		t.Errorf("Expected opportunity not to beat a margin of its gross profit")
+	}
+	if record := opportunity.Record(); record.GasPrice != "21000000000" || record.GasUnits != gas.DefaultBaseGas+3*gas.DefaultSwapGas[eth.UniswapPoolType] {
+		t.Errorf("Unexpected gas in record: %+v", record)
+	}
+}
+
+func TestJournal(t *testing.T) {
+	fmt.Println("TestJournal")
+	filename := t.TempDir() + "/opportunities.jsonl"
+	journal, err := OpenJournal(filename)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	record := func(block byte, minutes int, symbols []string, profitable bool, netProfit string) OpportunityRecord {
+		pools := make([]string, len(symbols)-1)
+		for i := range pools {
+			pools[i] = common.BytesToAddress([]byte(symbols[i] + symbols[i+1])).String()
+		}
+		return OpportunityRecord{
+			Block:      BlockRef{Number: uint64(block), Hash: common.BytesToHash([]byte{block})},
+			FoundAt:    base.Add(time.Duration(minutes) * time.Minute),
+			Symbols:    symbols,
+			Pools:      pools,
+			AmountIn:   "0.1",
+			NetProfit:  netProfit,
+			Profitable: profitable,
+		}
+	}
+	orphaned := record(1, 0, []string{"WETH", "DAI", "USDC", "WETH"}, true, "0.5")
+	records := []OpportunityRecord{
+		orphaned,
+		record(2, 10, []string{"WETH", "DAI", "WETH"}, true, "0.25"),
+		record(3, 70, []string{"WETH", "USDC", "DAI", "WETH"}, true, "1"),
+		record(4, 80, []string{"WETH", "LINK", "WETH"}, false, "-0.01"),
+	}
+	for _, r := range records {
+		if err := journal.Append(r); err != nil {
+			t.Fatalf("Failed to append: %v", err)
+		}
+	}
+	// The first opportunity's block is orphaned after it was journaled
+	orphaned.Invalidated = true
+	journal.Append(orphaned)
+	journal.Close()
+
+	// A crash mid-write leaves a partial last line
+	file, _ := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0644)
+	file.WriteString(`{"block":{"number":5`)
+	file.Close()
+
+	read, skipped, err := ReadJournal(filename)
+	if err != nil || skipped != 0 {
+		t.Fatalf("Failed to read journal: %v, %d skipped", err, skipped)
+	}
+	if len(read) != 4 || !read[0].Invalidated {
+		t.Fatalf("Expected 4 records with the first invalidated, got %d", len(read))
+	}
+
+	// Reopening after the crash truncates the partial line, so the next
+	// record is on its own line
+	journal, err = OpenJournal(filename)
+	if err != nil {
+		t.Fatalf("Failed to reopen journal: %v", err)
+	}
+	journal.Append(record(5, 90, []string{"WETH", "DAI", "WETH"}, false, "0"))
+	journal.Close()
+	if reread, skipped, err := ReadJournal(filename); err != nil || skipped != 0 || len(reread) != 5 {
+		t.Errorf("Expected 5 records after reopening, got %d, %d skipped, %v", len(reread), skipped, err)
+	}
+
+	// A malformed line in the middle is skipped and counted
+	file, _ = os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0644)
+	file.WriteString("not json\n")
+	file.Close()
+	journal, _ = OpenJournal(filename)
+	journal.Append(record(6, 100, []string{"WETH", "DAI", "WETH"}, false, "0"))
+	journal.Close()
+	if reread, skipped, err := ReadJournal(filename); err != nil || skipped != 1 || len(reread) != 6 {
+		t.Errorf("Expected 6 records and 1 skipped line, got %d, %d skipped, %v", len(reread), skipped, err)
+	}
+
+	// Backruns of different swaps through the same pools are kept apart
+	journal, _ = OpenJournal(filename)
+	for _, trigger := range []string{"0x01", "0x02"} {
+		backrun := record(7, 110, []string{"WETH", "DAI", "WETH"}, true, "0.1")
+		backrun.Trigger = trigger
+		journal.Append(backrun)
+	}
+	journal.Close()
+	if reread, _, err := ReadJournal(filename); err != nil || len(reread) != 8 {
+		t.Errorf("Expected 8 records with both backruns, got %d, %v", len(reread), err)
+	}
+
+	summary := SummariseJournal(read, base.Add(5*time.Minute), time.Hour)
+	if summary.Total.Found != 3 || summary.Total.Profitable != 2 {
+		t.Errorf("Expected 3 opportunities since 12:05 with 2 profitable, got %+v", summary.Total)
+	}
+	if profit, _ := summary.Total.NetProfit.Float64(); profit != 1.25 {
+		t.Errorf("Expected total net profit 1.25, got %v", profit)
+	}
+	// Trades in either direction count towards the same pair
+	if top := summary.Pairs[0]; top.Key != "DAI/WETH" || top.Found != 2 || top.Profitable != 2 {
+		t.Errorf("Expected DAI/WETH to be the most profitable pair, got %+v", top)
+	}
+	if len(summary.Windows) != 2 || summary.Windows[0].Found != 1 || summary.Windows[1].Found != 2 {
+		t.Errorf("Expected 1 and 2 opportunities in the 12:00 and 13:00 windows, got %d windows", len(summary.Windows))
+	}
+
+	all := SummariseJournal(read, time.Time{}, 0)
+	if all.Total.Invalidated != 1 || len(all.Windows) != 1 {
+		t.Errorf("Expected 1 invalidated opportunity in a single window, got %+v", all.Total)
+	}
+}
+
+func TestQuotePath(t *testing.T) {
+	fmt.Println("TestQuotePath")
+	weth := testutil.NewToken("WETH", 18)
+	dai := testutil.NewToken("DAI", 18)
+	usdc := testutil.NewToken("USDC", 6)
+	fakeUSDC := testutil.NewToken("FAKE", 6)
+	fakeUSDC.Symbol = "USDC"
+	g := NewGraph()
+	shallow := testutil.NewPool("0x01", weth, dai, testutil.Ether(10), testutil.Ether(30_000))
+	deep := testutil.NewPool("0x02", weth, dai, testutil.Ether(1_000), testutil.Ether(3_000_000))
+	g.AddPool(shallow)
+	g.AddPool(deep)
+	g.AddPool(testutil.NewPool("0x03", dai, usdc, testutil.Ether(1_000_000), big.NewInt(1_000_000e6)))
+	g.AddPool(testutil.NewPool("0x04", usdc, weth, big.NewInt(3_000_000e6), testutil.Ether(1_000)))
+	g.AddPool(testutil.NewPool("0x05", fakeUSDC, weth, big.NewInt(1e6), testutil.Ether(1)))
+
+	// A symbol resolves to the most widely traded token with it
+	node, err := g.ResolveToken("usdc")
+	if err != nil || node.Token != usdc {
+		t.Fatalf("Expected USDC to resolve to the real token, got %v, %v", node, err)
+	}
+	if _, err := g.ResolveToken("0x0000000000000000000000000000000000000009"); err == nil {
+		t.Errorf("Expected an unknown address not to resolve")
+	}
+	path := make([]*Node, 0)
+	for _, symbol := range []string{"WETH", "DAI", "USDC", "WETH"} {
+		node, err := g.ResolveToken(symbol)
+		if err != nil {
+			t.Fatalf("Failed to resolve %s: %v", symbol, err)
+		}
+		path = append(path, node)
+	}
+
+	// The deeper WETH/DAI pool gives more for a large trade
+	quote, err := g.QuotePath(path, nil, testutil.Ether(5))
+	if err != nil {
+		t.Fatalf("Failed to quote: %v", err)
+	}
+	if len(quote.Hops) != 3 || quote.Hops[0].Pool != deep {
+		t.Fatalf("Expected the deep pool on the first hop, got %+v", quote.Hops[0])
+	}
+	expected := testutil.Ether(5)
+	for _, hop := range quote.Hops {
+		expected = hop.Pool.GetAmountOut(hop.TokenIn, hop.TokenOut, expected)
+		if hop.AmountOut.Cmp(expected) != 0 {
+			t.Errorf("Expected %s out of %s, got %s", expected, hop.Pool.GetAddress(), hop.AmountOut)
+		}
+		// Every hop pays at least the 0.3% fee
+		if hop.PriceImpact < 0.003 || hop.PriceImpact > 0.02 {
+			t.Errorf("Expected price impact between 0.3%% and 2%% on %s, got %f", hop.Pool.GetAddress(), hop.PriceImpact)
+		}
+	}
+	if quote.AmountOut.Cmp(expected) != 0 {
+		t.Errorf("Expected %s WETH out, got %s", expected, quote.AmountOut)
+	}
+	if profit, ok := quote.Profit(); !ok || profit.Cmp(new(big.Int).Sub(expected, testutil.Ether(5))) != 0 {
+		t.Errorf("Expected the cycle's profit, got %v", profit)
+	}
+
+	// A given pool is used even when another is better
+	quote, err = g.QuotePath(path, []eth.Pool{shallow, nil, nil}, testutil.Ether(5))
+	if err != nil || quote.Hops[0].Pool != shallow {
+		t.Fatalf("Expected the shallow pool on the first hop, got %v", err)
+	}
+	if quote.Hops[0].PriceImpact < 0.3 {
+		t.Errorf("Expected a large price impact trading half the shallow pool, got %f", quote.Hops[0].PriceImpact)
+	}
+	if _, err := g.QuotePath(path, []eth.Pool{deep, deep, nil}, testutil.Ether(5)); err == nil {
+		t.Errorf("Expected a pool that does not trade the hop's tokens to be rejected")
+	}
+}
+
+func TestRoute(t *testing.T) {
+	fmt.Println("TestRoute")
+	weth := testutil.NewToken("WETH", 18)
+	dai := testutil.NewToken("DAI", 18)
+	usdc := testutil.NewToken("USDC", 6)
+	g := NewGraph()
+	direct := testutil.NewPool("0x01", weth, usdc, testutil.Ether(100), big.NewInt(303_000e6))
+	g.AddPool(direct)
+	g.AddPool(testutil.NewPool("0x02", weth, dai, testutil.Ether(1_000), testutil.Ether(3_000_000)))
+	g.AddPool(testutil.NewPool("0x03", dai, usdc, testutil.Ether(3_000_000), big.NewInt(3_000_000e6)))
+	from, to := g.GetNode(weth.ContractAddress.String()), g.GetNode(usdc.ContractAddress.String())
+
+	// A small trade takes the direct pool, a large one the deeper route via DAI
+	quote, err := g.Route(from, to, testutil.Ether(1), 3)
+	if err != nil || len(quote.Hops) != 1 || quote.Hops[0].Pool != direct {
+		t.Fatalf("Expected the direct pool for a small trade, got %+v, %v", quote, err)
+	}
+	quote, err = g.Route(from, to, testutil.Ether(50), 3)
+	if err != nil || len(quote.Hops) != 2 || quote.Hops[0].TokenOut != dai {
+		t.Fatalf("Expected the route via DAI for a large trade, got %+v, %v", quote, err)
+	}
+	if _, err := g.Route(from, to, testutil.Ether(50), 1); err != nil {
+		t.Errorf("Expected the direct pool within one hop, got %v", err)
+	}
+	if _, err := g.Route(from, from, testutil.Ether(1), 3); err == nil {
+		t.Errorf("Expected routing a token to itself to be rejected")
+	}
+	// Avoiding the direct pool leaves the route via DAI, even for a small trade
+	quote, err = g.RouteAvoiding(from, to, testutil.Ether(1), 3, direct)
+	if err != nil || len(quote.Hops) != 2 {
+		t.Errorf("Expected the route via DAI when avoiding the direct pool, got %+v, %v", quote, err)
+	}
+	if _, err := g.RouteAvoiding(from, to, testutil.Ether(1), 1, direct); err == nil {
+		t.Errorf("Expected no route within one hop when avoiding the direct pool")
+	}
+
+	// Splitting a large trade uses both routes and beats either alone
+	split, err := g.RouteSplit(from, to, testutil.Ether(50), 3, 10)
+	if err != nil {
+		t.Fatalf("Failed to split route: %v", err)
+	}
+	if len(split.Routes) != 2 {
+		t.Fatalf("Expected the trade split across both routes, got %d", len(split.Routes))
+	}
+	if split.AmountOut.Cmp(split.Single.AmountOut) <= 0 {
+		t.Errorf("Expected the split %s to beat the single route %s", split.AmountOut, split.Single.AmountOut)
+	}
+	total, amountIn := new(big.Int), new(big.Int)
+	for _, route := range split.Routes {
+		total.Add(total, route.AmountOut)
+		amountIn.Add(amountIn, route.AmountIn)
+	}
+	if total.Cmp(split.AmountOut) != 0 || amountIn.Cmp(testutil.Ether(50)) != 0 {
+		t.Errorf("Expected the routes to add up to the split, got %s in, %s out", amountIn, total)
+	}
+
+	// The graph's pools are untouched by splitting
+	if direct.Reserve0.Cmp(testutil.Ether(100)) != 0 || g.GetPool(direct.ContractAddress.String()) != direct {
+		t.Errorf("Expected the graph's pools to be unchanged by splitting")
+	}
+}
+
+func TestRouteDoesNotReusePools(t *testing.T) {
+	fmt.Println("TestRouteDoesNotReusePools")
+	weth := testutil.NewToken("WETH", 18)
+	dai := testutil.NewToken("DAI", 18)
+	usdc := testutil.NewToken("USDC", 6)
+	link := testutil.NewToken("LINK", 18)
+	quarter := big.NewInt(25e16)
+	balancer := &eth.BalancerPool{
+		ContractAddress: common.HexToAddress("0x01"),
+		Tokens:          []*eth.ERC20Token{weth, dai, usdc, link},
+		Balances:        []*big.Int{testutil.Ether(100), testutil.Ether(300_000), big.NewInt(300_000e6), testutil.Ether(300_000)},
+		Weights:         []*big.Int{quarter, quarter, quarter, quarter},
+		SwapFee:         big.NewInt(1e15),
+		Initialized:     true,
+	}
+	g := NewGraph()
+	g.AddPool(balancer)
+	// USDC is cheap against DAI here, so WETH -> DAI -> USDC -> LINK would
+	// look twice as good as WETH -> LINK if the Balancer pool could be
+	// quoted on its unmoved balances for both the first and last hop
+	g.AddPool(testutil.NewPool("0x02", dai, usdc, testutil.Ether(1_000_000), big.NewInt(2_000_000e6)))
+
+	quote, err := g.Route(g.GetNode(weth.ContractAddress.String()), g.GetNode(link.ContractAddress.String()), testutil.Ether(1), 3)
+	if err != nil {
+		t.Fatalf("Failed to route: %v", err)
+	}
+	if len(quote.Hops) != 1 || quote.Hops[0].Pool != balancer {
+		t.Errorf("Expected the direct Balancer swap, got %d hops", len(quote.Hops))
+	}
+}
+
+func TestSplitAcrossPools(t *testing.T) {
+	fmt.Println("TestSplitAcrossPools")
+	weth := testutil.NewToken("WETH", 18)
+	dai := testutil.NewToken("DAI", 18)
+	g := NewGraph()
+	small := testutil.NewPool("0x01", weth, dai, testutil.Ether(100), testutil.Ether(300_000))
+	large := testutil.NewPool("0x02", dai, weth, testutil.Ether(600_000), testutil.Ether(200))
+	worse := testutil.NewPool("0x03", weth, dai, testutil.Ether(100), testutil.Ether(200_000))
+	g.AddPool(small)
+	g.AddPool(large)
+	g.AddPool(worse)
+	from, to := g.GetNode(weth.ContractAddress.String()), g.GetNode(dai.ContractAddress.String())
+
+	// Pools at the same price share a trade in proportion to their depth, and
+	// the pool with the worse price is left out
+	split, err := g.SplitAcrossPools(from, to, testutil.Ether(30))
+	if err != nil {
+		t.Fatalf("Failed to split: %v", err)
+	}
+	if len(split.Allocations) != 2 {
+		t.Fatalf("Expected two pools used, got %+v", split.Allocations)
+	}
+	amounts := make(map[eth.Pool]*big.Int)
+	total := new(big.Int)
+	for _, allocation := range split.Allocations {
+		amounts[allocation.Pool] = allocation.AmountIn
+		total.Add(total, allocation.AmountIn)
+	}
+	if total.Cmp(testutil.Ether(30)) != 0 {
+		t.Errorf("Expected the allocations to add up to 30 WETH, got %s", total)
+	}
+	if amounts[small].Cmp(testutil.Ether(10)) != 0 || amounts[large].Cmp(testutil.Ether(20)) != 0 {
+		t.Errorf("Expected 10 and 20 WETH, got %s and %s", amounts[small], amounts[large])
+	}
+	if split.Best.Pool != large || split.AmountOut.Cmp(split.Best.AmountOut) <= 0 {
+		t.Errorf("Expected the split to beat the large pool alone, got %s vs %s", split.AmountOut, split.Best.AmountOut)
+	}
+
+	// A trade large enough to move both pools past the worse one's price uses
+	// all three, and shifting any amount between them gets less out
+	split, err = g.SplitAcrossPools(from, to, testutil.Ether(300))
+	if err != nil || len(split.Allocations) != 3 {
+		t.Fatalf("Expected all three pools used, got %+v, %v", split, err)
+	}
+	shift := testutil.Ether(1)
+	for i := range split.Allocations {
+		for j := range split.Allocations {
+			if i == j {
+				continue
+			}
+			out := new(big.Int)
+			for k, allocation := range split.Allocations {
+				amount := new(big.Int).Set(allocation.AmountIn)
+				if k == i {
+					amount.Sub(amount, shift)
+				} else if k == j {
+					amount.Add(amount, shift)
+				}
+				out.Add(out, allocation.Pool.GetAmountOut(weth, dai, amount))
+			}
+			if out.Cmp(split.AmountOut) > 0 {
+				t.Errorf("Expected no better allocation, got %s moving 1 WETH from pool %d to %d", out, i, j)
+			}
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package graph
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Journal appends opportunity records to a JSON lines file as they are
+// found, syncing each one to disk so that a crash loses nothing
+type Journal struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// Open a journal for appending. A partial last line, left by a crash
+// mid-write, is truncated so that the next record starts on its own line.
+func OpenJournal(filename string) (*Journal, error) {
+	if err := truncatePartialLine(filename); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// Truncate a file back to just after its last newline
+func truncatePartialLine(filename string) error {
+	file, err := os.OpenFile(filename, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Scan back from the end a block at a time
+	block := make([]byte, 4096)
+	end := info.Size()
+	for end > 0 {
+		start := max(end-int64(len(block)), 0)
+		n, err := file.ReadAt(block[:end-start], start)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if i := bytes.LastIndexByte(block[:n], '\n'); i >= 0 {
+			end = start + int64(i) + 1
+			break
+		}
+		end = start
+	}
+	if end == info.Size() {
+		return nil
+	}
+	return file.Truncate(end)
+}
+
+func (j *Journal) Append(record OpportunityRecord) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.encoder.Encode(record); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
+
+// Read every record in a journal, returning them with the number of
+// malformed lines skipped. An opportunity that was invalidated after being
+// journaled appears twice, and only its later record is kept. A partially
+// written last line, left by a crash mid-write, is ignored.
+func ReadJournal(filename string) ([]OpportunityRecord, int, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	records := make([]OpportunityRecord, 0)
+	index := make(map[string]int)
+	skipped := 0
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, 0, err
+		}
+		var record OpportunityRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			skipped++
+			continue
+		}
+		key := record.key()
+		if i, exists := index[key]; exists {
+			records[i] = record
+			continue
+		}
+		index[key] = len(records)
+		records = append(records, record)
+	}
+	return records, skipped, nil
+}
+
+// Identifies the opportunity a record describes. Backruns of different
+// pending swaps through the same pools at a block are different opportunities.
+func (r OpportunityRecord) key() string {
+	return r.Block.Hash.String() + "/" + r.Trigger + "/" + strings.Join(r.Pools, "/") + "/" + r.AmountIn
+}
+
+// JournalStats aggregates the opportunities in a group of journal records.
+// Profits are in the start token, as recorded.
+type JournalStats struct {
+	Key         string
+	Found       int
+	Profitable  int
+	Invalidated int
+	NetProfit   *big.Float // Total over valid profitable opportunities
+	BestProfit  *big.Float
+}
+
+func (s *JournalStats) add(record OpportunityRecord) {
+	s.Found++
+	if record.Invalidated {
+		s.Invalidated++
+		return
+	}
+	if !record.Profitable {
+		return
+	}
+	s.Profitable++
+	if profit, ok := new(big.Float).SetString(record.NetProfit); ok {
+		s.NetProfit.Add(s.NetProfit, profit)
+		if s.BestProfit == nil || profit.Cmp(s.BestProfit) > 0 {
+			s.BestProfit = profit
+		}
+	}
+}
+
+// JournalSummary groups journal records by the token pairs they trade, by
+// the tokens they trade through and by time window
+type JournalSummary struct {
+	Total   JournalStats
+	Pairs   []*JournalStats // Most profitable opportunities first
+	Tokens  []*JournalStats
+	Windows []*JournalStats // Oldest first
+}
+
+// Summarise the records found at or after since, bucketing them into
+// windows of the given length. A zero window puts every record in one.
+func SummariseJournal(records []OpportunityRecord, since time.Time, window time.Duration) *JournalSummary {
+	summary := &JournalSummary{Total: JournalStats{Key: "total", NetProfit: new(big.Float)}}
+	pairs := make(map[string]*JournalStats)
+	tokens := make(map[string]*JournalStats)
+	windows := make(map[string]*JournalStats)
+	group := func(groups map[string]*JournalStats, key string) *JournalStats {
+		stats, exists := groups[key]
+		if !exists {
+			stats = &JournalStats{Key: key, NetProfit: new(big.Float)}
+			groups[key] = stats
+		}
+		return stats
+	}
+
+	for _, record := range records {
+		if record.FoundAt.Before(since) {
+			continue
+		}
+		summary.Total.add(record)
+
+		// A token traded on more than one hop is counted once
+		seenPairs := make(map[string]bool)
+		seenTokens := make(map[string]bool)
+		for i := 0; i+1 < len(record.Symbols); i++ {
+			pair := pairKey(record.Symbols[i], record.Symbols[i+1])
+			if !seenPairs[pair] {
+				seenPairs[pair] = true
+				group(pairs, pair).add(record)
+			}
+		}
+		for _, symbol := range record.Symbols {
+			if !seenTokens[symbol] {
+				seenTokens[symbol] = true
+				group(tokens, symbol).add(record)
+			}
+		}
+
+		windowKey := "all"
+		if window > 0 {
+			windowKey = record.FoundAt.UTC().Truncate(window).Format(time.RFC3339)
+		}
+		group(windows, windowKey).add(record)
+	}
+
+	summary.Pairs = sortedByProfit(pairs)
+	summary.Tokens = sortedByProfit(tokens)
+	for _, stats := range windows {
+		summary.Windows = append(summary.Windows, stats)
+	}
+	sort.Slice(summary.Windows, func(i, j int) bool {
+		return summary.Windows[i].Key < summary.Windows[j].Key
+	})
+	return summary
+}
+
+// Pairs are unordered, so a trade in either direction counts towards the same pair
+func pairKey(a, b string) string {
+	if b < a {
+		a, b = b, a
+	}
+	return a + "/" + b
+}
+
+func sortedByProfit(groups map[string]*JournalStats) []*JournalStats {
+	sorted := make([]*JournalStats, 0, len(groups))
+	for _, stats := range groups {
+		sorted = append(sorted, stats)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if c := sorted[i].NetProfit.Cmp(sorted[j].NetProfit); c != 0 {
+			return c > 0
+		}
+		if sorted[i].Found != sorted[j].Found {
+			return sorted[i].Found > sorted[j].Found
+		}
+		return sorted[i].Key < sorted[j].Key
+	})
+	return sorted
+}
@@ -0,0 +1,227 @@
+package graph
+
+import (
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Opportunity is an arbitrage path found by Strategy on a block's state
+type Opportunity struct {
+	Block       BlockRef
+	Start       *Node
+	Path        []*Edge
+	AmountIn    *big.Float // Whole start tokens
+	FoundAt     time.Time
+	Invalidated bool
+	Trigger     common.Hash // Pending transaction the opportunity backruns, zero if none
+
+	// Filled in by Evaluate, in the start token's smallest unit
+	AmountOut   *big.Int
+	GrossProfit *big.Int
+	GasUnits    uint64
+	GasPrice    *big.Int // Base fee plus priority fee, in wei
+	GasCost     *big.Int
+	NetProfit   *big.Int
+	Profitable  bool // Net profit beat the configured margin
+}
+
+// OpportunityRecord is the serialisable form of an Opportunity
+type OpportunityRecord struct {
+	Block       BlockRef  `json:"block"`
+	FoundAt     time.Time `json:"found_at"`
+	Tokens      []string  `json:"tokens"`
+	Symbols     []string  `json:"symbols"`
+	Pools       []string  `json:"pools"`
+	AmountIn    string    `json:"amount_in"`
+	AmountOut   string    `json:"amount_out,omitempty"`
+	GrossProfit string    `json:"gross_profit,omitempty"`
+	GasUnits    uint64    `json:"gas_units,omitempty"`
+	GasPrice    string    `json:"gas_price,omitempty"` // wei
+	GasCost     string    `json:"gas_cost,omitempty"`
+	NetProfit   string    `json:"net_profit,omitempty"`
+	Profitable  bool      `json:"profitable"`
+	Invalidated bool      `json:"invalidated,omitempty"`
+	Trigger     string    `json:"trigger,omitempty"`
+
+	// Hash of the reserves of the pools on the path, identifying the state
+	// the opportunity was found on
+	ReservesHash common.Hash `json:"reserves_hash"`
+}
+
+func (o *Opportunity) Record() OpportunityRecord {
+	record := OpportunityRecord{
+		Block:       o.Block,
+		FoundAt:     o.FoundAt,
+		Tokens:      make([]string, 0, len(o.Path)+1),
+		Symbols:     make([]string, 0, len(o.Path)+1),
+		Pools:       make([]string, 0, len(o.Path)),
+		AmountIn:    o.AmountIn.Text('f', -1),
+		AmountOut:   FormatUnits(o.AmountOut, o.Start.Token.Decimals),
+		GrossProfit: FormatUnits(o.GrossProfit, o.Start.Token.Decimals),
+		GasUnits:    o.GasUnits,
+		GasCost:     FormatUnits(o.GasCost, 18),
+		NetProfit:   FormatUnits(o.NetProfit, o.Start.Token.Decimals),
+		Profitable:  o.Profitable,
+		Invalidated: o.Invalidated,
+
+		ReservesHash: o.ReservesHash(),
+	}
+	if o.GasPrice != nil {
+		record.GasPrice = o.GasPrice.String()
+	}
+	if o.Trigger != (common.Hash{}) {
+		record.Trigger = o.Trigger.Hex()
+	}
+	current := o.Start
+	record.Tokens = append(record.Tokens, current.Token.ContractAddress.String())
+	record.Symbols = append(record.Symbols, current.Token.Symbol)
+	for _, edge := range o.Path {
+		current = edge.Other(current)
+		record.Tokens = append(record.Tokens, current.Token.ContractAddress.String())
+		record.Symbols = append(record.Symbols, current.Token.Symbol)
+		record.Pools = append(record.Pools, edge.Pool.GetAddress().String())
+	}
+	return record
+}
+
+// Hash the address and reserves of every pool on the path
+func (o *Opportunity) ReservesHash() common.Hash {
+	data := make([]byte, 0)
+	for _, edge := range o.Path {
+		data = append(data, edge.Pool.GetAddress().Bytes()...)
+		for _, token := range edge.Pool.GetTokens() {
+			if reserve := edge.Pool.GetReserve(token); reserve != nil {
+				data = append(data, common.LeftPadBytes(reserve.Bytes(), 32)...)
+			}
+		}
+	}
+	return crypto.Keccak256Hash(data)
+}
+
+// OpportunityBook keeps the opportunities found on a window of recent blocks,
+// so they can be invalidated if their block is orphaned
+type OpportunityBook struct {
+	mu      sync.Mutex
+	window  int
+	blocks  []BlockRef // Oldest first
+	byBlock map[common.Hash][]*Opportunity
+}
+
+func NewOpportunityBook(window int) *OpportunityBook {
+	return &OpportunityBook{
+		window:  window,
+		byBlock: make(map[common.Hash][]*Opportunity),
+	}
+}
+
+func (b *OpportunityBook) Add(opportunity *Opportunity) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	hash := opportunity.Block.Hash
+	if _, exists := b.byBlock[hash]; !exists {
+		b.blocks = append(b.blocks, opportunity.Block)
+		if len(b.blocks) > b.window {
+			delete(b.byBlock, b.blocks[0].Hash)
+			b.blocks = b.blocks[1:]
+		}
+	}
+	b.byBlock[hash] = append(b.byBlock[hash], opportunity)
+}
+
+// Mark every opportunity found on the given blocks as invalidated, returning
+// the opportunities that were invalidated
+func (b *OpportunityBook) Invalidate(blockHashes []common.Hash) []*Opportunity {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	invalidated := make([]*Opportunity, 0)
+	for _, hash := range blockHashes {
+		for _, opportunity := range b.byBlock[hash] {
+			if !opportunity.Invalidated {
+				opportunity.Invalidated = true
+				invalidated = append(invalidated, opportunity)
+			}
+		}
+	}
+	return invalidated
+}
+
+// Get the valid opportunities found on the most recent block, nil if none
+func (b *OpportunityBook) Latest() []*Opportunity {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.latest()
+}
+
+// Get records of the valid opportunities found on the most recent block.
+// Unlike Latest, this is safe while opportunities are being invalidated.
+func (b *OpportunityBook) LatestRecords() []OpportunityRecord {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	latest := b.latest()
+	records := make([]OpportunityRecord, len(latest))
+	for i, opportunity := range latest {
+		records[i] = opportunity.Record()
+	}
+	return records
+}
+
+func (b *OpportunityBook) latest() []*Opportunity {
+	for i := len(b.blocks) - 1; i >= 0; i-- {
+		valid := make([]*Opportunity, 0)
+		for _, opportunity := range b.byBlock[b.blocks[i].Hash] {
+			if !opportunity.Invalidated {
+				valid = append(valid, opportunity)
+			}
+		}
+		if len(valid) > 0 {
+			return valid
+		}
+	}
+	return nil
+}
+
+// Forget the reserves block of every pool whose reserves were read at one of
+// the given blocks, returning the addresses of the affected pools. Affected
+// pools fail CheckBlockConsistency until they are refreshed.
+func (g *Graph) InvalidateReserves(blockHashes []common.Hash) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	orphaned := make(map[common.Hash]bool)
+	for _, hash := range blockHashes {
+		orphaned[hash] = true
+	}
+	affected := make([]string, 0)
+	for key, block := range g.ReserveBlocks {
+		if orphaned[block.Hash] {
+			delete(g.ReserveBlocks, key)
+			affected = append(affected, key)
+		}
+	}
+	sort.Strings(affected)
+	return affected
+}
+
+// Render a path as the token symbols it trades through, starting at src
+func PathString(src *Node, path []*Edge) string {
+	symbols := []string{src.Token.Symbol}
+	current := src
+	for _, edge := range path {
+		current = edge.Other(current)
+		symbols = append(symbols, current.Token.Symbol)
+	}
+	return strings.Join(symbols, " -> ")
+}
+
+// Get the node at the other end of the edge from node
+func (e *Edge) Other(node *Node) *Node {
+	if e.Start == node {
+		return e.Dest
+	}
+	return e.Start
+}
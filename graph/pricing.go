@@ -0,0 +1,195 @@
+package graph
+
+import (
+	"container/heap"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+
+	"gethmate/eth"
+)
+
+// Stablecoins on Eth mainnet assumed to be worth one US dollar
+var USDStablecoins = []string{
+	"0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48", // USDC
+	"0xdac17f958d2ee523a2206206994597c13d831ec7", // USDT
+	"0x6b175474e89094c44da98b954eedeac495271d0f", // DAI
+}
+
+// Depth, in base tokens, at which a pool is considered half as reliable a
+// price source as an infinitely deep one
+const referenceDepth = 10.0
+
+// Confidence lost on every hop from the base token, however deep the pool
+const hopConfidence = 0.95
+
+// Price is the value of one whole token
+type Price struct {
+	Token *eth.ERC20Token
+	ETH   *big.Float
+	USD   *big.Float // nil if no stablecoin could be priced in ETH
+
+	// Between 0 and 1, lower for tokens priced through thin pools or many hops
+	Confidence float64
+	Hops       int
+}
+
+// Value an amount of the token in ETH
+func (p Price) ValueInETH(amount *big.Int) *big.Float {
+	return tokenValue(amount, p.Token, p.ETH)
+}
+
+// Value an amount of the token in USD, nil if the USD price is unknown
+func (p Price) ValueInUSD(amount *big.Int) *big.Float {
+	if p.USD == nil {
+		return nil
+	}
+	return tokenValue(amount, p.Token, p.USD)
+}
+
+// Pricer values every token in the graph in ETH and USD. Prices are
+// propagated from WETH along the most liquid paths, and converted to USD
+// through the ETH price of USD stablecoins.
+type Pricer struct {
+	mu     sync.RWMutex
+	prices map[string]Price // Keyed by lowercase token address
+	ethUSD *big.Float
+}
+
+func NewPricer() *Pricer {
+	return &Pricer{prices: make(map[string]Price)}
+}
+
+// Reprice every token from the reserves in g, typically a per-block Copy
+func (p *Pricer) Update(g *Graph) error {
+	g.mu.RLock()
+	base := g.getNode(WETHAddress)
+	if base == nil {
+		g.mu.RUnlock()
+		return fmt.Errorf("WETH not found in graph")
+	}
+	nodePrices := g.priceFrom(base)
+	stablecoins := make([]*Node, 0, len(USDStablecoins))
+	for _, address := range USDStablecoins {
+		if node := g.getNode(address); node != nil {
+			stablecoins = append(stablecoins, node)
+		}
+	}
+	g.mu.RUnlock()
+
+	// Confidence weighted mean of the USD price of ETH implied by each stablecoin
+	var ethUSD *big.Float
+	weighted, totalWeight := 0.0, 0.0
+	for _, node := range stablecoins {
+		if price, exists := nodePrices[node]; exists {
+			ethPrice, _ := price.price.Float64()
+			weighted += price.confidence / ethPrice
+			totalWeight += price.confidence
+		}
+	}
+	if totalWeight > 0 {
+		ethUSD = big.NewFloat(weighted / totalWeight)
+	}
+
+	prices := make(map[string]Price, len(nodePrices))
+	for node, nodePrice := range nodePrices {
+		price := Price{
+			Token:      node.Token,
+			ETH:        nodePrice.price,
+			Confidence: nodePrice.confidence,
+			Hops:       nodePrice.hops,
+		}
+		if ethUSD != nil {
+			price.USD = new(big.Float).Mul(nodePrice.price, ethUSD)
+		}
+		prices[strings.ToLower(node.Token.ContractAddress.String())] = price
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prices = prices
+	p.ethUSD = ethUSD
+	return nil
+}
+
+// Get the price of a token, false if it is not connected to WETH
+func (p *Pricer) PriceOf(token *eth.ERC20Token) (Price, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	price, exists := p.prices[strings.ToLower(token.ContractAddress.String())]
+	return price, exists
+}
+
+// Get the USD price of ETH, nil if no stablecoin could be priced
+func (p *Pricer) ETHUSD() *big.Float {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.ethUSD
+}
+
+type nodePrice struct {
+	price      *big.Float // Whole base tokens per whole token
+	confidence float64
+	hops       int
+	cost       float64
+}
+
+// Price every token reachable from base in whole base tokens. Each token is
+// priced along its cheapest path from base, where every hop costs one plus
+// a penalty that grows as the pool's depth on the priced side shrinks.
+func (g *Graph) priceFrom(base *Node) map[*Node]*nodePrice {
+	prices := map[*Node]*nodePrice{base: {price: big.NewFloat(1), confidence: 1}}
+	done := make(map[*Node]bool)
+	queue := &priceQueue{{node: base}}
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(priceQueueItem)
+		if done[item.node] {
+			continue
+		}
+		done[item.node] = true
+		current := prices[item.node]
+		for _, edge := range item.node.Edges {
+			next := edge.Other(item.node)
+			if done[next] {
+				continue
+			}
+			depth, _ := tokenValue(edge.Pool.GetReserve(item.node.Token), item.node.Token, current.price).Float64()
+			price := new(big.Float).Mul(edge.Pool.GetSpotPrice(next.Token, item.node.Token), current.price)
+			if depth <= 0 || price.Sign() <= 0 {
+				continue
+			}
+			cost := current.cost + 1 + referenceDepth/depth
+			if existing, exists := prices[next]; exists && existing.cost <= cost {
+				continue
+			}
+			prices[next] = &nodePrice{
+				price:      price,
+				confidence: current.confidence * hopConfidence * depth / (depth + referenceDepth),
+				hops:       current.hops + 1,
+				cost:       cost,
+			}
+			heap.Push(queue, priceQueueItem{node: next, cost: cost})
+		}
+	}
+	return prices
+}
+
+type priceQueueItem struct {
+	node *Node
+	cost float64
+}
+
+// Min heap of nodes by the cost of the path pricing them
+type priceQueue []priceQueueItem
+
+func (q priceQueue) Len() int           { return len(q) }
+func (q priceQueue) Less(i, j int) bool { return q[i].cost < q[j].cost }
+func (q priceQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *priceQueue) Push(x any)        { *q = append(*q, x.(priceQueueItem)) }
+func (q *priceQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,78 @@
+package graph
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"gethmate/eth"
+	"gethmate/gas"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Trade amountIn of start's token along path using each pool's exact swap
+// maths, returning the amount of the last token received
+func SimulatePath(start *Node, path []*Edge, amountIn *big.Int) *big.Int {
+	amount := amountIn
+	current := start
+	for _, edge := range path {
+		next := edge.Other(current)
+		amount = edge.Pool.GetAmountOut(current.Token, next.Token, amount)
+		if amount.Sign() <= 0 {
+			return new(big.Int)
+		}
+		current = next
+	}
+	return amount
+}
+
+// Simulate the opportunity's trade and charge the gas it would use at
+// header's fees, filling in its profit. Amounts are in the start token.
+func (o *Opportunity) Evaluate(model *gas.Model, header *types.Header) {
+	amountIn := ToUnits(o.AmountIn, o.Start.Token.Decimals)
+	o.AmountOut = SimulatePath(o.Start, o.Path, amountIn)
+	o.GrossProfit = new(big.Int).Sub(o.AmountOut, amountIn)
+
+	pools := make([]eth.Pool, len(o.Path))
+	for i, edge := range o.Path {
+		pools[i] = edge.Pool
+	}
+	o.GasUnits = model.EstimatePath(pools)
+	o.GasPrice = model.GasPrice(header)
+	o.GasCost = new(big.Int).Mul(new(big.Int).SetUint64(o.GasUnits), o.GasPrice)
+	o.NetProfit = new(big.Int).Sub(o.GrossProfit, o.GasCost)
+}
+
+// Check whether the opportunity's net profit is at least margin. Gas is
+// charged in ETH, so only opportunities starting at WETH can be profitable.
+func (o *Opportunity) IsProfitable(margin *big.Int) bool {
+	if o.NetProfit == nil || !strings.EqualFold(o.Start.Token.ContractAddress.String(), WETHAddress) {
+		return false
+	}
+	return o.NetProfit.Cmp(margin) >= 0
+}
+
+// Convert an amount of whole tokens to the token's smallest unit
+func ToUnits(amount *big.Float, decimals int) *big.Int {
+	scale := new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil))
+	units, _ := new(big.Float).Mul(amount, scale).Int(nil)
+	return units
+}
+
+// Format an amount in a token's smallest unit as whole tokens
+func FormatUnits(amount *big.Int, decimals int) string {
+	if amount == nil {
+		return ""
+	}
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	whole, fraction := new(big.Int).QuoRem(new(big.Int).Abs(amount), scale, new(big.Int))
+	sign := ""
+	if amount.Sign() < 0 {
+		sign = "-"
+	}
+	if decimals == 0 {
+		return sign + whole.String()
+	}
+	return fmt.Sprintf("%s%s.%0*d", sign, whole, decimals, fraction)
+}
@@ -0,0 +1,131 @@
+package graph
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+
+	"gethmate/eth"
+)
+
+// PruneReport describes the pools and tokens removed by Prune
+type PruneReport struct {
+	BaseToken string
+	Threshold *big.Float // Minimum pool liquidity, in whole base tokens
+	Passes    int
+
+	PoolsBefore int
+	PoolsAfter  int
+	NodesBefore int
+	NodesAfter  int
+
+	LowLiquidity []string // Pools valued below the threshold
+	Unpriced     []string // Pools with no token reachable from the base token
+	DeadEnds     []string // Tokens traded by fewer than two pools
+	Kept         []string // Pools remaining in the graph
+}
+
+func (r *PruneReport) String() string {
+	return fmt.Sprintf("pruned %d pools (%d below %s, %d unpriced) and %d dead end tokens in %d passes: %d of %d pools and %d of %d tokens remain",
+		r.PoolsBefore-r.PoolsAfter, len(r.LowLiquidity), r.Threshold.Text('f', -1), len(r.Unpriced), len(r.DeadEnds), r.Passes,
+		r.PoolsAfter, r.PoolsBefore, r.NodesAfter, r.NodesBefore)
+}
+
+// Remove pools whose liquidity, valued in the base token, is below threshold
+// and tokens that cannot be part of a cycle, repeating until nothing more is
+// removed. Tokens are priced as by Pricer, along the most liquid paths from
+// the base token, so removing pools can change the value of those remaining.
+func (g *Graph) Prune(baseToken string, threshold *big.Float) (*PruneReport, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	base := g.getNode(baseToken)
+	if base == nil {
+		return nil, fmt.Errorf("base token %s not found in graph", baseToken)
+	}
+	report := &PruneReport{
+		BaseToken:   base.Token.ContractAddress.String(),
+		Threshold:   threshold,
+		PoolsBefore: len(g.Pools),
+		NodesBefore: len(g.Nodes),
+	}
+
+	for {
+		report.Passes++
+		removed := 0
+		prices := g.priceFrom(base)
+		for key, pool := range g.Pools {
+			liquidity, priced := g.poolLiquidity(pool, prices)
+			if !priced {
+				report.Unpriced = append(report.Unpriced, pool.GetAddress().String())
+			} else if liquidity.Cmp(threshold) < 0 {
+				report.LowLiquidity = append(report.LowLiquidity, pool.GetAddress().String())
+			} else {
+				continue
+			}
+			g.removePool(key)
+			removed++
+		}
+
+		for _, node := range g.Nodes {
+			if node != base && poolDegree(node) < 2 {
+				report.DeadEnds = append(report.DeadEnds, node.Token.ContractAddress.String())
+				g.removeNode(node)
+				removed++
+			}
+		}
+		if removed == 0 {
+			break
+		}
+	}
+
+	report.PoolsAfter = len(g.Pools)
+	report.NodesAfter = len(g.Nodes)
+	for _, pool := range g.Pools {
+		report.Kept = append(report.Kept, pool.GetAddress().String())
+	}
+	sort.Strings(report.LowLiquidity)
+	sort.Strings(report.Unpriced)
+	sort.Strings(report.DeadEnds)
+	sort.Strings(report.Kept)
+	return report, nil
+}
+
+// Value a pool's reserves in base tokens. A pool is priced if at least one
+// of its tokens is.
+func (g *Graph) poolLiquidity(pool eth.Pool, prices map[*Node]*nodePrice) (*big.Float, bool) {
+	liquidity := new(big.Float)
+	priced := false
+	for _, token := range pool.GetTokens() {
+		if price, exists := prices[g.getNode(token.ContractAddress.String())]; exists {
+			liquidity.Add(liquidity, tokenValue(pool.GetReserve(token), token, price.price))
+			priced = true
+		}
+	}
+	return liquidity, priced
+}
+
+// Value an amount of token in base tokens, given the price of one whole token
+func tokenValue(amount *big.Int, token *eth.ERC20Token, price *big.Float) *big.Float {
+	value := new(big.Float).SetInt(amount)
+	value.Quo(value, new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(token.Decimals)), nil)))
+	return value.Mul(value, price)
+}
+
+// Number of distinct pools trading a token. A token traded by a single pool
+// cannot be part of a cycle through more than one pool.
+func poolDegree(node *Node) int {
+	pools := make(map[eth.Pool]bool)
+	for _, edge := range node.Edges {
+		pools[edge.Pool] = true
+	}
+	return len(pools)
+}
+
+func (g *Graph) removePool(key string) {
+	for _, edge := range g.getPoolEdges(key) {
+		g.removeEdge(edge)
+	}
+	// Pools without edges are not removed by removeEdge
+	delete(g.Pools, key)
+	delete(g.ReserveBlocks, key)
+}
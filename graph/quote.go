@@ -0,0 +1,165 @@
+package graph
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"gethmate/eth"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// HopQuote is a single swap along a quoted path. Amounts are in each token's
+// smallest unit.
+type HopQuote struct {
+	Pool      eth.Pool
+	TokenIn   *eth.ERC20Token
+	TokenOut  *eth.ERC20Token
+	AmountIn  *big.Int
+	AmountOut *big.Int
+
+	SpotPrice      *big.Float // Whole tokenOut per whole tokenIn before the swap
+	ExecutionPrice *big.Float // Whole tokenOut received per whole tokenIn paid
+
+	// Fraction of the spot price lost to the swap's size and fees
+	PriceImpact float64
+}
+
+// Quote is the result of trading an amount along a path of tokens
+type Quote struct {
+	Hops      []HopQuote
+	AmountIn  *big.Int
+	AmountOut *big.Int
+}
+
+func (q *Quote) TokenIn() *eth.ERC20Token {
+	return q.Hops[0].TokenIn
+}
+
+func (q *Quote) TokenOut() *eth.ERC20Token {
+	return q.Hops[len(q.Hops)-1].TokenOut
+}
+
+// Get the amount gained, for a path that starts and ends at the same token
+func (q *Quote) Profit() (*big.Int, bool) {
+	if !q.TokenIn().Equals(q.TokenOut()) {
+		return nil, false
+	}
+	return new(big.Int).Sub(q.AmountOut, q.AmountIn), true
+}
+
+// Resolve a token by address or symbol. Symbols are not unique, so a symbol
+// resolves to the token with that symbol traded by the most pools.
+func (g *Graph) ResolveToken(symbolOrAddress string) (*Node, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if common.IsHexAddress(symbolOrAddress) {
+		if node := g.getNode(symbolOrAddress); node != nil {
+			return node, nil
+		}
+		return nil, fmt.Errorf("token %s not in graph", symbolOrAddress)
+	}
+	var best *Node
+	for _, node := range g.Nodes {
+		if !strings.EqualFold(node.Token.Symbol, symbolOrAddress) {
+			continue
+		}
+		if best == nil || poolDegree(node) > poolDegree(best) ||
+			(poolDegree(node) == poolDegree(best) && node.Token.ContractAddress.Hex() < best.Token.ContractAddress.Hex()) {
+			best = node
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no token with symbol %s in graph", symbolOrAddress)
+	}
+	return best, nil
+}
+
+// Get every pool that trades tokenA against tokenB
+func (g *Graph) PoolsBetween(tokenA, tokenB *Node) []eth.Pool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	pools := make([]eth.Pool, 0)
+	for _, edge := range tokenA.Edges {
+		if edge.Other(tokenA) == tokenB {
+			pools = append(pools, edge.Pool)
+		}
+	}
+	return pools
+}
+
+// Quote trading amountIn of the first token along a path of tokens. Each hop
+// trades through the given pool, or if it is nil through whichever pool
+// between the two tokens returns the most for the amount reaching that hop.
+func (g *Graph) QuotePath(path []*Node, pools []eth.Pool, amountIn *big.Int) (*Quote, error) {
+	if len(path) < 2 {
+		return nil, fmt.Errorf("a path needs at least two tokens")
+	}
+	if pools != nil && len(pools) != len(path)-1 {
+		return nil, fmt.Errorf("%d pools given for %d hops", len(pools), len(path)-1)
+	}
+	quote := &Quote{AmountIn: amountIn}
+	amount := amountIn
+	for i := 0; i+1 < len(path); i++ {
+		tokenIn, tokenOut := path[i].Token, path[i+1].Token
+		candidates := g.PoolsBetween(path[i], path[i+1])
+		if pools != nil && pools[i] != nil {
+			pool := findPool(candidates, pools[i].GetAddress())
+			if pool == nil {
+				return nil, fmt.Errorf("pool %s does not trade %s for %s", pools[i].GetAddress(), tokenIn.Symbol, tokenOut.Symbol)
+			}
+			candidates = []eth.Pool{pool}
+		}
+		if len(candidates) == 0 {
+			return nil, fmt.Errorf("no pool trades %s for %s", tokenIn.Symbol, tokenOut.Symbol)
+		}
+
+		var hop *HopQuote
+		for _, pool := range candidates {
+			amountOut := pool.GetAmountOut(tokenIn, tokenOut, amount)
+			if hop == nil || amountOut.Cmp(hop.AmountOut) > 0 {
+				hop = &HopQuote{Pool: pool, TokenIn: tokenIn, TokenOut: tokenOut, AmountIn: amount, AmountOut: amountOut}
+			}
+		}
+		hop.SpotPrice = hop.Pool.GetSpotPrice(tokenIn, tokenOut)
+		if amount.Sign() > 0 {
+			hop.ExecutionPrice = new(big.Float).Quo(
+				new(big.Float).SetInt(hop.AmountOut), new(big.Float).SetInt(amount))
+			hop.ExecutionPrice.Mul(hop.ExecutionPrice, decimalScale(tokenIn.Decimals-tokenOut.Decimals))
+			if hop.SpotPrice.Sign() > 0 {
+				ratio, _ := new(big.Float).Quo(hop.ExecutionPrice, hop.SpotPrice).Float64()
+				hop.PriceImpact = 1 - ratio
+			}
+		}
+		quote.Hops = append(quote.Hops, *hop)
+		amount = hop.AmountOut
+	}
+	quote.AmountOut = amount
+	return quote, nil
+}
+
+func findPool(pools []eth.Pool, address common.Address) eth.Pool {
+	for _, pool := range pools {
+		if pool.GetAddress() == address {
+			return pool
+		}
+	}
+	return nil
+}
+
+// 10^exponent, which may be negative
+func decimalScale(exponent int) *big.Float {
+	scale := new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(exponent))), nil))
+	if exponent < 0 {
+		return scale.Quo(big.NewFloat(1), scale)
+	}
+	return scale
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,211 @@
+package graph
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"gethmate/eth"
+)
+
+// A partial route: the best amount found reaching node in a given number of
+// hops, and how it got there
+type routeStep struct {
+	node   *Node
+	amount *big.Int
+	pool   eth.Pool // Pool traded into node, nil at the start
+	prev   *routeStep
+}
+
+func (s *routeStep) visits(node *Node) bool {
+	for step := s; step != nil; step = step.prev {
+		if step.node == node {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the route trades through pool. A pool used on two hops would be
+// quoted on the same unmoved reserves twice.
+func (s *routeStep) uses(pool eth.Pool) bool {
+	for step := s; step != nil; step = step.prev {
+		if step.pool == pool {
+			return true
+		}
+	}
+	return false
+}
+
+// Find a path of at most maxHops hops returning as much tokenOut as it can
+// for amountIn of tokenIn. Every swap is quoted exactly, but the search is a
+// heuristic: only the best route of each hop count to each token is kept and
+// extended, and routes may not revisit a token or reuse a pool, so a worse
+// route to some token that could have been extended where the best one
+// cannot is never considered.
+func (g *Graph) Route(tokenIn, tokenOut *Node, amountIn *big.Int, maxHops int) (*Quote, error) {
+	return g.RouteAvoiding(tokenIn, tokenOut, amountIn, maxHops)
+}
+
+// Route without trading through any of the pools in avoid, such as pools
+// another leg of a cycle already trades through
+func (g *Graph) RouteAvoiding(tokenIn, tokenOut *Node, amountIn *big.Int, maxHops int, avoid ...eth.Pool) (*Quote, error) {
+	if tokenIn == tokenOut {
+		return nil, fmt.Errorf("cannot route %s to itself", tokenIn.Token.Symbol)
+	}
+	if maxHops < 1 {
+		return nil, fmt.Errorf("invalid max hops %d", maxHops)
+	}
+	g.mu.RLock()
+	best := g.bestRoute(tokenIn, tokenOut, amountIn, maxHops, avoid)
+	g.mu.RUnlock()
+	if best == nil {
+		return nil, fmt.Errorf("no route from %s to %s in %d hops", tokenIn.Token.Symbol, tokenOut.Token.Symbol, maxHops)
+	}
+
+	steps := make([]*routeStep, 0)
+	for step := best; step.prev != nil; step = step.prev {
+		steps = append(steps, step)
+	}
+	path := make([]*Node, len(steps)+1)
+	pools := make([]eth.Pool, len(steps))
+	path[0] = tokenIn
+	for i := range steps {
+		step := steps[len(steps)-1-i]
+		path[i+1] = step.node
+		pools[i] = step.pool
+	}
+	return g.QuotePath(path, pools, amountIn)
+}
+
+func (g *Graph) bestRoute(tokenIn, tokenOut *Node, amountIn *big.Int, maxHops int, avoid []eth.Pool) *routeStep {
+	avoided := make(map[eth.Pool]bool, len(avoid))
+	for _, pool := range avoid {
+		avoided[pool] = true
+	}
+	frontier := map[*Node]*routeStep{tokenIn: {node: tokenIn, amount: amountIn}}
+	var best *routeStep
+	for hop := 1; hop <= maxHops && len(frontier) > 0; hop++ {
+		next := make(map[*Node]*routeStep)
+		for node, step := range frontier {
+			for _, edge := range node.Edges {
+				other := edge.Other(node)
+				if avoided[edge.Pool] || step.visits(other) || step.uses(edge.Pool) {
+					continue
+				}
+				amountOut := edge.Pool.GetAmountOut(node.Token, other.Token, step.amount)
+				if amountOut.Sign() <= 0 {
+					continue
+				}
+				if current, exists := next[other]; !exists || amountOut.Cmp(current.amount) > 0 {
+					next[other] = &routeStep{node: other, amount: amountOut, pool: edge.Pool, prev: step}
+				}
+			}
+		}
+		// Routes end at tokenOut rather than passing through it
+		if arrived, exists := next[tokenOut]; exists {
+			if best == nil || arrived.amount.Cmp(best.amount) > 0 {
+				best = arrived
+			}
+			delete(next, tokenOut)
+		}
+		frontier = next
+	}
+	return best
+}
+
+// SplitRoute divides an amount between routes that together return more than
+// any single route. Each route's amounts are as simulated after the routes
+// before it, so routes sharing a pool are quoted on its moved reserves.
+type SplitRoute struct {
+	Routes    []*Quote
+	AmountIn  *big.Int
+	AmountOut *big.Int
+	Single    *Quote // The best single route for the whole amount, for comparison
+}
+
+// Find a split of amountIn across routes of at most maxHops hops. The amount
+// is divided into parts, each routed in turn along the best route given the
+// reserves left by the parts before it. Parts taking the same route are
+// merged.
+func (g *Graph) RouteSplit(tokenIn, tokenOut *Node, amountIn *big.Int, maxHops, parts int) (*SplitRoute, error) {
+	if parts < 1 {
+		return nil, fmt.Errorf("invalid number of parts %d", parts)
+	}
+	single, err := g.Route(tokenIn, tokenOut, amountIn, maxHops)
+	if err != nil {
+		return nil, err
+	}
+
+	// Route on a copy whose pools are replaced by clones as they are traded
+	work := g.Copy()
+	from := work.GetNode(tokenIn.Token.ContractAddress.String())
+	to := work.GetNode(tokenOut.Token.ContractAddress.String())
+	cloned := make(map[string]bool)
+	split := &SplitRoute{AmountIn: amountIn, AmountOut: new(big.Int), Single: single}
+	routes := make(map[string]*Quote)
+	part := new(big.Int).Quo(amountIn, big.NewInt(int64(parts)))
+	for i := 0; i < parts; i++ {
+		amount := part
+		if i == parts-1 {
+			amount = new(big.Int).Sub(amountIn, new(big.Int).Mul(part, big.NewInt(int64(parts-1))))
+		}
+		if amount.Sign() == 0 {
+			continue
+		}
+		quote, err := work.Route(from, to, amount, maxHops)
+		if err != nil {
+			return nil, err
+		}
+		for _, hop := range quote.Hops {
+			key := strings.ToLower(hop.Pool.GetAddress().String())
+			if !cloned[key] {
+				cloned[key] = true
+				work.replacePool(key, hop.Pool.Clone())
+			}
+			work.getPool(key).ApplySwap(hop.TokenIn, hop.TokenOut, hop.AmountIn, hop.AmountOut)
+		}
+		split.AmountOut.Add(split.AmountOut, quote.AmountOut)
+
+		key := routeKey(quote)
+		if merged, exists := routes[key]; exists {
+			mergeQuote(merged, quote)
+		} else {
+			routes[key] = quote
+			split.Routes = append(split.Routes, quote)
+		}
+	}
+
+	// Splitting should never be worse than the single route, but parts may
+	// round down where the whole amount does not
+	if split.AmountOut.Cmp(single.AmountOut) < 0 {
+		split.Routes = []*Quote{single}
+		split.AmountOut = single.AmountOut
+	}
+	return split, nil
+}
+
+func routeKey(quote *Quote) string {
+	pools := make([]string, len(quote.Hops))
+	for i, hop := range quote.Hops {
+		pools[i] = strings.ToLower(hop.Pool.GetAddress().String())
+	}
+	return strings.Join(pools, "/")
+}
+
+// Add another part's amounts to a route's quote
+func mergeQuote(merged, part *Quote) {
+	merged.AmountIn = new(big.Int).Add(merged.AmountIn, part.AmountIn)
+	merged.AmountOut = new(big.Int).Add(merged.AmountOut, part.AmountOut)
+	for i := range merged.Hops {
+		hop := &merged.Hops[i]
+		hop.AmountIn = new(big.Int).Add(hop.AmountIn, part.Hops[i].AmountIn)
+		hop.AmountOut = new(big.Int).Add(hop.AmountOut, part.Hops[i].AmountOut)
+		hop.ExecutionPrice = new(big.Float).Quo(new(big.Float).SetInt(hop.AmountOut), new(big.Float).SetInt(hop.AmountIn))
+		hop.ExecutionPrice.Mul(hop.ExecutionPrice, decimalScale(hop.TokenIn.Decimals-hop.TokenOut.Decimals))
+		if hop.SpotPrice.Sign() > 0 {
+			ratio, _ := new(big.Float).Quo(hop.ExecutionPrice, hop.SpotPrice).Float64()
+			hop.PriceImpact = 1 - ratio
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package graph
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gethmate/eth"
+	"gethmate/utils"
+)
+
+// Snapshot is a serialisable copy of every pool in the graph and the block
+// its reserves were read at
+type Snapshot struct {
+	WrittenAt time.Time      `json:"written_at"`
+	Pools     []PoolSnapshot `json:"pools"`
+}
+
+type PoolSnapshot struct {
+	Block *BlockRef       `json:"block,omitempty"`
+	Pool  json.RawMessage `json:"pool"`
+}
+
+func (g *Graph) Snapshot() (*Snapshot, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	keys := make([]string, 0, len(g.Pools))
+	for key := range g.Pools {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	snapshot := &Snapshot{
+		WrittenAt: time.Now().UTC(),
+		Pools:     make([]PoolSnapshot, 0, len(keys)),
+	}
+	for _, key := range keys {
+		data, err := eth.MarshalPool(g.Pools[key])
+		if err != nil {
+			return nil, err
+		}
+		poolSnapshot := PoolSnapshot{Pool: data}
+		if block, exists := g.ReserveBlocks[key]; exists {
+			poolSnapshot.Block = &block
+		}
+		snapshot.Pools = append(snapshot.Pools, poolSnapshot)
+	}
+	return snapshot, nil
+}
+
+func (g *Graph) WriteSnapshot(filename string) error {
+	snapshot, err := g.Snapshot()
+	if err != nil {
+		return err
+	}
+	jsonBytes, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(filename, jsonBytes)
+}
+
+// Build a graph from a snapshot written by WriteSnapshot
+func LoadSnapshot(filename string) (*Graph, error) {
+	jsonBytes, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(jsonBytes, &snapshot); err != nil {
+		return nil, err
+	}
+
+	g := NewGraph()
+	for _, poolSnapshot := range snapshot.Pools {
+		pool, err := eth.UnmarshalPool(poolSnapshot.Pool)
+		if err != nil {
+			return nil, err
+		}
+		g.canonicaliseTokens(pool)
+		g.AddPool(pool)
+		if poolSnapshot.Block != nil {
+			g.ReserveBlocks[strings.ToLower(pool.GetAddress().String())] = *poolSnapshot.Block
+		}
+	}
+	return g, nil
+}
+
+// Decoded pools each hold their own copy of a token, so point them at the
+// token already held by the graph's node
+func (g *Graph) canonicaliseTokens(pool eth.Pool) {
+	tokens := pool.GetTokens()
+	for i, token := range tokens {
+		if node := g.GetNode(token.ContractAddress.String()); node != nil {
+			tokens[i] = node.Token
+		}
+	}
+	if uniswapPool, ok := pool.(*eth.UniswapPool); ok {
+		uniswapPool.Token0, uniswapPool.Token1 = tokens[0], tokens[1]
+	}
+}
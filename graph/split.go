@@ -0,0 +1,151 @@
+package graph
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+
+	"gethmate/eth"
+)
+
+// Precision of the allocation arithmetic, enough for reserves of any token
+const splitPrecision = 256
+
+// Fraction of the input left after the Uniswap V2 fee
+var uniswapFeeFactor = new(big.Float).SetPrec(splitPrecision).Quo(big.NewFloat(997), big.NewFloat(1000))
+
+// PoolAllocation is the part of a split trade sent through one pool
+type PoolAllocation struct {
+	Pool      eth.Pool
+	AmountIn  *big.Int
+	AmountOut *big.Int
+}
+
+// PoolSplit is a trade between two tokens divided across the pools that
+// trade them directly
+type PoolSplit struct {
+	TokenIn     *eth.ERC20Token
+	TokenOut    *eth.ERC20Token
+	AmountIn    *big.Int
+	AmountOut   *big.Int
+	Allocations []PoolAllocation
+	Best        PoolAllocation // The whole amount through the best single pool, for comparison
+}
+
+// Split amountIn of tokenIn across the constant product pools trading it for
+// tokenOut to get the most out. Output is maximised where every pool used
+// has the same marginal price, so pools are added from the best spot price
+// down for as long as they beat the marginal price of those already used.
+func (g *Graph) SplitAcrossPools(tokenIn, tokenOut *Node, amountIn *big.Int) (*PoolSplit, error) {
+	if amountIn.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid amount %s", amountIn)
+	}
+	pools := make([]*eth.UniswapPool, 0)
+	for _, pool := range g.PoolsBetween(tokenIn, tokenOut) {
+		if uniswapPool, ok := pool.(*eth.UniswapPool); ok && hasLiquidity(uniswapPool) {
+			pools = append(pools, uniswapPool)
+		}
+	}
+	if len(pools) == 0 {
+		return nil, fmt.Errorf("no constant product pool trades %s for %s", tokenIn.Token.Symbol, tokenOut.Token.Symbol)
+	}
+
+	split := &PoolSplit{TokenIn: tokenIn.Token, TokenOut: tokenOut.Token, AmountIn: amountIn, AmountOut: new(big.Int)}
+	for _, pool := range pools {
+		amountOut := pool.GetAmountOut(tokenIn.Token, tokenOut.Token, amountIn)
+		if split.Best.Pool == nil || amountOut.Cmp(split.Best.AmountOut) > 0 {
+			split.Best = PoolAllocation{Pool: pool, AmountIn: amountIn, AmountOut: amountOut}
+		}
+	}
+
+	amounts := allocate(pools, tokenIn.Token, amountIn)
+	for i, pool := range pools {
+		if amounts[i].Sign() == 0 {
+			continue
+		}
+		amountOut := pool.GetAmountOut(tokenIn.Token, tokenOut.Token, amounts[i])
+		split.Allocations = append(split.Allocations, PoolAllocation{Pool: pool, AmountIn: amounts[i], AmountOut: amountOut})
+		split.AmountOut.Add(split.AmountOut, amountOut)
+	}
+	// Rounding each allocation down can lose out to a single pool on tiny trades
+	if split.AmountOut.Cmp(split.Best.AmountOut) < 0 {
+		split.Allocations = []PoolAllocation{split.Best}
+		split.AmountOut = split.Best.AmountOut
+	}
+	return split, nil
+}
+
+func hasLiquidity(pool *eth.UniswapPool) bool {
+	return pool.Reserve0 != nil && pool.Reserve1 != nil && pool.Reserve0.Sign() > 0 && pool.Reserve1.Sign() > 0
+}
+
+// Allocate amountIn between pools so their marginal prices are equal. With
+// fee factor γ, a pool with reserves a in and b out has marginal price
+// γab/(a+γx)² after taking x, so at a common marginal price λ each pool used
+// takes x = (√(γab/λ) - a)/γ, and summing over the pools gives
+// 1/√λ = (γ·amountIn + Σa) / Σ√(γab).
+func allocate(pools []*eth.UniswapPool, tokenIn *eth.ERC20Token, amountIn *big.Int) []*big.Int {
+	type candidate struct {
+		index     int
+		reserveIn *big.Float
+		root      *big.Float // √(γab)
+	}
+	candidates := make([]candidate, len(pools))
+	for i, pool := range pools {
+		reserveIn, reserveOut := pool.Reserve0, pool.Reserve1
+		if !tokenIn.Equals(pool.Token0) {
+			reserveIn, reserveOut = pool.Reserve1, pool.Reserve0
+		}
+		a := new(big.Float).SetPrec(splitPrecision).SetInt(reserveIn)
+		b := new(big.Float).SetPrec(splitPrecision).SetInt(reserveOut)
+		root := new(big.Float).SetPrec(splitPrecision).Mul(a, b)
+		root.Mul(root, uniswapFeeFactor).Sqrt(root)
+		candidates[i] = candidate{index: i, reserveIn: a, root: root}
+	}
+	// Best spot price, b/a, first. Comparing √(γab)/a orders the same way.
+	sort.SliceStable(candidates, func(i, j int) bool {
+		left := new(big.Float).Mul(candidates[i].root, candidates[j].reserveIn)
+		right := new(big.Float).Mul(candidates[j].root, candidates[i].reserveIn)
+		return left.Cmp(right) > 0
+	})
+
+	total := new(big.Float).SetPrec(splitPrecision).SetInt(amountIn)
+	total.Mul(total, uniswapFeeFactor)
+	sumIn := new(big.Float).SetPrec(splitPrecision)
+	sumRoot := new(big.Float).SetPrec(splitPrecision)
+	var scale *big.Float // 1/√λ for the pools used so far
+	used := 0
+	for _, c := range candidates {
+		nextIn := new(big.Float).Add(sumIn, c.reserveIn)
+		nextRoot := new(big.Float).Add(sumRoot, c.root)
+		nextScale := new(big.Float).Add(total, nextIn)
+		nextScale.Quo(nextScale, nextRoot)
+		// A pool is worth using if its spot price beats the common marginal
+		// price, so it takes a positive amount
+		if new(big.Float).Mul(c.root, nextScale).Cmp(c.reserveIn) <= 0 {
+			break
+		}
+		sumIn, sumRoot, scale = nextIn, nextRoot, nextScale
+		used++
+	}
+
+	amounts := make([]*big.Int, len(pools))
+	for i := range amounts {
+		amounts[i] = new(big.Int)
+	}
+	allocated := new(big.Int)
+	largest := candidates[0].index
+	for _, c := range candidates[:used] {
+		x := new(big.Float).Mul(c.root, scale)
+		x.Sub(x, c.reserveIn).Quo(x, uniswapFeeFactor)
+		x.Int(amounts[c.index])
+		allocated.Add(allocated, amounts[c.index])
+		if amounts[c.index].Cmp(amounts[largest]) > 0 {
+			largest = c.index
+		}
+	}
+	// Rounding leaves a remainder, which may be negative, for the largest
+	// allocation to absorb
+	amounts[largest].Add(amounts[largest], new(big.Int).Sub(amountIn, allocated))
+	return amounts
+}
@@ -0,0 +1,89 @@
+// Package testutil builds the tokens, pools and markets shared by the graph,
+// api, mempool and executor tests.
+package testutil
+
+import (
+	"math/big"
+
+	"gethmate/eth"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Mainnet addresses of the tokens tests trade, so that WETH is the graph's
+// base token and DAI and USDC are priced as stablecoins. WETH repeats
+// graph.WETHAddress, as graph's own tests import this package.
+var mainnetAddresses = map[string]string{
+	"WETH": "0xc02aaa39b223fe8d0a0e5c4f27ead9083c756cc2",
+	"DAI":  "0x6b175474e89094c44da98b954eedeac495271d0f",
+	"USDC": "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48",
+	"LINK": "0x514910771af9ca656af840dff83e8264ecf986ca",
+}
+
+// An initialized token at its mainnet address if the symbol is one tests
+// trade, and at an address made from the symbol otherwise
+func NewToken(symbol string, decimals int) *eth.ERC20Token {
+	address := common.BytesToAddress([]byte(symbol))
+	if mainnet, exists := mainnetAddresses[symbol]; exists {
+		address = common.HexToAddress(mainnet)
+	}
+	token := eth.NewERC20Token(address)
+	token.Symbol = symbol
+	token.Decimals = decimals
+	token.Initalized = true
+	return token
+}
+
+// An initialized Uniswap V2 pool with the given reserves
+func NewPool(address string, token0, token1 *eth.ERC20Token, reserve0, reserve1 *big.Int) *eth.UniswapPool {
+	pool := eth.NewUniswapPool(address)
+	pool.Token0 = token0
+	pool.Token1 = token1
+	pool.Reserve0 = reserve0
+	pool.Reserve1 = reserve1
+	pool.Initialized = true
+	return pool
+}
+
+// A Uniswap V2 pair at the address the factory would deploy it to
+func NewPair(token0, token1 *eth.ERC20Token, reserve0, reserve1 *big.Int) *eth.UniswapPool {
+	address := eth.UniswapPairAddress(eth.UniswapV2FactoryAddress, token0.ContractAddress, token1.ContractAddress)
+	return NewPool(address.String(), token0, token1, reserve0, reserve1)
+}
+
+// Amount of a token with 18 decimals
+func Ether(amount int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(amount), new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil))
+}
+
+// Market is a set of tokens and the pools trading them
+type Market struct {
+	WETH, DAI, USDC *eth.ERC20Token
+	Pools           []*eth.UniswapPool
+}
+
+// Add every pool in the market to a graph
+func (m Market) AddTo(g interface{ AddPool(eth.Pool) }) {
+	for _, pool := range m.Pools {
+		g.AddPool(pool)
+	}
+}
+
+// A WETH -> DAI -> USDC -> WETH cycle where DAI is cheap against WETH in the
+// first pool, 0x01, and dear in the third, 0x03, through a deep DAI/USDC pool
+// at 0x02
+func CheapDAI() Market {
+	weth := NewToken("WETH", 18)
+	dai := NewToken("DAI", 18)
+	usdc := NewToken("USDC", 6)
+	return Market{
+		WETH: weth,
+		DAI:  dai,
+		USDC: usdc,
+		Pools: []*eth.UniswapPool{
+			NewPool("0x01", weth, dai, Ether(100), Ether(330_000)),
+			NewPool("0x02", dai, usdc, Ether(10_000_000), big.NewInt(10_000_000e6)),
+			NewPool("0x03", usdc, weth, big.NewInt(3_000_000e6), Ether(1_000)),
+		},
+	}
+}
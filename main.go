@@ -2,17 +2,30 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"math/big"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"gethmate/api"
+	"gethmate/chain"
 	"gethmate/eth"
+	"gethmate/gas"
 	"gethmate/graph"
+	"gethmate/metrics"
+	"gethmate/rpcpool"
+	"gethmate/utils"
+	"gethmate/workers"
 
-	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
 )
 
 type BlockNumberResponse struct {
@@ -22,65 +35,223 @@ type BlockNumberResponse struct {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "report":
+			runReport(os.Args[2:])
+			return
+		case "quote":
+			runQuote(os.Args[2:])
+			return
+		case "route":
+			runRoute(os.Args[2:])
+			return
+		case "split":
+			runSplit(os.Args[2:])
+			return
+		}
+	}
+
+	rpcURLs := flag.String("rpc", "http://localhost:8545,ws://localhost:8546", "Comma separated node endpoints (http and ws)")
+	callTimeout := flag.Duration("call-timeout", 10*time.Second, "Timeout for a single node call")
+	blockTimeout := flag.Duration("block-timeout", 12*time.Second, "Deadline for processing a single block")
+	concurrency := flag.Int("concurrency", 24, "Number of node calls to run concurrently")
+	rateLimit := flag.Float64("rate-limit", 0, "Maximum node calls per second, 0 for no limit")
+	minLiquidity := flag.Float64("min-liquidity", 300, "Minimum pool liquidity in WETH kept when trimming")
+	trimOutput := flag.String("trim-output", "", "File to write the pool addresses kept by trimming to")
+	priorityFee := flag.Float64("priority-fee", 1, "Priority fee in gwei paid on top of the base fee")
+	calibrateGas := flag.Bool("calibrate-gas", false, "Estimate the gas of one swap per pool type with eth_estimateGas at startup, instead of using the defaults")
+	minProfit := flag.Float64("min-profit", 0, "Profit in WETH an opportunity must make after gas")
+	metricsAddr := flag.String("metrics", "", "Address to serve Prometheus metrics on at /metrics, e.g. :9090. Disabled if empty")
+	tokenCacheFile := flag.String("token-cache", "token_cache.json", "File to cache token metadata in between runs")
+	snapshotFile := flag.String("snapshot", "graph_snapshot.json", "File to write the graph snapshot to on shutdown")
+	opportunitiesFile := flag.String("opportunities", "opportunities.jsonl", "Journal to append found opportunities to")
+	watchMempool := flag.Bool("mempool", false, "Watch pending Uniswap V2 router swaps, report the pools they would move and search for backruns")
+	apiAddr := flag.String("api", "", "Address to serve the HTTP JSON API and websocket stream on, e.g. :8080. Disabled if empty")
+	logLevel := flag.String("log-level", "info", "Minimum level logged: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "Log output format: text or json")
+	flag.Parse()
+	logger, err := newLogger(os.Stderr, *logLevel, *logFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
 	args := make(map[string]bool)
-	for _, arg := range os.Args {
+	for _, arg := range flag.Args() {
 		args[arg] = true
 	}
-	wsClient, err := ethclient.Dial("ws://localhost:8546")
-	if err != nil {
-		log.Fatalf("Failed to connect to the Ethereum client (ws): %v", err)
+
+	// Cancelled on SIGINT/SIGTERM to shut down gracefully
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *metricsAddr != "" {
+		go func() {
+			if err := metrics.Serve(ctx, *metricsAddr); err != nil {
+				slog.Error("Failed to serve metrics", "addr", *metricsAddr, "err", err)
+			}
+		}()
+	}
+
+	var apiServer *api.Server
+	if *apiAddr != "" {
+		apiServer = api.NewServer()
+		go func() {
+			if err := apiServer.Serve(ctx, *apiAddr); err != nil {
+				slog.Error("Failed to serve api", "addr", *apiAddr, "err", err)
+			}
+		}()
 	}
-	defer wsClient.Close()
 
-	client, err := ethclient.Dial("http://localhost:8545")
+	pool, err := rpcpool.Dial(ctx, strings.Split(*rpcURLs, ","))
 	if err != nil {
-		log.Fatalf("Failed to connect to the Ethereum client (http): %v", err)
+		fatal("Failed to connect to the Ethereum client", err)
 	}
-	defer client.Close()
+	defer pool.Close()
+	go pool.Run(ctx)
+	// Discovery and refresh share one worker pool, so together they never
+	// exceed the configured concurrency and rate
+	workerPool := workers.New(*concurrency, *rateLimit)
+	defer workerPool.Close()
+	client := eth.WithRateLimit(eth.WithCallTimeout(pool, *callTimeout), workerPool)
 
-	headers := make(chan *types.Header)
-	ctx := context.Background()
-	sub, err := wsClient.SubscribeNewHead(ctx, headers)
+	tokens, err := eth.LoadTokenCache(*tokenCacheFile)
 	if err != nil {
-		log.Fatal(err)
+		slog.Warn("Failed to load token cache, starting empty", "err", err)
+		tokens = &sync.Map{}
 	}
 
 	// Get uniswap pools
-	fmt.Printf("Starting GethMate.\nTimestamp: %s\n", time.Now())
-	fmt.Println("Getting all Uniswap pools. This may take some time...")
+	slog.Info("Starting GethMate")
+	slog.Info("Getting all Uniswap pools. This may take some time...", "stage", "load")
 	// allPools := eth.GetUniswapPools()
-	allPools := eth.GetUniswapPools(client)
+	allPools, err := eth.GetUniswapPools(ctx, client, workerPool, tokens)
+	if err != nil {
+		fatal("Failed to load Uniswap pools", err)
+	}
+	slog.Info("Getting all Curve pools", "stage", "load")
+	curvePools, err := eth.GetCurvePools(ctx, client, workerPool, tokens)
+	if err != nil {
+		fatal("Failed to load Curve pools", err)
+	}
+	slog.Info("Getting all Balancer pools", "stage", "load")
+	balancerPools, err := eth.GetBalancerPools(ctx, client, workerPool, tokens)
+	if err != nil {
+		fatal("Failed to load Balancer pools", err)
+	}
+
+	if ctx.Err() != nil {
+		slog.Warn("Interrupted while loading pools", "stage", "load")
+		writeTokenCache(*tokenCacheFile, tokens)
+		return
+	}
 
 	// Create graph
-	fmt.Println("Initialising data structures. This may take some time...")
-	graph := graph.NewGraph()
+	slog.Info("Initialising data structures. This may take some time...", "stage", "load",
+		"uniswap", len(allPools), "curve", len(curvePools), "balancer", len(balancerPools))
+	g := graph.NewGraph()
 	for _, pool := range allPools {
-		graph.AddEdge(&pool)
+		g.AddPool(&pool)
+	}
+	for _, pool := range curvePools {
+		g.AddPool(&pool)
+	}
+	for _, pool := range balancerPools {
+		g.AddPool(&pool)
 	}
 
-	// Trim away low liquidity pools
-	// To do this, we can run algorithm to get liquidity value in Eth and
-	// remove nodes and their edges that are below a threshold.
-	// (TAKES AGES...)
+	// Trim away low liquidity pools and tokens that cannot be part of a cycle
 	if args["trim"] {
-		fmt.Println("Trimming data structure.")
-		graph.TrimNodes(*new(big.Float).SetInt64(300))
+		slog.Info("Trimming data structure", "stage", "prune")
+		report, err := g.Prune(graph.WETHAddress, new(big.Float).SetFloat64(*minLiquidity))
+		if err != nil {
+			fatal("Failed to trim graph", err)
+		}
+		slog.Info("Trimmed graph", "stage", "prune", "report", report.String())
+		if *trimOutput != "" {
+			if err := utils.WriteAddressesToFile(*trimOutput, report.Kept); err != nil {
+				slog.Error("Failed to write trimmed pool addresses", "file", *trimOutput, "err", err)
+			}
+		}
+	}
+	priorityFeeWei, _ := new(big.Float).Mul(big.NewFloat(*priorityFee), big.NewFloat(params.GWei)).Int(nil)
+	minProfitWei, _ := new(big.Float).Mul(big.NewFloat(*minProfit), big.NewFloat(params.Ether)).Int(nil)
+	config := BotConfig{
+		StartAmountIn: new(big.Float).SetFloat64(0.1),
+		BlockTimeout:  *blockTimeout,
+		Gas:           gas.NewModel(priorityFeeWei),
+		MinProfit:     minProfitWei,
+		API:           apiServer,
+	}
+	if *calibrateGas {
+		slog.Info("Calibrating swap gas", "stage", "calibrate")
+		config.Gas.CalibratePools(ctx, pool, g.GetTokenPools(graph.WETHAddress), common.HexToAddress(graph.WETHAddress))
 	}
-	startAmountIn := new(big.Float).SetFloat64(0.1)
+	journal, err := graph.OpenJournal(*opportunitiesFile)
+	if err != nil {
+		fatal("Failed to open opportunity journal", err)
+	}
+	defer journal.Close()
+	config.Journal = journal
 
-	for {
-		select {
-		case err := <-sub.Err():
-			log.Fatal(err)
-		case header := <-headers:
-			blockNumber := header.Number
-			fmt.Println("New block:", blockNumber.String())
+	// Follow new heads, reconnecting if the ws connection drops
+	heads := chain.NewHeadSubscriber(pool.HeadDialer())
+	go heads.Run(ctx)
 
-			// Update edge weights for new block
-			graph.UpdateAllEdges(client)
+	bot := NewBot(pool, client, workerPool, g, config)
+	// Simulate pending swaps against each block's view to find backruns
+	mempoolDone := make(chan struct{})
+	if *watchMempool {
+		pending := chain.NewPendingSubscriber(pool.PendingDialer())
+		go pending.Run(ctx)
+		go func() {
+			defer close(mempoolDone)
+			bot.WatchMempool(ctx, pending.Transactions())
+		}()
+	} else {
+		close(mempoolDone)
+	}
+	bot.Run(ctx, heads.Headers())
+	// Stop the mempool watcher in case the heads ended first, and let its
+	// backruns finish journaling before the journal is closed
+	stop()
+	<-mempoolDone
 
-			// Find arbitrage path
-			graph.Strategy(startAmountIn)
-		}
+	// Persist state once the in-flight block has finished
+	if err := g.WriteSnapshot(*snapshotFile); err != nil {
+		slog.Error("Failed to write graph snapshot", "file", *snapshotFile, "err", err)
 	}
+	writeTokenCache(*tokenCacheFile, tokens)
+	bot.LogSummary()
+	slog.Info("Shut down GethMate")
+}
+
+func writeTokenCache(filename string, tokens *sync.Map) {
+	if err := eth.WriteTokenCache(filename, tokens); err != nil {
+		slog.Error("Failed to write token cache", "file", filename, "err", err)
+	}
+}
+
+// Build the logger configured by the -log-level and -log-format flags
+func newLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var minLevel slog.Level
+	if err := minLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %v", level, err)
+	}
+	options := &slog.HandlerOptions{Level: minLevel}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q, expected text or json", format)
+	}
+}
+
+// Log err and exit. Deferred calls are not run.
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
 }
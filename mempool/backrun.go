@@ -0,0 +1,143 @@
+package mempool
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"gethmate/eth"
+	"gethmate/graph"
+)
+
+// Copy the graph the swap was simulated on, with the pools it moves in the
+// state they would be left in
+func (i *Impact) Project() *graph.Graph {
+	pools := make([]eth.Pool, len(i.Moves))
+	for j, move := range i.Moves {
+		pools[j] = move.After
+	}
+	return i.Graph.WithPools(pools...)
+}
+
+// Search the state after a pending swap for an arbitrage of at most maxHops
+// hops through a pool it moves, to be executed right behind it. Cycles that
+// do not touch a moved pool already exist before the swap, so are left to
+// the block's search. Each candidate trades through a moved pool in one
+// direction, reaching it from WETH along the best route that avoids it, and
+// returning to WETH along the best route that avoids the pools traded so far,
+// quoted exactly. Returns the candidate returning the most WETH, or nil if
+// none returns more than startAmountIn or ctx is done first. The opportunity
+// is not evaluated for gas.
+//
+// This is a heuristic rather than a search of every cycle: each leg is the
+// best route on its own, as found by Graph.Route, so a backrun whose legs are
+// only profitable together, or that trades through a moved pool twice, can be
+// missed.
+func Backrun(ctx context.Context, impact *Impact, startAmountIn *big.Float, maxHops int) *graph.Opportunity {
+	if impact.Reverts || len(impact.Moves) == 0 {
+		return nil
+	}
+	projected := impact.Project()
+	start := projected.GetNode(graph.WETHAddress)
+	if start == nil {
+		return nil
+	}
+	amountIn := graph.ToUnits(startAmountIn, start.Token.Decimals)
+	var bestPath []*graph.Edge
+	bestOut := amountIn
+	for _, move := range impact.Moves {
+		tokens := move.After.GetTokens()
+		for i := range tokens {
+			for j := range tokens {
+				if i == j {
+					continue
+				}
+				if ctx.Err() != nil {
+					return nil
+				}
+				path, amountOut := cycleThrough(projected, start, move.After, tokens[i], tokens[j], amountIn, maxHops)
+				if path != nil && amountOut.Cmp(bestOut) > 0 {
+					bestPath, bestOut = path, amountOut
+				}
+			}
+		}
+	}
+	if bestPath == nil {
+		return nil
+	}
+
+	opportunity := &graph.Opportunity{
+		Start:    start,
+		Path:     bestPath,
+		AmountIn: startAmountIn,
+		FoundAt:  time.Now(),
+		Trigger:  impact.Swap.Hash,
+	}
+	if impact.Header != nil {
+		opportunity.Block = graph.BlockRef{Number: impact.Header.Number.Uint64(), Hash: impact.Header.Hash()}
+	}
+	return opportunity
+}
+
+// Find the cycle from start that trades tokenA for tokenB through pool,
+// returning its edges and the amount it returns, or nil if there is none
+// within maxHops. Each pool is traded through once.
+func cycleThrough(g *graph.Graph, start *graph.Node, pool eth.Pool, tokenA, tokenB *eth.ERC20Token, amountIn *big.Int, maxHops int) ([]*graph.Edge, *big.Int) {
+	nodeA := g.GetNode(tokenA.ContractAddress.String())
+	nodeB := g.GetNode(tokenB.ContractAddress.String())
+	if nodeA == nil || nodeB == nil {
+		return nil, nil
+	}
+	path := make([]*graph.Edge, 0, maxHops)
+	amount := amountIn
+	// Hops left for the routes to and from the pool
+	spare := maxHops - 1
+	if nodeA != start && nodeB != start {
+		spare--
+	}
+
+	if nodeA != start {
+		if spare < 1 {
+			return nil, nil
+		}
+		quote, err := g.RouteAvoiding(start, nodeA, amount, spare, pool)
+		if err != nil {
+			return nil, nil
+		}
+		path = append(path, quoteEdges(g, quote)...)
+		amount = quote.AmountOut
+	}
+	edge := g.GetEdge(pool.GetAddress().String(), tokenA.ContractAddress.String(), tokenB.ContractAddress.String())
+	if edge == nil {
+		return nil, nil
+	}
+	path = append(path, edge)
+	amount = edge.Pool.GetAmountOut(tokenA, tokenB, amount)
+	if nodeB != start {
+		remaining := maxHops - len(path)
+		if remaining < 1 || amount.Sign() <= 0 {
+			return nil, nil
+		}
+		// Avoid the pools traded so far, whose reserves the quote would not
+		// have moved
+		traded := make([]eth.Pool, len(path))
+		for i, edge := range path {
+			traded[i] = edge.Pool
+		}
+		quote, err := g.RouteAvoiding(nodeB, start, amount, remaining, traded...)
+		if err != nil {
+			return nil, nil
+		}
+		path = append(path, quoteEdges(g, quote)...)
+		amount = quote.AmountOut
+	}
+	return path, amount
+}
+
+func quoteEdges(g *graph.Graph, quote *graph.Quote) []*graph.Edge {
+	edges := make([]*graph.Edge, len(quote.Hops))
+	for i, hop := range quote.Hops {
+		edges[i] = g.GetEdge(hop.Pool.GetAddress().String(), hop.TokenIn.ContractAddress.String(), hop.TokenOut.ContractAddress.String())
+	}
+	return edges
+}
@@ -0,0 +1,130 @@
+package mempool
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"gethmate/eth"
+	"gethmate/gas"
+	"gethmate/graph"
+	"gethmate/internal/testutil"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestBackrun(t *testing.T) {
+	fmt.Println("TestBackrun")
+	g, market := newTestGraph()
+	// Another exchange's WETH/DAI pool at the same price, which the router
+	// does not trade through
+	g.AddPool(testutil.NewPool("0x0000000000000000000000000000000000000042", market.WETH, market.DAI, testutil.Ether(500), testutil.Ether(1_500_000)))
+	// An arbitrage between two WETH/LINK pools that exists before the swap
+	link := testutil.NewToken("LINK", 18)
+	g.AddPool(testutil.NewPair(market.WETH, link, testutil.Ether(1_000), testutil.Ether(100_000)))
+	g.AddPool(testutil.NewPool("0x0000000000000000000000000000000000000043", market.WETH, link, testutil.Ether(1_000), testutil.Ether(120_000)))
+	start := big.NewFloat(1)
+
+	// Selling a lot of WETH for DAI through the router leaves WETH cheaper in
+	// the pair than in the other pool
+	header := &types.Header{Number: big.NewInt(7), BaseFee: big.NewInt(1e9)}
+	swap := &Swap{
+		Hash:         common.HexToHash("0xabc"),
+		Path:         []common.Address{market.WETH.ContractAddress, market.DAI.ContractAddress},
+		AmountIn:     testutil.Ether(50),
+		AmountOutMin: big.NewInt(0),
+	}
+	impact, err := Simulate(g, header, swap)
+	if err != nil {
+		t.Fatalf("Failed to simulate: %v", err)
+	}
+	opportunity := Backrun(context.Background(), impact, start, 3)
+	if opportunity == nil {
+		t.Fatalf("Expected a backrun")
+	}
+	if opportunity.Trigger != swap.Hash || opportunity.Block.Number != 7 {
+		t.Errorf("Expected the backrun tied to the swap at block 7, got %s at %d", opportunity.Trigger, opportunity.Block.Number)
+	}
+	moved := impact.Moves[0].After
+	touches := false
+	for _, edge := range opportunity.Path {
+		touches = touches || edge.Pool == moved
+	}
+	if !touches {
+		t.Errorf("Expected the backrun to trade through the moved pool, got %s", graph.PathString(opportunity.Start, opportunity.Path))
+	}
+	opportunity.Evaluate(gas.NewModel(big.NewInt(0)), header)
+	if opportunity.GrossProfit.Sign() <= 0 {
+		t.Errorf("Expected the backrun to profit, got %s", opportunity.GrossProfit)
+	}
+	if record := opportunity.Record(); record.Trigger != swap.Hash.Hex() {
+		t.Errorf("Expected the record to carry the trigger, got %q", record.Trigger)
+	}
+
+	// Projecting leaves the graph the swap was simulated on untouched
+	if g.GetPool(impact.Moves[0].Pool.GetAddress().String()).(*eth.UniswapPool).Reserve0.Cmp(testutil.Ether(1_000)) != 0 {
+		t.Errorf("Expected the graph's pool to be unchanged")
+	}
+
+	// A tiny swap does not open a cycle that pays the pools' fees
+	swap.AmountIn = testutil.Ether(1)
+	impact, _ = Simulate(g, nil, swap)
+	if opportunity = Backrun(context.Background(), impact, start, 3); opportunity != nil {
+		t.Errorf("Expected no backrun for a tiny swap, got %s", graph.PathString(opportunity.Start, opportunity.Path))
+	}
+	swap.AmountIn = testutil.Ether(50)
+	impact, _ = Simulate(g, nil, swap)
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if Backrun(cancelled, impact, start, 3) != nil {
+		t.Errorf("Expected no backrun once the context is done")
+	}
+
+	// A swap that would revert has no backrun
+	swap.AmountOutMin = testutil.Ether(1_000_000)
+	if impact, _ = Simulate(g, nil, swap); Backrun(context.Background(), impact, start, 3) != nil {
+		t.Errorf("Expected no backrun for a reverted swap")
+	}
+}
+
+func TestBackrunAvoidsTradedPools(t *testing.T) {
+	fmt.Println("TestBackrunAvoidsTradedPools")
+	g, market := newTestGraph()
+	// USDC is cheap in DAI through another exchange's pool, and WETH is
+	// cheap in USDC in a shallow pool. Large amounts of USDC go further back
+	// to WETH through the WETH/DAI pair than through the shallow pool.
+	g.AddPool(testutil.NewPool("0x0000000000000000000000000000000000000044", market.USDC, market.WETH, big.NewInt(19_000e6), testutil.Ether(10)))
+	g.AddPool(testutil.NewPool("0x0000000000000000000000000000000000000045", market.DAI, market.USDC, testutil.Ether(1_500_000), big.NewInt(1_000_000e6)))
+
+	// Buying DAI with USDC through the router leaves USDC cheap in the pair.
+	// The best way back from the pair to WETH trades through the WETH/DAI
+	// pair the backrun starts in, so the shallow pool is taken instead.
+	swap := &Swap{
+		Hash:         common.HexToHash("0xdef"),
+		Path:         []common.Address{market.USDC.ContractAddress, market.DAI.ContractAddress},
+		AmountIn:     big.NewInt(500_000e6),
+		AmountOutMin: big.NewInt(0),
+	}
+	impact, err := Simulate(g, nil, swap)
+	if err != nil {
+		t.Fatalf("Failed to simulate: %v", err)
+	}
+	opportunity := Backrun(context.Background(), impact, big.NewFloat(1), 4)
+	if opportunity == nil {
+		t.Fatalf("Expected a backrun")
+	}
+	used := make(map[eth.Pool]bool)
+	touches := false
+	for _, edge := range opportunity.Path {
+		if used[edge.Pool] {
+			t.Errorf("Expected each pool traded once, got %s", graph.PathString(opportunity.Start, opportunity.Path))
+		}
+		used[edge.Pool] = true
+		touches = touches || edge.Pool == impact.Moves[0].After
+	}
+	if !touches {
+		t.Errorf("Expected the backrun to trade through the moved pool, got %s", graph.PathString(opportunity.Start, opportunity.Path))
+	}
+}
@@ -0,0 +1,135 @@
+package mempool
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"gethmate/eth"
+	"gethmate/graph"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// ErrUntracked is returned for swaps through a pair the graph does not have
+var ErrUntracked = errors.New("pair not in graph")
+
+// PoolMove is how one pool changes when a pending swap trades through it
+type PoolMove struct {
+	Pool eth.Pool // As in the graph
+	// A clone of Pool with the swap applied. Hops through the same pair share
+	// the clone, so it holds the state the whole swap leaves the pair in.
+	After     eth.Pool
+	TokenIn   *eth.ERC20Token
+	TokenOut  *eth.ERC20Token
+	AmountIn  *big.Int
+	AmountOut *big.Int
+
+	PriceBefore *big.Float // Whole tokenOut per whole tokenIn, before and after this hop
+	PriceAfter  *big.Float
+	PriceChange float64 // Fractional change of the price, negative as tokenIn gets cheaper
+}
+
+// Impact is the simulated effect of a pending swap on the graph's pools
+type Impact struct {
+	Swap      *Swap
+	Moves     []PoolMove // Empty if the swap reverts
+	AmountIn  *big.Int
+	AmountOut *big.Int
+	// The swap would fail its slippage limit or deadline at the graph's
+	// state, so would move nothing
+	Reverts bool
+
+	Graph  *graph.Graph  // The state the swap was simulated on
+	Header *types.Header // The block of that state, nil if unknown
+}
+
+// Simulate a swap through the Uniswap V2 pairs on its path at the graph's
+// reserves, the state at header. Like the router, every hop is quoted on the
+// reserves before the swap, then the hops are applied in turn, so a second
+// hop through a pair trades on the reserves the first left. Fee on transfer
+// tokens are simulated as plain transfers. The swap reverts if its deadline
+// is not after header's timestamp, as it can only be mined in a later block.
+func Simulate(g *graph.Graph, header *types.Header, swap *Swap) (*Impact, error) {
+	pools := make([]*eth.UniswapPool, len(swap.Path)-1)
+	tokens := make([]*eth.ERC20Token, len(swap.Path))
+	for i, address := range swap.Path {
+		node := g.GetNode(address.String())
+		if node == nil {
+			return nil, fmt.Errorf("%w: token %s", ErrUntracked, address)
+		}
+		tokens[i] = node.Token
+	}
+	for i := range pools {
+		pair := eth.UniswapPairAddress(eth.UniswapV2FactoryAddress, swap.Path[i], swap.Path[i+1])
+		pool, ok := g.GetPool(pair.String()).(*eth.UniswapPool)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUntracked, pair)
+		}
+		pools[i] = pool
+	}
+
+	// Amounts before and after each hop
+	amounts := make([]*big.Int, len(swap.Path))
+	impact := &Impact{Swap: swap, Graph: g, Header: header}
+	if header != nil && swap.Deadline != nil && swap.Deadline.Cmp(new(big.Int).SetUint64(header.Time)) <= 0 {
+		impact.Reverts = true
+		return impact, nil
+	}
+	if swap.ExactOut {
+		amounts[len(amounts)-1] = swap.AmountOut
+		for i := len(pools) - 1; i >= 0; i-- {
+			amounts[i] = pools[i].GetAmountIn(tokens[i], tokens[i+1], amounts[i+1])
+			if amounts[i] == nil {
+				impact.Reverts = true
+				return impact, nil
+			}
+		}
+		impact.Reverts = amounts[0].Cmp(swap.AmountInMax) > 0
+	} else {
+		amounts[0] = swap.AmountIn
+		for i, pool := range pools {
+			amounts[i+1] = pool.GetAmountOut(tokens[i], tokens[i+1], amounts[i])
+		}
+		last := amounts[len(amounts)-1]
+		impact.Reverts = last.Sign() == 0 || last.Cmp(swap.AmountOutMin) < 0
+	}
+	impact.AmountIn, impact.AmountOut = amounts[0], amounts[len(amounts)-1]
+	if impact.Reverts {
+		return impact, nil
+	}
+
+	clones := make(map[*eth.UniswapPool]eth.Pool, len(pools))
+	moves := make([]PoolMove, 0, len(pools))
+	for i, pool := range pools {
+		after, exists := clones[pool]
+		if !exists {
+			after = pool.Clone()
+			clones[pool] = after
+		}
+		// A pair traded through earlier in the swap must still pay out the
+		// amount quoted on its reserves before the swap
+		if exists && after.GetAmountOut(tokens[i], tokens[i+1], amounts[i]).Cmp(amounts[i+1]) < 0 {
+			impact.Reverts = true
+			return impact, nil
+		}
+		move := PoolMove{
+			Pool:        pool,
+			After:       after,
+			TokenIn:     tokens[i],
+			TokenOut:    tokens[i+1],
+			AmountIn:    amounts[i],
+			AmountOut:   amounts[i+1],
+			PriceBefore: after.GetSpotPrice(tokens[i], tokens[i+1]),
+		}
+		after.ApplySwap(tokens[i], tokens[i+1], amounts[i], amounts[i+1])
+		move.PriceAfter = after.GetSpotPrice(tokens[i], tokens[i+1])
+		if move.PriceBefore.Sign() > 0 {
+			ratio, _ := new(big.Float).Quo(move.PriceAfter, move.PriceBefore).Float64()
+			move.PriceChange = ratio - 1
+		}
+		moves = append(moves, move)
+	}
+	impact.Moves = moves
+	return impact, nil
+}
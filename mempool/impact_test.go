@@ -0,0 +1,123 @@
+package mempool
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"gethmate/eth"
+	"gethmate/graph"
+	"gethmate/internal/testutil"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// WETH/DAI and DAI/USDC pairs at the addresses the router trades through
+func newTestGraph() (*graph.Graph, testutil.Market) {
+	weth := testutil.NewToken("WETH", 18)
+	dai := testutil.NewToken("DAI", 18)
+	usdc := testutil.NewToken("USDC", 6)
+	market := testutil.Market{
+		WETH: weth,
+		DAI:  dai,
+		USDC: usdc,
+		Pools: []*eth.UniswapPool{
+			testutil.NewPair(weth, dai, testutil.Ether(1_000), testutil.Ether(3_000_000)),
+			testutil.NewPair(dai, usdc, testutil.Ether(1_000_000), big.NewInt(1_000_000e6)),
+		},
+	}
+	g := graph.NewGraph()
+	market.AddTo(g)
+	return g, market
+}
+
+func TestSimulate(t *testing.T) {
+	fmt.Println("TestSimulate")
+	g, market := newTestGraph()
+	path := []common.Address{market.WETH.ContractAddress, market.DAI.ContractAddress, market.USDC.ContractAddress}
+
+	// An exact input swap moves both pairs on its path
+	impact, err := Simulate(g, nil, &Swap{Path: path, AmountIn: testutil.Ether(10), AmountOutMin: big.NewInt(28_000e6)})
+	if err != nil {
+		t.Fatalf("Failed to simulate: %v", err)
+	}
+	if impact.Reverts || len(impact.Moves) != 2 {
+		t.Fatalf("Expected two pools to move, got %+v", impact)
+	}
+	first := impact.Moves[0]
+	expected := first.Pool.GetAmountOut(market.WETH, market.DAI, testutil.Ether(10))
+	if first.AmountOut.Cmp(expected) != 0 || impact.Moves[1].AmountIn.Cmp(expected) != 0 {
+		t.Errorf("Expected %s DAI between the hops, got %s and %s", expected, first.AmountOut, impact.Moves[1].AmountIn)
+	}
+	after := first.After.(*eth.UniswapPool)
+	if after.Reserve0.Cmp(testutil.Ether(1_010)) != 0 || new(big.Int).Add(after.Reserve1, expected).Cmp(testutil.Ether(3_000_000)) != 0 {
+		t.Errorf("Expected the swap applied to the clone, got %s, %s", after.Reserve0, after.Reserve1)
+	}
+	if first.Pool.(*eth.UniswapPool).Reserve0.Cmp(testutil.Ether(1_000)) != 0 {
+		t.Errorf("Expected the graph's pool to be unchanged")
+	}
+	// Selling WETH makes it cheaper in DAI
+	if first.PriceChange >= -0.01 || first.PriceChange < -0.03 {
+		t.Errorf("Expected the WETH price to fall about 2%%, got %f", first.PriceChange)
+	}
+
+	// A swap whose slippage limit is not met moves nothing
+	impact, err = Simulate(g, nil, &Swap{Path: path, AmountIn: testutil.Ether(10), AmountOutMin: big.NewInt(29_000e6)})
+	if err != nil || !impact.Reverts || len(impact.Moves) != 0 {
+		t.Errorf("Expected the swap to revert, got %+v, %v", impact, err)
+	}
+
+	// An exact output swap is simulated backwards from its output
+	impact, err = Simulate(g, nil, &Swap{Path: path, ExactOut: true, AmountOut: big.NewInt(1_000e6), AmountInMax: testutil.Ether(1)})
+	if err != nil || impact.Reverts {
+		t.Fatalf("Expected the exact output swap to succeed, got %+v, %v", impact, err)
+	}
+	if impact.AmountOut.Cmp(big.NewInt(1_000e6)) != 0 || impact.Moves[1].AmountOut.Cmp(big.NewInt(1_000e6)) != 0 {
+		t.Errorf("Expected exactly 1000 USDC out, got %s", impact.AmountOut)
+	}
+	impact, err = Simulate(g, nil, &Swap{Path: path, ExactOut: true, AmountOut: big.NewInt(1_000e6), AmountInMax: big.NewInt(1)})
+	if err != nil || !impact.Reverts {
+		t.Errorf("Expected the exact output swap to exceed its maximum input, got %+v, %v", impact, err)
+	}
+
+	// A swap past its deadline at the block's timestamp reverts
+	header := &types.Header{Number: big.NewInt(1), Time: 1_700_000_000}
+	swap := &Swap{Path: path, AmountIn: testutil.Ether(10), AmountOutMin: big.NewInt(0), Deadline: big.NewInt(1_700_000_000)}
+	if impact, err = Simulate(g, header, swap); err != nil || !impact.Reverts || impact.Header != header {
+		t.Errorf("Expected the expired swap to revert, got %+v, %v", impact, err)
+	}
+	swap.Deadline = big.NewInt(1_700_000_060)
+	if impact, err = Simulate(g, header, swap); err != nil || impact.Reverts {
+		t.Errorf("Expected the swap to succeed before its deadline, got %+v, %v", impact, err)
+	}
+
+	// Hops through the same pair apply in turn to one clone of it
+	roundTrip := []common.Address{market.WETH.ContractAddress, market.DAI.ContractAddress, market.WETH.ContractAddress}
+	impact, err = Simulate(g, nil, &Swap{Path: roundTrip, AmountIn: testutil.Ether(10), AmountOutMin: big.NewInt(0)})
+	if err != nil || impact.Reverts || len(impact.Moves) != 2 {
+		t.Fatalf("Expected the round trip to move the pair twice, got %+v, %v", impact, err)
+	}
+	if impact.Moves[0].After != impact.Moves[1].After {
+		t.Errorf("Expected both hops to share a clone of the pair")
+	}
+	after = impact.Moves[1].After.(*eth.UniswapPool)
+	expectedWETH := new(big.Int).Sub(testutil.Ether(1_010), impact.AmountOut)
+	if after.Reserve0.Cmp(expectedWETH) != 0 || after.Reserve1.Cmp(testutil.Ether(3_000_000)) != 0 {
+		t.Errorf("Expected %s WETH and 3000000 DAI left in the pair, got %s, %s", expectedWETH, after.Reserve0, after.Reserve1)
+	}
+	// Quoted on the reserves before the swap, a third hop selling WETH again
+	// asks more of the pair than its moved reserves allow
+	roundTrip = append(roundTrip, market.DAI.ContractAddress)
+	impact, err = Simulate(g, nil, &Swap{Path: roundTrip, AmountIn: testutil.Ether(10), AmountOutMin: big.NewInt(0)})
+	if err != nil || !impact.Reverts || len(impact.Moves) != 0 {
+		t.Errorf("Expected the third hop through the pair to revert, got %+v, %v", impact, err)
+	}
+
+	// Pairs the graph does not track cannot be simulated
+	path = []common.Address{market.WETH.ContractAddress, market.USDC.ContractAddress}
+	if _, err := Simulate(g, nil, &Swap{Path: path, AmountIn: testutil.Ether(1), AmountOutMin: big.NewInt(0)}); !errors.Is(err, ErrUntracked) {
+		t.Errorf("Expected ErrUntracked, got %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package mempool
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"gethmate/eth"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+var RouterV2Address = eth.UniswapV2RouterAddress
+
+// ErrNotSwap is returned for calls to the router that are not swaps, such as
+// adding liquidity
+var ErrNotSwap = errors.New("not a swap")
+
+const routerV2ABIJSON = `[
+	{"name":"swapExactTokensForTokens","type":"function","stateMutability":"nonpayable",
+	 "inputs":[{"name":"amountIn","type":"uint256"},{"name":"amountOutMin","type":"uint256"},{"name":"path","type":"address[]"},{"name":"to","type":"address"},{"name":"deadline","type":"uint256"}],
+	 "outputs":[{"name":"amounts","type":"uint256[]"}]},
+	{"name":"swapTokensForExactTokens","type":"function","stateMutability":"nonpayable",
+	 "inputs":[{"name":"amountOut","type":"uint256"},{"name":"amountInMax","type":"uint256"},{"name":"path","type":"address[]"},{"name":"to","type":"address"},{"name":"deadline","type":"uint256"}],
+	 "outputs":[{"name":"amounts","type":"uint256[]"}]},
+	{"name":"swapExactETHForTokens","type":"function","stateMutability":"payable",
+	 "inputs":[{"name":"amountOutMin","type":"uint256"},{"name":"path","type":"address[]"},{"name":"to","type":"address"},{"name":"deadline","type":"uint256"}],
+	 "outputs":[{"name":"amounts","type":"uint256[]"}]},
+	{"name":"swapTokensForExactETH","type":"function","stateMutability":"nonpayable",
+	 "inputs":[{"name":"amountOut","type":"uint256"},{"name":"amountInMax","type":"uint256"},{"name":"path","type":"address[]"},{"name":"to","type":"address"},{"name":"deadline","type":"uint256"}],
+	 "outputs":[{"name":"amounts","type":"uint256[]"}]},
+	{"name":"swapExactTokensForETH","type":"function","stateMutability":"nonpayable",
+	 "inputs":[{"name":"amountIn","type":"uint256"},{"name":"amountOutMin","type":"uint256"},{"name":"path","type":"address[]"},{"name":"to","type":"address"},{"name":"deadline","type":"uint256"}],
+	 "outputs":[{"name":"amounts","type":"uint256[]"}]},
+	{"name":"swapETHForExactTokens","type":"function","stateMutability":"payable",
+	 "inputs":[{"name":"amountOut","type":"uint256"},{"name":"path","type":"address[]"},{"name":"to","type":"address"},{"name":"deadline","type":"uint256"}],
+	 "outputs":[{"name":"amounts","type":"uint256[]"}]},
+	{"name":"swapExactTokensForTokensSupportingFeeOnTransferTokens","type":"function","stateMutability":"nonpayable",
+	 "inputs":[{"name":"amountIn","type":"uint256"},{"name":"amountOutMin","type":"uint256"},{"name":"path","type":"address[]"},{"name":"to","type":"address"},{"name":"deadline","type":"uint256"}],
+	 "outputs":[]},
+	{"name":"swapExactETHForTokensSupportingFeeOnTransferTokens","type":"function","stateMutability":"payable",
+	 "inputs":[{"name":"amountOutMin","type":"uint256"},{"name":"path","type":"address[]"},{"name":"to","type":"address"},{"name":"deadline","type":"uint256"}],
+	 "outputs":[]},
+	{"name":"swapExactTokensForETHSupportingFeeOnTransferTokens","type":"function","stateMutability":"nonpayable",
+	 "inputs":[{"name":"amountIn","type":"uint256"},{"name":"amountOutMin","type":"uint256"},{"name":"path","type":"address[]"},{"name":"to","type":"address"},{"name":"deadline","type":"uint256"}],
+	 "outputs":[]}
+]`
+
+var routerV2ABI, _ = abi.JSON(strings.NewReader(routerV2ABIJSON))
+
+// Swap is a router swap call decoded from a pending transaction. An exact
+// input swap sells AmountIn for at least AmountOutMin; an exact output swap
+// buys AmountOut for at most AmountInMax. For ETH swaps the router wraps or
+// unwraps WETH, which is the path's first or last token.
+type Swap struct {
+	Hash     common.Hash
+	Method   string
+	Path     []common.Address
+	To       common.Address
+	Deadline *big.Int
+	ExactOut bool
+
+	AmountIn     *big.Int // Exact input swaps only
+	AmountOutMin *big.Int
+	AmountOut    *big.Int // Exact output swaps only
+	AmountInMax  *big.Int
+}
+
+// Decode a UniswapV2Router02 swap call. Returns ErrNotSwap for any other
+// call.
+func DecodeSwap(tx *types.Transaction) (*Swap, error) {
+	data := tx.Data()
+	if len(data) < 4 {
+		return nil, ErrNotSwap
+	}
+	method, err := routerV2ABI.MethodById(data[:4])
+	if err != nil {
+		return nil, ErrNotSwap
+	}
+	args := make(map[string]interface{})
+	if err := method.Inputs.UnpackIntoMap(args, data[4:]); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %v", method.Name, err)
+	}
+
+	swap := &Swap{
+		Hash:     tx.Hash(),
+		Method:   method.Name,
+		Path:     args["path"].([]common.Address),
+		To:       args["to"].(common.Address),
+		Deadline: args["deadline"].(*big.Int),
+	}
+	if len(swap.Path) < 2 {
+		return nil, fmt.Errorf("%s has a path of %d tokens", method.Name, len(swap.Path))
+	}
+	if amountOut, ok := args["amountOut"]; ok {
+		swap.ExactOut = true
+		swap.AmountOut = amountOut.(*big.Int)
+		if amountInMax, ok := args["amountInMax"]; ok {
+			swap.AmountInMax = amountInMax.(*big.Int)
+		} else {
+			swap.AmountInMax = tx.Value() // swapETHForExactTokens refunds what it does not use
+		}
+	} else {
+		swap.AmountOutMin = args["amountOutMin"].(*big.Int)
+		if amountIn, ok := args["amountIn"]; ok {
+			swap.AmountIn = amountIn.(*big.Int)
+		} else {
+			swap.AmountIn = tx.Value()
+		}
+	}
+	return swap, nil
+}
@@ -0,0 +1,57 @@
+package mempool
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func newRouterTx(value *big.Int, method string, args ...interface{}) *types.Transaction {
+	data, err := routerV2ABI.Pack(method, args...)
+	if err != nil {
+		panic(err)
+	}
+	return types.NewTx(&types.DynamicFeeTx{To: &RouterV2Address, Value: value, Data: data})
+}
+
+func TestDecodeSwap(t *testing.T) {
+	fmt.Println("TestDecodeSwap")
+	path := []common.Address{common.HexToAddress("0x01"), common.HexToAddress("0x02")}
+	to := common.HexToAddress("0x03")
+	deadline := big.NewInt(1_700_000_000)
+
+	swap, err := DecodeSwap(newRouterTx(nil, "swapExactTokensForTokens", big.NewInt(100), big.NewInt(90), path, to, deadline))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if swap.ExactOut || swap.AmountIn.Int64() != 100 || swap.AmountOutMin.Int64() != 90 || len(swap.Path) != 2 || swap.To != to {
+		t.Errorf("Unexpected exact input swap %+v", swap)
+	}
+
+	// ETH in is the transaction's value
+	swap, err = DecodeSwap(newRouterTx(big.NewInt(5), "swapExactETHForTokens", big.NewInt(4), path, to, deadline))
+	if err != nil || swap.AmountIn.Int64() != 5 || swap.AmountOutMin.Int64() != 4 {
+		t.Errorf("Unexpected ETH swap %+v, %v", swap, err)
+	}
+	swap, err = DecodeSwap(newRouterTx(big.NewInt(7), "swapETHForExactTokens", big.NewInt(3), path, to, deadline))
+	if err != nil || !swap.ExactOut || swap.AmountOut.Int64() != 3 || swap.AmountInMax.Int64() != 7 {
+		t.Errorf("Unexpected exact output ETH swap %+v, %v", swap, err)
+	}
+	swap, err = DecodeSwap(newRouterTx(nil, "swapTokensForExactTokens", big.NewInt(50), big.NewInt(60), path, to, deadline))
+	if err != nil || !swap.ExactOut || swap.AmountOut.Int64() != 50 || swap.AmountInMax.Int64() != 60 {
+		t.Errorf("Unexpected exact output swap %+v, %v", swap, err)
+	}
+
+	// Other router calls are not swaps, and a truncated call fails to decode
+	if _, err := DecodeSwap(types.NewTx(&types.DynamicFeeTx{To: &RouterV2Address, Data: []byte{0xe8, 0xe3, 0x37, 0x00}})); !errors.Is(err, ErrNotSwap) {
+		t.Errorf("Expected ErrNotSwap for an unknown selector, got %v", err)
+	}
+	data := newRouterTx(nil, "swapExactTokensForTokens", big.NewInt(100), big.NewInt(90), path, to, deadline).Data()
+	if _, err := DecodeSwap(types.NewTx(&types.DynamicFeeTx{To: &RouterV2Address, Data: data[:40]})); err == nil || errors.Is(err, ErrNotSwap) {
+		t.Errorf("Expected a decoding error for truncated calldata, got %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package mempool
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gethmate/graph"
+	"gethmate/metrics"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Watcher decodes pending swaps sent to a router and simulates them against
+// the latest published graph, reporting the pools each would move
+type Watcher struct {
+	router common.Address
+	handle func(ctx context.Context, impact *Impact)
+	state  atomic.Pointer[state]
+	// Most impacts handled at once. Impacts arriving while every handler is
+	// busy are not handled, so a slow handler never holds up decoding.
+	Handlers int
+	// Deadline for handling a single impact, zero for none
+	HandleTimeout time.Duration
+
+	busy    chan struct{}
+	handled sync.WaitGroup
+}
+
+type state struct {
+	graph  *graph.Graph
+	header *types.Header
+}
+
+// Create a watcher for swaps sent to router. handle is called with every
+// simulated swap that would not revert, and may be nil.
+func NewWatcher(router common.Address, handle func(ctx context.Context, impact *Impact)) *Watcher {
+	return &Watcher{router: router, handle: handle, Handlers: 4, HandleTimeout: 2 * time.Second}
+}
+
+// Simulate later swaps against g, the state at header, which must not be
+// modified afterwards. Published views satisfy this as their pools are
+// replaced, not modified.
+func (w *Watcher) SetGraph(g *graph.Graph, header *types.Header) {
+	w.state.Store(&state{graph: g, header: header})
+}
+
+// Run watches transactions until the channel is closed or ctx is cancelled,
+// then waits for the impacts being handled
+func (w *Watcher) Run(ctx context.Context, transactions <-chan *types.Transaction) {
+	w.busy = make(chan struct{}, max(w.Handlers, 1))
+	defer w.handled.Wait()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case tx, ok := <-transactions:
+			if !ok {
+				return
+			}
+			w.watch(ctx, tx)
+		}
+	}
+}
+
+func (w *Watcher) watch(ctx context.Context, tx *types.Transaction) {
+	if tx.To() == nil || *tx.To() != w.router {
+		return
+	}
+	current := w.state.Load()
+	if current == nil {
+		return
+	}
+	logger := slog.With("tx", tx.Hash())
+	swap, err := DecodeSwap(tx)
+	if errors.Is(err, ErrNotSwap) {
+		return
+	} else if err != nil {
+		metrics.PendingSwaps.With("invalid").Inc()
+		logger.Debug("Failed to decode pending swap", "err", err)
+		return
+	}
+	impact, err := Simulate(current.graph, current.header, swap)
+	if err != nil {
+		metrics.PendingSwaps.With("untracked").Inc()
+		logger.Debug("Skipping pending swap", "method", swap.Method, "err", err)
+		return
+	}
+	if impact.Reverts {
+		metrics.PendingSwaps.With("reverts").Inc()
+		logger.Debug("Pending swap would revert", "method", swap.Method)
+		return
+	}
+
+	metrics.PendingSwaps.With("simulated").Inc()
+	for _, move := range impact.Moves {
+		logger.Info("Pending swap moves pool", "method", swap.Method, "pool", move.Pool.GetAddress(),
+			"pair", move.TokenIn.Symbol+"/"+move.TokenOut.Symbol,
+			"in", graph.FormatUnits(move.AmountIn, move.TokenIn.Decimals),
+			"out", graph.FormatUnits(move.AmountOut, move.TokenOut.Decimals),
+			"price_change", move.PriceChange)
+	}
+	if w.handle == nil {
+		return
+	}
+	select {
+	case w.busy <- struct{}{}:
+	default:
+		metrics.PendingSwaps.With("busy").Inc()
+		logger.Debug("Not handling pending swap, every handler is busy", "method", swap.Method)
+		return
+	}
+	w.handled.Add(1)
+	go func() {
+		defer func() {
+			<-w.busy
+			w.handled.Done()
+		}()
+		handleCtx := ctx
+		if w.HandleTimeout > 0 {
+			var cancel context.CancelFunc
+			handleCtx, cancel = context.WithTimeout(ctx, w.HandleTimeout)
+			defer cancel()
+		}
+		w.handle(handleCtx, impact)
+	}()
+}
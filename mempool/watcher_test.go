@@ -0,0 +1,80 @@
+package mempool
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"gethmate/internal/testutil"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestWatcher(t *testing.T) {
+	fmt.Println("TestWatcher")
+	g, market := newTestGraph()
+	impacts := make(chan *Impact, 4)
+	watcher := NewWatcher(RouterV2Address, func(ctx context.Context, impact *Impact) {
+		impacts <- impact
+	})
+	watcher.SetGraph(g, &types.Header{Number: big.NewInt(1)})
+
+	path := []common.Address{market.WETH.ContractAddress, market.DAI.ContractAddress}
+	deadline := big.NewInt(1_700_000_000)
+	elsewhere := common.HexToAddress("0x09")
+	transactions := make(chan *types.Transaction, 4)
+	transactions <- types.NewTx(&types.DynamicFeeTx{To: &elsewhere, Data: newRouterTx(nil, "swapExactTokensForTokens", testutil.Ether(1), big.NewInt(0), path, elsewhere, deadline).Data()})
+	transactions <- newRouterTx(nil, "swapExactTokensForTokens", testutil.Ether(1), testutil.Ether(3_000), path, elsewhere, deadline) // Reverts
+	swapTx := newRouterTx(testutil.Ether(2), "swapExactETHForTokens", big.NewInt(0), path, elsewhere, deadline)
+	transactions <- swapTx
+	close(transactions)
+	watcher.Run(context.Background(), transactions)
+
+	// Only the swap through the router that would succeed is reported
+	select {
+	case impact := <-impacts:
+		if impact.Swap.Hash != swapTx.Hash() || len(impact.Moves) != 1 || impact.Moves[0].AmountIn.Cmp(testutil.Ether(2)) != 0 {
+			t.Errorf("Expected the ETH swap's impact, got %+v", impact)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected an impact")
+	}
+	if len(impacts) != 0 {
+		t.Errorf("Expected a single impact, got %d more", len(impacts))
+	}
+}
+
+func TestWatcherBusy(t *testing.T) {
+	fmt.Println("TestWatcherBusy")
+	g, market := newTestGraph()
+	release := make(chan struct{})
+	handled := make(chan common.Hash, 2)
+	watcher := NewWatcher(RouterV2Address, func(ctx context.Context, impact *Impact) {
+		<-release
+		handled <- impact.Swap.Hash
+	})
+	watcher.Handlers = 1
+	watcher.SetGraph(g, &types.Header{Number: big.NewInt(1)})
+
+	// The second swap arrives while the only handler is busy with the first
+	path := []common.Address{market.WETH.ContractAddress, market.DAI.ContractAddress}
+	deadline := big.NewInt(1_700_000_000)
+	first := newRouterTx(testutil.Ether(1), "swapExactETHForTokens", big.NewInt(0), path, RouterV2Address, deadline)
+	second := newRouterTx(testutil.Ether(2), "swapExactETHForTokens", big.NewInt(0), path, RouterV2Address, deadline)
+	transactions := make(chan *types.Transaction, 2)
+	transactions <- first
+	transactions <- second
+	close(transactions)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+	watcher.Run(context.Background(), transactions)
+
+	if len(handled) != 1 || <-handled != first.Hash() {
+		t.Errorf("Expected only the first swap handled")
+	}
+}
@@ -0,0 +1,40 @@
+package metrics
+
+// Default is the registry served by Serve, holding the metrics below
+var Default = NewRegistry()
+
+// Bounds for stage durations in seconds, from a fast refresh of a small graph
+// to a block that overran its slot
+var durationBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+var (
+	HeadLag = Default.NewGauge("gethmate_head_lag_seconds",
+		"Seconds between a block's timestamp and the bot starting to process it")
+	EndpointHeadLag = Default.NewGauge("gethmate_rpc_endpoint_head_lag_blocks",
+		"Blocks an rpc endpoint's head is behind the best head in the pool", "endpoint")
+	StageDuration = Default.NewHistogram("gethmate_stage_duration_seconds",
+		"Time taken by each stage of processing a block", durationBuckets, "stage")
+	Blocks = Default.NewCounter("gethmate_blocks_total",
+		"Blocks by outcome: processed, skipped before starting or abandoned", "outcome")
+
+	RPCCalls = Default.NewCounter("gethmate_rpc_calls_total",
+		"Calls made to rpc endpoints", "method")
+	RPCErrors = Default.NewCounter("gethmate_rpc_errors_total",
+		"Calls to rpc endpoints that failed, including calls retried on another endpoint", "method")
+
+	GraphNodes   = Default.NewGauge("gethmate_graph_nodes", "Tokens in the graph")
+	GraphEdges   = Default.NewGauge("gethmate_graph_edges", "Token pairs in the graph")
+	GraphPools   = Default.NewGauge("gethmate_graph_pools", "Pools in the graph")
+	PoolsSkipped = Default.NewCounter("gethmate_pools_skipped_total",
+		"Pools whose reserves could not be refreshed at a block")
+
+	Opportunities = Default.NewCounter("gethmate_opportunities_total",
+		"Paths found by the strategy, by whether they beat gas and the margin", "profitable")
+	BestProfit = Default.NewGauge("gethmate_block_best_profit_eth",
+		"Net profit after gas of the best path found at the last processed block, 0 if none")
+
+	PendingSwaps = Default.NewCounter("gethmate_pending_swaps_total",
+		"Pending router swaps by outcome: simulated, reverts, untracked or invalid, and busy if simulated but not searched for a backrun", "outcome")
+	Backruns = Default.NewCounter("gethmate_backruns_total",
+		"Backruns of pending swaps found, by whether they beat gas and the margin", "profitable")
+)
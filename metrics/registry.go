@@ -0,0 +1,276 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Registry holds metric families and writes them in the Prometheus text
+// exposition format
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+const (
+	counterType   = "counter"
+	gaugeType     = "gauge"
+	histogramType = "histogram"
+)
+
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64 // Upper bounds, histograms only
+
+	mu     sync.Mutex
+	series map[string]*series // Keyed by label values
+}
+
+// A single time series of a family, identified by its label values
+type series struct {
+	mu     sync.Mutex
+	values []string
+	value  float64  // Counters and gauges
+	counts []uint64 // Observations in each bucket, histograms only
+	sum    float64
+	count  uint64
+}
+
+func (r *Registry) register(name, help, kind string, buckets []float64, labels []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, exists := r.families[name]; exists {
+		if f.kind != kind {
+			panic(fmt.Sprintf("metric %s registered as both %s and %s", name, f.kind, kind))
+		}
+		return f
+	}
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	r.families[name] = f
+	return f
+}
+
+func (f *family) with(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, exists := f.series[key]
+	if !exists {
+		s = &series{values: append([]string(nil), values...)}
+		if f.kind == histogramType {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// CounterVec is a family of counters partitioned by label values
+type CounterVec struct{ f *family }
+
+type Counter struct{ s *series }
+
+func (r *Registry) NewCounter(name, help string, labels ...string) CounterVec {
+	return CounterVec{r.register(name, help, counterType, nil, labels)}
+}
+
+func (v CounterVec) With(values ...string) Counter {
+	return Counter{v.f.with(values)}
+}
+
+func (c Counter) Inc() {
+	c.Add(1)
+}
+
+// Add delta, which must not be negative
+func (c Counter) Add(delta float64) {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	c.s.value += delta
+}
+
+// GaugeVec is a family of gauges partitioned by label values
+type GaugeVec struct{ f *family }
+
+type Gauge struct{ s *series }
+
+func (r *Registry) NewGauge(name, help string, labels ...string) GaugeVec {
+	return GaugeVec{r.register(name, help, gaugeType, nil, labels)}
+}
+
+func (v GaugeVec) With(values ...string) Gauge {
+	return Gauge{v.f.with(values)}
+}
+
+func (g Gauge) Set(value float64) {
+	g.s.mu.Lock()
+	defer g.s.mu.Unlock()
+	g.s.value = value
+}
+
+// HistogramVec is a family of histograms partitioned by label values
+type HistogramVec struct{ f *family }
+
+type Histogram struct {
+	s       *series
+	buckets []float64
+}
+
+// Register a histogram with the given bucket upper bounds, in increasing order
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) HistogramVec {
+	return HistogramVec{r.register(name, help, histogramType, buckets, labels)}
+}
+
+func (v HistogramVec) With(values ...string) Histogram {
+	return Histogram{v.f.with(values), v.f.buckets}
+}
+
+func (h Histogram) Observe(value float64) {
+	h.s.mu.Lock()
+	defer h.s.mu.Unlock()
+	i := sort.SearchFloat64s(h.buckets, value)
+	if i < len(h.buckets) {
+		h.s.counts[i]++
+	}
+	h.s.sum += value
+	h.s.count++
+}
+
+// Write every metric in the Prometheus text exposition format, ordered by
+// name and label values
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+
+	buf := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(buf)
+	}
+	return buf.Flush()
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.mu.Lock()
+	all := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		all = append(all, s)
+	}
+	f.mu.Unlock()
+	if len(all) == 0 {
+		return
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return strings.Join(all[i].values, "\xff") < strings.Join(all[j].values, "\xff")
+	})
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, strings.ReplaceAll(f.help, "\n", " "))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+	for _, s := range all {
+		s.mu.Lock()
+		labels := formatLabels(f.labels, s.values, "")
+		if f.kind != histogramType {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, labels, formatValue(s.value))
+			s.mu.Unlock()
+			continue
+		}
+		cumulative := uint64(0)
+		for i, bound := range f.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.values, formatValue(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.values, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labels, formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, labels, s.count)
+		s.mu.Unlock()
+	}
+}
+
+// Format label pairs, with an le label for histogram buckets if le is set
+func formatLabels(names, values []string, le string) string {
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+labelEscaper.Replace(values[i])+`"`)
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := r.Write(w); err != nil {
+			slog.Warn("Failed to write metrics", "err", err)
+		}
+	})
+}
+
+// Serve the default registry at /metrics on addr until ctx is cancelled
+func Serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Default.Handler())
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
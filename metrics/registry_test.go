@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryExposition(t *testing.T) {
+	fmt.Println("TestRegistryExposition")
+	registry := NewRegistry()
+	calls := registry.NewCounter("test_calls_total", "Calls made", "method")
+	nodes := registry.NewGauge("test_nodes", "Nodes in the graph")
+	durations := registry.NewHistogram("test_duration_seconds", "Stage durations", []float64{0.1, 1}, "stage")
+
+	calls.With("eth_call").Inc()
+	calls.With("eth_call").Add(2)
+	calls.With(`say "hi"`).Inc()
+	nodes.With().Set(42)
+	durations.With("refresh").Observe(0.05)
+	durations.With("refresh").Observe(0.5)
+	durations.With("refresh").Observe(5)
+	registry.NewCounter("test_unused_total", "Never incremented")
+
+	server := httptest.NewServer(registry.Handler())
+	defer server.Close()
+	response, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to scrape: %v", err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+
+	expected := `# HELP test_calls_total Calls made
+# TYPE test_calls_total counter
+test_calls_total{method="eth_call"} 3
+test_calls_total{method="say \"hi\""} 1
+# HELP test_duration_seconds Stage durations
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{stage="refresh",le="0.1"} 1
+test_duration_seconds_bucket{stage="refresh",le="1"} 2
+test_duration_seconds_bucket{stage="refresh",le="+Inf"} 3
+test_duration_seconds_sum{stage="refresh"} 5.55
+test_duration_seconds_count{stage="refresh"} 3
+# HELP test_nodes Nodes in the graph
+# TYPE test_nodes gauge
+test_nodes 42
+`
+	if string(body) != expected {
+		t.Errorf("Unexpected exposition:\n%s", body)
+	}
+	if !strings.HasPrefix(response.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("Expected text/plain content type, got %s", response.Header.Get("Content-Type"))
+	}
+}
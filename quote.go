@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"gethmate/eth"
+	"gethmate/graph"
+	"gethmate/rpcpool"
+	"gethmate/workers"
+)
+
+// Quote a trade along a path of tokens: gethmate quote -path WETH,USDC,DAI,WETH -amount 1.5
+func runQuote(args []string) {
+	flags := flag.NewFlagSet("quote", flag.ExitOnError)
+	pathFlag := flags.String("path", "", "Comma separated token symbols or addresses to trade through, in order")
+	amount := flags.String("amount", "1", "Amount of the first token to trade, in whole tokens")
+	poolsFlag := flags.String("pools", "", "Comma separated pool address for each hop. An empty entry uses the best pool")
+	snapshotFile := flags.String("snapshot", "graph_snapshot.json", "Graph snapshot to quote against")
+	live := flags.Bool("live", false, "Refresh the pools on the path at the latest block before quoting")
+	rpcURLs := flags.String("rpc", "http://localhost:8545", "Comma separated node endpoints, used with -live")
+	callTimeout := flags.Duration("call-timeout", 10*time.Second, "Timeout for a single node call, used with -live")
+	flags.Parse(args)
+
+	if *pathFlag == "" {
+		log.Fatalf("A path is required, e.g. -path WETH,USDC,DAI,WETH")
+	}
+	g, err := graph.LoadSnapshot(*snapshotFile)
+	if err != nil {
+		log.Fatalf("Failed to load graph snapshot: %v", err)
+	}
+
+	symbols := strings.Split(*pathFlag, ",")
+	path := make([]*graph.Node, len(symbols))
+	for i, symbol := range symbols {
+		if path[i], err = g.ResolveToken(strings.TrimSpace(symbol)); err != nil {
+			log.Fatalf("Failed to resolve path: %v", err)
+		}
+	}
+	var pools []eth.Pool
+	if *poolsFlag != "" {
+		addresses := strings.Split(*poolsFlag, ",")
+		pools = make([]eth.Pool, len(addresses))
+		for i, address := range addresses {
+			if address = strings.TrimSpace(address); address == "" {
+				continue
+			}
+			if pools[i] = g.GetPool(address); pools[i] == nil {
+				log.Fatalf("Pool %s not in graph", address)
+			}
+		}
+	}
+
+	if *live {
+		g, path, pools, err = refreshPath(g, path, pools, strings.Split(*rpcURLs, ","), *callTimeout)
+		if err != nil {
+			log.Fatalf("Failed to refresh pools: %v", err)
+		}
+	}
+
+	amountIn, ok := new(big.Float).SetString(*amount)
+	if !ok || amountIn.Sign() <= 0 || amountIn.IsInf() {
+		log.Fatalf("Invalid amount %s", *amount)
+	}
+	quote, err := g.QuotePath(path, pools, graph.ToUnits(amountIn, path[0].Token.Decimals))
+	if err != nil {
+		log.Fatalf("Failed to quote path: %v", err)
+	}
+	printQuote(quote)
+}
+
+// Copy the pools that could be used by the path into a new graph and
+// refresh them at the latest block, returning the new graph with the path and
+// pools resolved in it
+func refreshPath(g *graph.Graph, path []*graph.Node, pools []eth.Pool, urls []string, callTimeout time.Duration) (*graph.Graph, []*graph.Node, []eth.Pool, error) {
+	ctx, stop := context.WithTimeout(context.Background(), time.Minute)
+	defer stop()
+	rpc, err := rpcpool.Dial(ctx, urls)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer rpc.Close()
+	header, err := rpc.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	sub := graph.NewGraph()
+	for i := 0; i+1 < len(path); i++ {
+		for _, pool := range g.PoolsBetween(path[i], path[i+1]) {
+			sub.AddPool(pool)
+		}
+	}
+	workerPool := workers.New(8, 0)
+	defer workerPool.Close()
+	if err := sub.UpdateAllEdges(ctx, eth.WithCallTimeout(rpc, callTimeout), workerPool, header); err != nil {
+		return nil, nil, nil, err
+	}
+	fmt.Printf("Refreshed %d pools at block %s\n", sub.Stats().Pools, header.Number)
+
+	subPath := make([]*graph.Node, len(path))
+	for i, node := range path {
+		subPath[i] = sub.GetNode(node.Token.ContractAddress.String())
+	}
+	var subPools []eth.Pool
+	if pools != nil {
+		subPools = make([]eth.Pool, len(pools))
+		for i, pool := range pools {
+			if pool != nil {
+				subPools[i] = sub.GetPool(pool.GetAddress().String())
+			}
+		}
+	}
+	return sub, subPath, subPools, nil
+}
+
+func printQuote(quote *graph.Quote) {
+	fmt.Printf("%-4s %-42s %-14s %-10s %24s %24s %14s %8s\n", "HOP", "POOL", "TYPE", "PAIR", "IN", "OUT", "PRICE", "IMPACT")
+	for i, hop := range quote.Hops {
+		fmt.Printf("%-4d %-42s %-14s %-10s %24s %24s %14s %7.3f%%\n",
+			i+1,
+			hop.Pool.GetAddress(),
+			eth.GetPoolType(hop.Pool),
+			hop.TokenIn.Symbol+"/"+hop.TokenOut.Symbol,
+			graph.FormatUnits(hop.AmountIn, hop.TokenIn.Decimals),
+			graph.FormatUnits(hop.AmountOut, hop.TokenOut.Decimals),
+			formatPrice(hop.ExecutionPrice),
+			hop.PriceImpact*100)
+	}
+	tokenIn, tokenOut := quote.TokenIn(), quote.TokenOut()
+	fmt.Printf("\n%s %s in, %s %s out\n",
+		graph.FormatUnits(quote.AmountIn, tokenIn.Decimals), tokenIn.Symbol,
+		graph.FormatUnits(quote.AmountOut, tokenOut.Decimals), tokenOut.Symbol)
+	if profit, ok := quote.Profit(); ok {
+		fmt.Printf("Net result: %s %s\n", graph.FormatUnits(profit, tokenIn.Decimals), tokenIn.Symbol)
+	}
+	if quote.AmountOut.Sign() == 0 {
+		fmt.Fprintln(os.Stderr, "The path returns nothing: a pool on it has no liquidity")
+	}
+}
+
+func formatPrice(price *big.Float) string {
+	if price == nil {
+		return "-"
+	}
+	return price.Text('g', 8)
+}
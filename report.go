@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"gethmate/graph"
+)
+
+// Summarise the opportunity journal: gethmate report [flags]
+func runReport(args []string) {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	journalFile := flags.String("journal", "opportunities.jsonl", "Opportunity journal to summarise")
+	since := flags.Duration("since", 0, "Only include opportunities found within this long, 0 for all")
+	window := flags.Duration("window", time.Hour, "Length of the time windows to group opportunities by, 0 for one window")
+	top := flags.Int("top", 10, "Number of pairs and tokens to show")
+	flags.Parse(args)
+
+	records, skipped, err := graph.ReadJournal(*journalFile)
+	if err != nil {
+		log.Fatalf("Failed to read journal: %v", err)
+	}
+	if skipped > 0 {
+		log.Printf("Skipped %d malformed lines in %s", skipped, *journalFile)
+	}
+	var start time.Time
+	if *since > 0 {
+		start = time.Now().Add(-*since)
+	}
+	summary := graph.SummariseJournal(records, start, *window)
+
+	fmt.Printf("Opportunities in %s\n", *journalFile)
+	printStatsHeader("")
+	printStats(&summary.Total)
+	fmt.Printf("\nBy pair\n")
+	printStatsHeader("PAIR")
+	for i, stats := range summary.Pairs {
+		if i == *top {
+			break
+		}
+		printStats(stats)
+	}
+	fmt.Printf("\nBy token\n")
+	printStatsHeader("TOKEN")
+	for i, stats := range summary.Tokens {
+		if i == *top {
+			break
+		}
+		printStats(stats)
+	}
+	fmt.Printf("\nBy time window\n")
+	printStatsHeader("WINDOW")
+	for _, stats := range summary.Windows {
+		printStats(stats)
+	}
+}
+
+func printStatsHeader(key string) {
+	fmt.Printf("%-24s %8s %10s %11s %22s %22s\n", key, "FOUND", "PROFITABLE", "INVALIDATED", "NET PROFIT", "BEST")
+}
+
+func printStats(stats *graph.JournalStats) {
+	best := "-"
+	if stats.BestProfit != nil {
+		best = stats.BestProfit.Text('f', 6)
+	}
+	fmt.Printf("%-24s %8d %10d %11d %22s %22s\n",
+		stats.Key, stats.Found, stats.Profitable, stats.Invalidated, stats.NetProfit.Text('f', 6), best)
+}
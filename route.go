@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+
+	"gethmate/graph"
+)
+
+// Find a route between two tokens: gethmate route -in WETH -out DAI -amount 10 -split 10
+func runRoute(args []string) {
+	flags := flag.NewFlagSet("route", flag.ExitOnError)
+	tokenIn := flags.String("in", "", "Symbol or address of the token to sell")
+	tokenOut := flags.String("out", "", "Symbol or address of the token to buy")
+	amount := flags.String("amount", "1", "Amount of the token to sell, in whole tokens")
+	maxHops := flags.Int("max-hops", 3, "Most hops on a route")
+	parts := flags.Int("split", 1, "Split the amount into this many parts across routes. 1 uses a single route")
+	snapshotFile := flags.String("snapshot", "graph_snapshot.json", "Graph snapshot to route through")
+	flags.Parse(args)
+
+	if *tokenIn == "" || *tokenOut == "" {
+		log.Fatalf("Tokens to route between are required, e.g. -in WETH -out DAI")
+	}
+	g, err := graph.LoadSnapshot(*snapshotFile)
+	if err != nil {
+		log.Fatalf("Failed to load graph snapshot: %v", err)
+	}
+	from, err := g.ResolveToken(*tokenIn)
+	if err != nil {
+		log.Fatalf("Failed to resolve token: %v", err)
+	}
+	to, err := g.ResolveToken(*tokenOut)
+	if err != nil {
+		log.Fatalf("Failed to resolve token: %v", err)
+	}
+	amountIn, ok := new(big.Float).SetString(*amount)
+	if !ok || amountIn.Sign() <= 0 || amountIn.IsInf() {
+		log.Fatalf("Invalid amount %s", *amount)
+	}
+	units := graph.ToUnits(amountIn, from.Token.Decimals)
+
+	if *parts <= 1 {
+		quote, err := g.Route(from, to, units, *maxHops)
+		if err != nil {
+			log.Fatalf("Failed to route: %v", err)
+		}
+		printQuote(quote)
+		return
+	}
+	split, err := g.RouteSplit(from, to, units, *maxHops, *parts)
+	if err != nil {
+		log.Fatalf("Failed to route: %v", err)
+	}
+	for i, quote := range split.Routes {
+		fmt.Printf("Route %d of %d\n", i+1, len(split.Routes))
+		printQuote(quote)
+		fmt.Println()
+	}
+	fmt.Printf("Split: %s %s out, best single route: %s %s out\n",
+		graph.FormatUnits(split.AmountOut, to.Token.Decimals), to.Token.Symbol,
+		graph.FormatUnits(split.Single.AmountOut, to.Token.Decimals), to.Token.Symbol)
+}
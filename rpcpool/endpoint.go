@@ -0,0 +1,179 @@
+package rpcpool
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+const (
+	// Weight of the latest sample in the latency and error rate moving averages
+	ewmaAlpha = 0.2
+
+	// Endpoints that fail this many calls in a row are skipped until cooldown
+	maxConsecutiveErrors = 3
+	cooldown             = 30 * time.Second
+
+	// Score penalty per block of head lag, in milliseconds of latency
+	lagPenaltyMs = 250
+)
+
+// Endpoint is a single node connection and its health statistics
+type Endpoint struct {
+	URL string
+
+	// URL with any credentials, path and query removed, safe to log and to
+	// use as a metric label
+	Name string
+
+	mu                sync.Mutex
+	c                 *ethclient.Client
+	latencyMs         float64
+	errorRate         float64
+	consecutiveErrors int
+	unhealthyUntil    time.Time
+	calls             uint64
+	errors            uint64
+	head              uint64
+	bestHead          uint64
+}
+
+// EndpointStats is a point in time view of an endpoint's health
+type EndpointStats struct {
+	URL       string  `json:"url"`
+	Name      string  `json:"name"`
+	LatencyMs float64 `json:"latency_ms"`
+	ErrorRate float64 `json:"error_rate"`
+	HeadLag   uint64  `json:"head_lag"`
+	Calls     uint64  `json:"calls"`
+	Errors    uint64  `json:"errors"`
+	Healthy   bool    `json:"healthy"`
+	Score     float64 `json:"score"`
+}
+
+// Dial the endpoint and make one cheap call, as dialling an http endpoint
+// does not contact the node
+func (e *Endpoint) connect(ctx context.Context) error {
+	client, err := ethclient.DialContext(ctx, e.URL)
+	if err != nil {
+		return err
+	}
+	if _, err := client.ChainID(ctx); err != nil {
+		client.Close()
+		return err
+	}
+	e.mu.Lock()
+	e.c = client
+	e.mu.Unlock()
+	return nil
+}
+
+func (e *Endpoint) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.c != nil {
+		e.c.Close()
+		e.c = nil
+	}
+}
+
+func (e *Endpoint) client() *ethclient.Client {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.c
+}
+
+func (e *Endpoint) pollHead(ctx context.Context) {
+	client := e.client()
+	if client == nil {
+		if err := e.connect(ctx); err != nil {
+			e.record(0, err)
+			return
+		}
+		client = e.client()
+	}
+	start := time.Now()
+	head, err := client.BlockNumber(ctx)
+	e.record(time.Since(start), err)
+	if err == nil {
+		e.mu.Lock()
+		e.head = head
+		e.mu.Unlock()
+	}
+}
+
+func (e *Endpoint) setBestHead(best uint64) {
+	e.mu.Lock()
+	e.bestHead = best
+	e.mu.Unlock()
+}
+
+func (e *Endpoint) record(latency time.Duration, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.calls++
+	sample := 0.0
+	if err != nil {
+		e.errors++
+		e.consecutiveErrors++
+		sample = 1
+		if e.consecutiveErrors >= maxConsecutiveErrors {
+			e.unhealthyUntil = time.Now().Add(cooldown)
+		}
+	} else {
+		e.consecutiveErrors = 0
+		ms := float64(latency) / float64(time.Millisecond)
+		if e.latencyMs == 0 {
+			e.latencyMs = ms
+		} else {
+			e.latencyMs = ewmaAlpha*ms + (1-ewmaAlpha)*e.latencyMs
+		}
+	}
+	e.errorRate = ewmaAlpha*sample + (1-ewmaAlpha)*e.errorRate
+}
+
+func (e *Endpoint) Head() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.head
+}
+
+// Score of the endpoint's health, lower is better
+func (e *Endpoint) Score() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.score()
+}
+
+func (e *Endpoint) score() float64 {
+	score := (1 + e.latencyMs) * (1 + 10*e.errorRate)
+	if e.bestHead > e.head {
+		score += float64(e.bestHead-e.head) * lagPenaltyMs
+	}
+	if time.Now().Before(e.unhealthyUntil) {
+		score += 1e9
+	}
+	return score
+}
+
+func (e *Endpoint) Stats() EndpointStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	lag := uint64(0)
+	if e.bestHead > e.head {
+		lag = e.bestHead - e.head
+	}
+	return EndpointStats{
+		URL:       e.URL,
+		Name:      e.Name,
+		LatencyMs: e.latencyMs,
+		ErrorRate: e.errorRate,
+		HeadLag:   lag,
+		Calls:     e.calls,
+		Errors:    e.errors,
+		Healthy:   e.c != nil && !time.Now().Before(e.unhealthyUntil),
+		Score:     e.score(),
+	}
+}
@@ -0,0 +1,295 @@
+package rpcpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gethmate/chain"
+	"gethmate/metrics"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/ethclient/gethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+var ErrNoEndpoints = errors.New("no healthy rpc endpoints")
+
+// Pool routes calls across several node endpoints, preferring the healthiest
+// one and failing over to the next when an endpoint errors. Health is scored
+// from call latency, error rate and how far the endpoint's head lags the best
+// head seen across the pool.
+type Pool struct {
+	endpoints []*Endpoint
+
+	// How often endpoint heads are polled to measure head lag
+	PollInterval time.Duration
+}
+
+// Dial every endpoint URL. Endpoints that cannot be dialled are kept and
+// retried by the health monitor, but at least one must succeed.
+func Dial(ctx context.Context, urls []string) (*Pool, error) {
+	p := &Pool{
+		PollInterval: 4 * time.Second,
+	}
+	connected := 0
+	for _, rawURL := range urls {
+		rawURL = strings.TrimSpace(rawURL)
+		if rawURL == "" {
+			continue
+		}
+		endpoint := &Endpoint{URL: rawURL, Name: p.endpointName(rawURL)}
+		if err := endpoint.connect(ctx); err != nil {
+			slog.Warn("Failed to connect to rpc endpoint", "endpoint", endpoint.Name, "err", err)
+		} else {
+			connected++
+		}
+		p.endpoints = append(p.endpoints, endpoint)
+	}
+	if connected == 0 {
+		return nil, fmt.Errorf("failed to connect to any of %d rpc endpoints", len(p.endpoints))
+	}
+	p.pollHeads(ctx)
+	return p, nil
+}
+
+// Name an endpoint by its scheme and host alone, as providers commonly put
+// API keys in the path or query. Endpoints on the same host are numbered by
+// their position in the pool to keep names distinct.
+func (p *Pool) endpointName(rawURL string) string {
+	name := "invalid"
+	if parsed, err := url.Parse(rawURL); err == nil && parsed.Host != "" {
+		name = parsed.Scheme + "://" + parsed.Host
+	}
+	for _, endpoint := range p.endpoints {
+		if endpoint.Name == name || strings.HasPrefix(endpoint.Name, name+"#") {
+			return fmt.Sprintf("%s#%d", name, len(p.endpoints))
+		}
+	}
+	return name
+}
+
+func (p *Pool) Close() {
+	for _, endpoint := range p.endpoints {
+		endpoint.close()
+	}
+}
+
+func (p *Pool) Stats() []EndpointStats {
+	stats := make([]EndpointStats, 0, len(p.endpoints))
+	for _, endpoint := range p.endpoints {
+		stats = append(stats, endpoint.Stats())
+	}
+	return stats
+}
+
+// Run polls each endpoint's head until ctx is cancelled, reconnecting
+// endpoints that failed to dial
+func (p *Pool) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.pollHeads(ctx)
+		}
+	}
+}
+
+func (p *Pool) pollHeads(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, endpoint := range p.endpoints {
+		wg.Add(1)
+		go func(endpoint *Endpoint) {
+			defer wg.Done()
+			endpoint.pollHead(ctx)
+		}(endpoint)
+	}
+	wg.Wait()
+
+	best := uint64(0)
+	for _, endpoint := range p.endpoints {
+		if head := endpoint.Head(); head > best {
+			best = head
+		}
+	}
+	for _, endpoint := range p.endpoints {
+		endpoint.setBestHead(best)
+		metrics.EndpointHeadLag.With(endpoint.Name).Set(float64(best - min(best, endpoint.Head())))
+	}
+}
+
+// Endpoints ordered from healthiest to least healthy, excluding those that
+// are not connected
+func (p *Pool) ranked(filter func(*Endpoint) bool) []*Endpoint {
+	ranked := make([]*Endpoint, 0, len(p.endpoints))
+	for _, endpoint := range p.endpoints {
+		if endpoint.client() != nil && (filter == nil || filter(endpoint)) {
+			ranked = append(ranked, endpoint)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Score() < ranked[j].Score()
+	})
+	return ranked
+}
+
+// Run call against the healthiest endpoint, failing over to the next one on
+// transport errors. Errors returned by the node itself (e.g. reverts) are
+// returned immediately, as another endpoint would give the same answer,
+// unless the node is missing the requested block or state.
+func (p *Pool) do(ctx context.Context, method string, call func(*ethclient.Client) error) error {
+	var lastErr error = ErrNoEndpoints
+	for _, endpoint := range p.ranked(nil) {
+		client := endpoint.client()
+		if client == nil {
+			continue
+		}
+		start := time.Now()
+		err := call(client)
+		metrics.RPCCalls.With(method).Inc()
+		if err != nil {
+			metrics.RPCErrors.With(method).Inc()
+		}
+		var rpcErr rpc.Error
+		if err == nil || (errors.As(err, &rpcErr) && !isMissingState(err)) {
+			endpoint.record(time.Since(start), nil)
+			return err
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		endpoint.record(time.Since(start), err)
+		slog.Warn("RPC call failed, failing over", "method", method, "endpoint", endpoint.Name, "err", err)
+		lastErr = err
+	}
+	return lastErr
+}
+
+func (p *Pool) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	var result []byte
+	err := p.do(ctx, "eth_call", func(client *ethclient.Client) error {
+		var err error
+		result, err = client.CallContract(ctx, msg, blockNumber)
+		return err
+	})
+	return result, err
+}
+
+func (p *Pool) CallContractAtHash(ctx context.Context, msg ethereum.CallMsg, blockHash common.Hash) ([]byte, error) {
+	var result []byte
+	err := p.do(ctx, "eth_call", func(client *ethclient.Client) error {
+		var err error
+		result, err = client.CallContractAtHash(ctx, msg, blockHash)
+		return err
+	})
+	return result, err
+}
+
+func (p *Pool) HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error) {
+	var header *types.Header
+	err := p.do(ctx, "eth_getBlockByHash", func(client *ethclient.Client) error {
+		var err error
+		header, err = client.HeaderByHash(ctx, hash)
+		return err
+	})
+	return header, err
+}
+
+// Get the header of a block by number, or of the latest block if number is nil
+func (p *Pool) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	var header *types.Header
+	err := p.do(ctx, "eth_getBlockByNumber", func(client *ethclient.Client) error {
+		var err error
+		header, err = client.HeaderByNumber(ctx, number)
+		return err
+	})
+	return header, err
+}
+
+func (p *Pool) EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64, error) {
+	var gas uint64
+	err := p.do(ctx, "eth_estimateGas", func(client *ethclient.Client) error {
+		var err error
+		gas, err = client.EstimateGas(ctx, msg)
+		return err
+	})
+	return gas, err
+}
+
+// Dial a dedicated websocket connection to the healthiest ws endpoint, for
+// use by chain.HeadSubscriber
+func (p *Pool) HeadDialer() chain.DialFunc {
+	return func(ctx context.Context) (chain.HeadClient, error) {
+		var lastErr error = ErrNoEndpoints
+		for _, endpoint := range p.ranked(isWebsocket) {
+			client, err := ethclient.DialContext(ctx, endpoint.URL)
+			if err == nil {
+				slog.Info("Following heads", "endpoint", endpoint.Name)
+				return client, nil
+			}
+			endpoint.record(0, err)
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+}
+
+// Dial a dedicated websocket connection to the healthiest ws endpoint, for
+// use by chain.PendingSubscriber
+func (p *Pool) PendingDialer() chain.PendingDialFunc {
+	return func(ctx context.Context) (chain.PendingClient, error) {
+		var lastErr error = ErrNoEndpoints
+		for _, endpoint := range p.ranked(isWebsocket) {
+			client, err := rpc.DialContext(ctx, endpoint.URL)
+			if err == nil {
+				slog.Info("Following pending transactions", "endpoint", endpoint.Name)
+				return &pendingClient{rpc: client, geth: gethclient.New(client)}, nil
+			}
+			endpoint.record(0, err)
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+}
+
+// Adapts gethclient to chain.PendingClient
+type pendingClient struct {
+	rpc  *rpc.Client
+	geth *gethclient.Client
+}
+
+func (c *pendingClient) SubscribeFullPendingTransactions(ctx context.Context, ch chan<- *types.Transaction) (ethereum.Subscription, error) {
+	sub, err := c.geth.SubscribeFullPendingTransactions(ctx, ch)
+	if err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+func (c *pendingClient) Close() {
+	c.rpc.Close()
+}
+
+func isWebsocket(endpoint *Endpoint) bool {
+	return strings.HasPrefix(endpoint.URL, "ws://") || strings.HasPrefix(endpoint.URL, "wss://")
+}
+
+// A lagging or pruned node reports a block or state it does not have as an
+// rpc error, but another endpoint may be able to serve it
+func isMissingState(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "not found") || strings.Contains(msg, "missing trie node")
+}
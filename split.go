@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+
+	"gethmate/eth"
+	"gethmate/graph"
+)
+
+// Split a trade across the pools of a pair: gethmate split -in WETH -out DAI -amount 100
+func runSplit(args []string) {
+	flags := flag.NewFlagSet("split", flag.ExitOnError)
+	tokenIn := flags.String("in", "", "Symbol or address of the token to sell")
+	tokenOut := flags.String("out", "", "Symbol or address of the token to buy")
+	amount := flags.String("amount", "1", "Amount of the token to sell, in whole tokens")
+	snapshotFile := flags.String("snapshot", "graph_snapshot.json", "Graph snapshot to split against")
+	flags.Parse(args)
+
+	if *tokenIn == "" || *tokenOut == "" {
+		log.Fatalf("A pair is required, e.g. -in WETH -out DAI")
+	}
+	g, err := graph.LoadSnapshot(*snapshotFile)
+	if err != nil {
+		log.Fatalf("Failed to load graph snapshot: %v", err)
+	}
+	from, err := g.ResolveToken(*tokenIn)
+	if err != nil {
+		log.Fatalf("Failed to resolve token: %v", err)
+	}
+	to, err := g.ResolveToken(*tokenOut)
+	if err != nil {
+		log.Fatalf("Failed to resolve token: %v", err)
+	}
+	amountIn, ok := new(big.Float).SetString(*amount)
+	if !ok || amountIn.Sign() <= 0 || amountIn.IsInf() {
+		log.Fatalf("Invalid amount %s", *amount)
+	}
+	split, err := g.SplitAcrossPools(from, to, graph.ToUnits(amountIn, from.Token.Decimals))
+	if err != nil {
+		log.Fatalf("Failed to split: %v", err)
+	}
+
+	fmt.Printf("%-42s %-14s %24s %24s %8s\n", "POOL", "TYPE", "IN", "OUT", "SHARE")
+	for _, allocation := range split.Allocations {
+		share, _ := new(big.Float).Quo(new(big.Float).SetInt(allocation.AmountIn), new(big.Float).SetInt(split.AmountIn)).Float64()
+		fmt.Printf("%-42s %-14s %24s %24s %7.2f%%\n",
+			allocation.Pool.GetAddress(),
+			eth.GetPoolType(allocation.Pool),
+			graph.FormatUnits(allocation.AmountIn, split.TokenIn.Decimals),
+			graph.FormatUnits(allocation.AmountOut, split.TokenOut.Decimals),
+			share*100)
+	}
+	gain := new(big.Int).Sub(split.AmountOut, split.Best.AmountOut)
+	fmt.Printf("\nSplit: %s %s out\nBest single pool %s: %s %s out\nGain from splitting: %s %s\n",
+		graph.FormatUnits(split.AmountOut, split.TokenOut.Decimals), split.TokenOut.Symbol,
+		split.Best.Pool.GetAddress(), graph.FormatUnits(split.Best.AmountOut, split.TokenOut.Decimals), split.TokenOut.Symbol,
+		graph.FormatUnits(gain, split.TokenOut.Decimals), split.TokenOut.Symbol)
+}
@@ -2,20 +2,15 @@ package utils
 
 import (
 	"bufio"
-	"log"
 	"os"
 	"strconv"
+	"strings"
 
 	"golang.org/x/crypto/sha3"
 )
 
-func ConvertHexToInt64(hex string) int64 {
-	hex = hex[2:]
-	dec, err := strconv.ParseInt(hex, 16, 64)
-	if err != nil {
-		log.Fatalf("Utils.go - %v", err)
-	}
-	return dec
+func ConvertHexToInt64(hex string) (int64, error) {
+	return strconv.ParseInt(strings.TrimPrefix(hex, "0x"), 16, 64)
 }
 
 func GetFunctionSelector(signature string) []byte {
@@ -43,3 +38,22 @@ func ReadAddressesFromFile(filename string) ([]string, error) {
 
 	return lines, nil
 }
+
+// Write one address per line, in the format read by ReadAddressesFromFile
+func WriteAddressesToFile(filename string, addresses []string) error {
+	data := make([]byte, 0, len(addresses)*43)
+	for _, address := range addresses {
+		data = append(data, address+"\n"...)
+	}
+	return WriteFileAtomic(filename, data)
+}
+
+// Write data to a temporary file and rename it over filename, so readers
+// never see a partially written file
+func WriteFileAtomic(filename string, data []byte) error {
+	tmp := filename + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
@@ -0,0 +1,113 @@
+package workers
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Pool runs jobs on a fixed number of goroutines fed from a shared queue, so
+// uneven batches keep every worker busy. It also paces requests made on
+// behalf of its jobs so that a shared node is not overwhelmed.
+type Pool struct {
+	jobs    chan func()
+	workers sync.WaitGroup
+	close   sync.Once
+
+	// Minimum time between requests, zero for no limit
+	interval time.Duration
+	mu       sync.Mutex
+	next     time.Time // Earliest time the next request may start
+}
+
+// Create a pool of concurrency workers allowing requestsPerSecond requests
+// through Wait. A requestsPerSecond of zero or less disables rate limiting.
+func New(concurrency int, requestsPerSecond float64) *Pool {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	p := &Pool{jobs: make(chan func())}
+	if requestsPerSecond > 0 {
+		p.interval = time.Duration(float64(time.Second) / requestsPerSecond)
+	}
+	for i := 0; i < concurrency; i++ {
+		p.workers.Add(1)
+		go func() {
+			defer p.workers.Done()
+			for job := range p.jobs {
+				job()
+			}
+		}()
+	}
+	return p
+}
+
+// Run calls fn for every index in [0, n) on the pool's workers and waits for
+// all of them, returning the error for each index. Indices that have not
+// started when ctx is cancelled get ctx's error. fn must not call Run on the
+// same pool, as it would wait for workers that are busy running fn.
+func (p *Pool) Run(ctx context.Context, n int, fn func(ctx context.Context, i int) error) []error {
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		job := func() {
+			defer wg.Done()
+			if err := ctx.Err(); err != nil {
+				errs[i] = err
+				return
+			}
+			errs[i] = fn(ctx, i)
+		}
+		select {
+		case p.jobs <- job:
+		case <-ctx.Done():
+			for j := i; j < n; j++ {
+				errs[j] = ctx.Err()
+				wg.Done()
+			}
+			wg.Wait()
+			return errs
+		}
+	}
+	wg.Wait()
+	return errs
+}
+
+// Wait blocks until the rate limit allows another request, or until ctx is
+// done. Requests are spaced evenly rather than allowed in bursts.
+func (p *Pool) Wait(ctx context.Context) error {
+	if p.interval == 0 {
+		return ctx.Err()
+	}
+	p.mu.Lock()
+	now := time.Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+	at := p.next
+	p.next = p.next.Add(p.interval)
+	p.mu.Unlock()
+
+	delay := time.Until(at)
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stop the workers once queued jobs have finished. Run must not be called
+// after Close.
+func (p *Pool) Close() {
+	p.close.Do(func() {
+		close(p.jobs)
+	})
+	p.workers.Wait()
+}
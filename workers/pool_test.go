@@ -0,0 +1,93 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPoolBoundsConcurrency(t *testing.T) {
+	fmt.Println("TestPoolBoundsConcurrency")
+	pool := New(3, 0)
+	defer pool.Close()
+
+	var running, peak atomic.Int64
+	ran := make([]bool, 50)
+	errs := pool.Run(context.Background(), len(ran), func(ctx context.Context, i int) error {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		running.Add(-1)
+		ran[i] = true
+		if i == 7 {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	for i := range ran {
+		if !ran[i] {
+			t.Errorf("Expected job %d to run", i)
+		}
+		if (errs[i] != nil) != (i == 7) {
+			t.Errorf("Unexpected error for job %d: %v", i, errs[i])
+		}
+	}
+	if peak.Load() > 3 {
+		t.Errorf("Expected at most 3 concurrent jobs, got %d", peak.Load())
+	}
+}
+
+func TestPoolCancellation(t *testing.T) {
+	fmt.Println("TestPoolCancellation")
+	pool := New(1, 0)
+	defer pool.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var ran atomic.Int64
+	errs := pool.Run(ctx, 10, func(ctx context.Context, i int) error {
+		ran.Add(1)
+		cancel()
+		return nil
+	})
+	if ran.Load() != 1 {
+		t.Errorf("Expected 1 job to run before cancellation, got %d", ran.Load())
+	}
+	for i := 1; i < len(errs); i++ {
+		if !errors.Is(errs[i], context.Canceled) {
+			t.Errorf("Expected job %d to be cancelled, got %v", i, errs[i])
+		}
+	}
+}
+
+func TestPoolRateLimit(t *testing.T) {
+	fmt.Println("TestPoolRateLimit")
+	pool := New(4, 100)
+	defer pool.Close()
+
+	// The first request is immediate and the rest are spaced 10ms apart
+	start := time.Now()
+	pool.Run(context.Background(), 11, func(ctx context.Context, i int) error {
+		return pool.Wait(ctx)
+	})
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected 11 requests at 100/s to take at least 100ms, took %s", elapsed)
+	}
+
+	// A cancelled wait returns promptly
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	limited := New(1, 0.01)
+	defer limited.Close()
+	limited.Wait(ctx)
+	if err := limited.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+}